@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/rycus86/ddexec/pkg/audit"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/control"
 	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/dockerclient"
 	"github.com/rycus86/ddexec/pkg/env"
 	"github.com/rycus86/ddexec/pkg/exec"
+	"github.com/rycus86/ddexec/pkg/notify"
 	"github.com/rycus86/ddexec/pkg/parse"
 	"github.com/rycus86/ddexec/pkg/xdgopen"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
@@ -22,6 +34,8 @@ func checkArgs() {
 	if name, err := os.Executable(); err == nil && strings.HasSuffix(name, "/xdg-open") {
 		xdgopen.CheckArgs()
 		return
+	} else if err == nil && strings.HasSuffix(name, "/"+notify.ExecutableName) {
+		return
 	}
 
 	if debug.IsEnabled() {
@@ -34,13 +48,50 @@ func checkArgs() {
 		os.Exit(1)
 	}
 
+	if os.Args[1] == "run" {
+		if len(os.Args) < 3 {
+			fmt.Println("Error: Expected a configuration file to run.")
+			os.Exit(1)
+		}
+
+		os.Args = append(os.Args[:1], os.Args[2:]...) // same as without the command
+	}
+
 	if os.Args[1] == "-v" || os.Args[1] == "--version" {
 		fmt.Println("ddexec version", config.GetVersion(), "( https://github.com/rycus86/ddexec )")
 		os.Exit(0)
 	}
 
 	if os.Args[1] == "-h" || os.Args[1] == "--help" {
-		fmt.Println(`Usage: ./ddexec <config.yml>
+		fmt.Println(`Usage: ./ddexec [run] <config.yml>
+       ./ddexec daemon
+       ./ddexec update [--check] <config.yml> [app...]
+       ./ddexec prune [--dry-run] [--keep N] [config.yml...]
+       ./ddexec audit [--app <name>] [--since <time>] [--until <time>] [--json]
+       ./ddexec bundle export <config.yml> [-o <bundle.tar>]
+       ./ddexec bundle import [--force] <bundle.tar>
+
+Commands:
+
+run                     Start the apps of the configuration (the default), through the daemon when it is running
+daemon                  Serve the control socket for the login session and start the apps of the other invocations,
+                          so that they keep working together (can be socket activated by systemd)
+update                  Refresh the digests in the lock file of apps with pin_digest enabled
+                          and show which ones have newer images available (--check only shows them),
+                          exits with 1 when any of them failed, or with 100 for --check when updates are available
+prune                   Remove images built by ddexec that are no longer referenced by their configuration
+                          or that were superseded by newer builds (keeping the latest N builds, 1 by default)
+audit                   Show the audit log of the control socket requests and the container lifecycle actions,
+                          the times are RFC 3339 timestamps, dates (2006-01-02) or durations before now (1h30m)
+bundle export           Save the images of the apps with the configuration and its lock file into one archive
+bundle import           Load the images from a bundle, verify them and install the configuration into the app search path
+
+Configuration files not found at the given path are looked up in the app search path,
+with or without a .yml or .yaml extension.
+Apps started through the daemon use its environment and output, and don't get the terminal,
+so the interactive ones and the ones with a tty are always started in the current process.
+Inside a container without access to the Docker daemon, apps are started by the ddexec on the host
+through the control socket, when the launching app lists them in its control.launch configuration.
 
 Environment variables supported:
 
@@ -58,9 +109,14 @@ USE_HOST_X11            Use the X11 socket from the host rather than from a shar
 USE_HOST_DBUS           Use the DBus sockets from the host rather than from a shared volume
 USE_HOST                Use the X11 and DBus sockets from the host
 DO_NOT_SHARE_X11        Do not share the X11 socket
+SHARE_WAYLAND           Share the Wayland socket (falls back to X11 when there is no Wayland display)
 DO_NOT_SHARE_DBUS       Do not share the DBus sockets
+FORWARD_NOTIFICATIONS   Forward desktop notifications to the host when DBus is not shared
 DO_NOT_SHARE_SHM        Do not share /dev/shm
-DO_NOT_SHARE_SOUND      Do not share /dev/snd
+DO_NOT_SHARE_SOUND      Do not share sound (PulseAudio, PipeWire or /dev/snd)
+SOUND_BACKEND           Sound backend to share: pulse, pipewire, alsa or auto (default)
+SHARE_SSH_AGENT         Forward the SSH agent from SSH_AUTH_SOCK
+SHARE_GPG_AGENT         Forward the restricted (extra) socket of the gpg-agent (for signing and decrypting)
 DO_NOT_SHARE_VIDEO      Do not share /dev/dri and /dev/video0
 DO_NOT_SHARE_DOCKER     Do not share the Docker Engine API socket
 DO_NOT_SHARE_HOME       Do not share a common HOME folder with the application
@@ -69,6 +125,9 @@ FIX_HOME_ARGS           Fix up the home path in command arguments (replace ${HOM
 YUBIKEY_SUPPORT         Enable YubiKey support in the container (requires privileged mode)
 DDEXEC_UNIQUE_NAMES 	If you want unique container names with a timestamp instead of a counter
 DDEXEC_MAPPING_DIR      Directory to use for storing shared information (xdg-open mappings for example)
+DDEXEC_NO_DAEMON        Start the apps in this process even when the daemon is running
+DDEXEC_APPS_PATH        Colon-separated list of directories to look for configuration files in (default: ~/.config/ddexec/apps)
+DOCKER_HOST             Docker (compatible) daemon to connect to (rootless Docker and Podman sockets are detected otherwise)
 DDEXEC_DEBUG            Print debug messages
 DDEXEC_TIMER            Print code execution timing information`)
 
@@ -84,75 +143,335 @@ DDEXEC_TIMER            Print code execution timing information`)
 func runMain() int {
 	if name, err := os.Executable(); err == nil && strings.HasSuffix(name, "/xdg-open") {
 		os.Exit(xdgopen.Invoke(os.Args[1]))
+	} else if err == nil && strings.HasSuffix(name, "/"+notify.ExecutableName) {
+		return notify.Run(os.Args[1:])
+	}
+
+	if os.Args[1] == "update" {
+		return runUpdate(os.Args[2:])
+	}
+
+	if os.Args[1] == "prune" {
+		return runPrune(os.Args[2:])
+	}
+
+	if os.Args[1] == "bundle" {
+		return runBundle(os.Args[2:])
+	}
+
+	if os.Args[1] == "audit" {
+		return runAudit(os.Args[2:])
+	}
+
+	if os.Args[1] == "daemon" {
+		return runDaemon()
+	}
+
+	if env.IsSet(control.EnvServerSocket) && !dockerclient.IsAvailable() {
+		// nested without access to the Docker daemon, ask the ddexec on the host to start the app
+		return launchOnHost(os.Args[1], os.Args[2:])
+	}
+
+	configPath := parse.FindConfiguration(os.Args[1])
+
+	globalConfig := parse.ParseConfiguration(configPath)
+
+	debug.LogTime("configParsed")
+
+	if env.IsNotSet(control.EnvServerSocket) && env.IsNotSet(control.EnvNoDaemon) && !needsTerminal(globalConfig) {
+		if exitCode, err := control.LaunchInDaemon(configPath, os.Args[2:]); err == nil {
+			return exitCode
+		} else if err != control.ErrNoDaemon && err != control.ErrDifferentEnvironment {
+			fmt.Println("Error: The daemon failed to start", os.Args[1]+":", err)
+			return 1
+		}
+
+		debug.LogTime("daemonNotUsed")
 	}
 
 	if debug.IsEnabled() {
 		fmt.Println("Starting...")
 	}
 
-	control.StartServerIfNecessary()
+	server := control.StartServerIfNecessary()
+	control.SetLauncher(launchApp)
+	control.SetHandlerLookup(xdgopen.IsHandler)
 
 	debug.LogTime("controlServerStarted")
 
-	var closers []func()
-	defer func() {
+	defer runClosers()
+
+	if server != nil {
+		addCloser(shutdownServer(server)) // after the apps have stopped
+	}
+
+	var exitCode int
+
+	for _, item := range exec.Sorted(globalConfig) {
+		prepareConfiguration(item.Name, item.Config)
+
+		code, closer := run(item.Name, configPath, os.Args[2:], item.Config)
+
+		if closer != nil {
+			addCloser(closer)
+		}
+
+		exitCode = code
+	}
+
+	return exitCode
+}
+
+// runDaemon serves the control socket at its stable path and starts the apps for the clients
+// until it is stopped, then stops the apps that are still running
+func runDaemon() int {
+	server, err := control.StartDaemonServer()
+	if err != nil {
+		fmt.Println("Error: Failed to start the daemon:", err)
+		return 1
+	}
+
+	control.SetLauncher(launchApp)
+	control.SetHandlerLookup(xdgopen.IsHandler)
+
+	defer runClosers()
+
+	addCloser(shutdownServer(server)) // after the apps have stopped
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	s := <-signals
+
+	if debug.IsEnabled() {
+		fmt.Println("Stopping the daemon on", s)
+	}
+
+	return 0
+}
+
+// needsTerminal checks whether the apps need the streams of this process, which the daemon can not provide
+func needsTerminal(globalConfig *config.GlobalConfiguration) bool {
+	if env.IsSet("DDEXEC_INTERACTIVE") || env.IsSet("DDEXEC_TTY") || env.IsSet("DDEXEC_IMAGE_ONLY") {
+		return true
+	}
+
+	for _, app := range *globalConfig {
+		if app != nil && (app.StdinOpen || app.Tty) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shutdownServer returns a closer that stops the control server, waiting for the active requests for a while
+func shutdownServer(server *control.Server) func() {
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil && debug.IsEnabled() {
+			fmt.Println("The control server did not stop gracefully:", err)
+		}
+	}
+}
+
+func runClosers() {
+	if debug.IsEnabled() {
+		fmt.Println("Running closers...")
+	}
+
+	closersLock.Lock()
+	toRun := closers
+	closers = nil
+	closersLock.Unlock()
+
+	for _, closer := range toRun {
+		closer()
+
 		if debug.IsEnabled() {
-			fmt.Println("Running closers...")
+			fmt.Println("Ran closer")
 		}
+	}
 
-		for _, closer := range closers {
-			closer()
+	debug.LogTime("runClosers")
+}
 
-			if debug.IsEnabled() {
-				fmt.Println("Ran closer")
-			}
+const serverShutdownTimeout = 5 * time.Second
+
+var (
+	closersLock sync.Mutex
+	closers     []func()
+)
+
+func addCloser(closer func()) {
+	closersLock.Lock()
+	defer closersLock.Unlock()
+
+	closers = append([]func(){closer}, closers...)
+}
+
+// launchApp starts an app from the search path for an app in a container,
+// or from any path for the clients of the daemon, through the control server,
+// stopping it when the client has gone away
+func launchApp(request *control.LaunchRequest, stop <-chan struct{}) (exitCode int, err error) {
+	configPath, ok := request.Name, true
+	if filepath.IsAbs(request.Name) {
+		if _, err := os.Stat(request.Name); err != nil {
+			return -1, control.ErrUnknownApp
 		}
+	} else if configPath, ok = parse.FindInSearchPath(request.Name); !ok {
+		return -1, control.ErrUnknownApp
+	}
 
-		debug.LogTime("runClosers")
+	defer func() {
+		if e := recover(); e != nil {
+			exitCode, err = -1, fmt.Errorf("%v", e)
+		}
 	}()
 
-	globalConfig := parse.ParseConfiguration(os.Args[1])
+	var globalConfig *config.GlobalConfiguration
 
-	debug.LogTime("configParsed")
-
-	var exitCode int
+	inEnvironment(request, func() {
+		globalConfig = parse.ParseConfiguration(configPath)
+	})
 
 	for _, item := range exec.Sorted(globalConfig) {
-		code, closer := run(item.Name, item.Config)
+		var (
+			sc     *config.StartupConfiguration
+			ch     chan int
+			closer func()
+		)
 
-		if closer != nil {
-			closers = append([]func(){closer}, closers...)
+		inEnvironment(request, func() {
+			prepareConfiguration(item.Name, item.Config)
+
+			// the streams of the terminal belong to the app started first
+			item.Config.StdinOpen = false
+			item.Config.Tty = false
+
+			sc, ch, closer = start(item.Name, configPath, request.Args, item.Config)
+		})
+
+		if ch == nil {
+			exitCode = 0 // only the image was prepared
+			continue
 		}
 
-		exitCode = code
+		exitCode = wait(item.Name, sc, ch, closer, stop)
+
+		if sc.DaemonMode {
+			addCloser(closer)
+		} else {
+			closer() // it has exited already
+		}
+	}
+
+	return exitCode, nil
+}
+
+// startupLock makes the apps start one at a time, as they may use the environment of their clients meanwhile
+var startupLock sync.Mutex
+
+// inEnvironment calls the function with the working directory and the environment of the client of the daemon
+// when the request has them, restoring the ones of the daemon afterwards
+func inEnvironment(request *control.LaunchRequest, fn func()) {
+	startupLock.Lock()
+	defer startupLock.Unlock()
+
+	if request.Env == nil {
+		fn()
+		return
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+
+	environment := os.Environ()
+
+	defer func() {
+		os.Chdir(dir)
+		setEnvironment(environment)
+	}()
+
+	if request.Dir != "" {
+		if err := os.Chdir(request.Dir); err != nil {
+			panic(err)
+		}
+	}
+
+	setEnvironment(request.Env)
+
+	fn()
+}
+
+func setEnvironment(environment []string) {
+	os.Clearenv()
+
+	for _, item := range environment {
+		if idx := strings.Index(item, "="); idx > 0 {
+			os.Setenv(item[:idx], item[idx+1:])
+		}
+	}
+}
+
+func launchOnHost(name string, args []string) int {
+	if debug.IsEnabled() {
+		fmt.Println("Launching", name, "through the control socket")
+	}
+
+	exitCode, err := control.Launch(name, args)
+	if err != nil {
+		fmt.Println("Error: Failed to launch", name+":", err)
+		return 1
 	}
 
 	return exitCode
 }
 
-func run(name string, configuration *config.AppConfiguration) (int, func()) {
+// run starts the app and waits for it unless it runs in daemon mode
+func run(name string, configPath string, args []string, configuration *config.AppConfiguration) (int, func()) {
+	sc, ch, closer := start(name, configPath, args, configuration)
+	if ch == nil {
+		return 0, nil
+	}
+
+	return wait(name, sc, ch, closer, nil), closer
+}
+
+func start(name string, configPath string, args []string, configuration *config.AppConfiguration) (*config.StartupConfiguration, chan int, func()) {
 	if debug.IsEnabled() {
 		fmt.Println("Starting", name, "...")
 	}
 
-	prepareConfiguration(name, configuration)
-
 	debug.LogTime("prepareConfig")
 
-	sc := getStartupConfiguration(configuration)
+	sc := getStartupConfiguration(configuration, configPath, args)
 
 	debug.LogTime("startupConfig")
 
 	ch, closer := exec.Run(configuration, sc)
 	if ch == nil {
-		return 0, nil
+		return sc, nil, nil
 	}
 
 	if debug.IsEnabled() {
 		fmt.Println("Started", name)
 	}
 
-	var waitCh = make(chan int, 1)
+	return sc, ch, closer
+}
+
+// wait returns the exit code of the app, or right away in daemon mode,
+// stopping it early when the optional stop channel is closed
+func wait(name string, sc *config.StartupConfiguration, ch chan int, closer func(), stop <-chan struct{}) int {
+	var (
+		waitCh = make(chan int, 1)
+		exited = make(chan struct{})
+	)
 
 	go func() {
 		if sc.DaemonMode {
@@ -160,6 +479,7 @@ func run(name string, configuration *config.AppConfiguration) (int, func()) {
 		}
 
 		exitCode := <-ch
+		close(exited)
 
 		if debug.IsEnabled() {
 			fmt.Println(name, "has exited with code", exitCode)
@@ -170,7 +490,22 @@ func run(name string, configuration *config.AppConfiguration) (int, func()) {
 		}
 	}()
 
-	return <-waitCh, closer
+	if stop != nil && !sc.DaemonMode {
+		go func() {
+			select {
+			case <-stop:
+				if debug.IsEnabled() {
+					fmt.Println("Stopping", name, "as its client has gone away")
+				}
+
+				closer()
+
+			case <-exited:
+			}
+		}()
+	}
+
+	return <-waitCh
 }
 
 func prepareConfiguration(name string, c *config.AppConfiguration) {
@@ -187,9 +522,7 @@ func prepareConfiguration(name string, c *config.AppConfiguration) {
 	}
 }
 
-func getStartupConfiguration(c *config.AppConfiguration) *config.StartupConfiguration {
-	args := os.Args[2:]
-
+func getStartupConfiguration(c *config.AppConfiguration, configPath string, args []string) *config.StartupConfiguration {
 	sc := c.StartupConfiguration
 	if sc == nil {
 		sc = &config.StartupConfiguration{
@@ -216,6 +549,14 @@ func getStartupConfiguration(c *config.AppConfiguration) *config.StartupConfigur
 	sc.UseHostDBus = sc.UseHostDBus || env.IsSet("USE_HOST_DBUS") || env.IsSet("USE_HOST")
 	sc.FixHomeArgs = sc.FixHomeArgs || env.IsSet("FIX_HOME_ARGS")
 	sc.YubiKeySupport = sc.YubiKeySupport || env.IsSet("YUBIKEY_SUPPORT")
+	sc.ShareWayland = sc.ShareWayland || env.IsSet("SHARE_WAYLAND")
+	sc.ForwardNotifications = sc.ForwardNotifications || env.IsSet("FORWARD_NOTIFICATIONS")
+	sc.SshAgent = sc.SshAgent || env.IsSet("SHARE_SSH_AGENT")
+	sc.GpgAgent = sc.GpgAgent || env.IsSet("SHARE_GPG_AGENT")
+
+	if sc.SoundBackend == "" && env.IsSet("SOUND_BACKEND") {
+		sc.SoundBackend = os.Getenv("SOUND_BACKEND")
+	}
 
 	if sc.PasswordFile == "" && env.IsSet("PASSWORD_FILE") {
 		sc.PasswordFile = os.Getenv("PASSWORD_FILE")
@@ -229,9 +570,37 @@ func getStartupConfiguration(c *config.AppConfiguration) *config.StartupConfigur
 
 	sc.Args = args
 
+	sc.ConfigPath = configPath
+
 	return sc
 }
 
+func runUpdate(args []string) int {
+	var (
+		checkOnly bool
+		remaining []string
+	)
+
+	for _, arg := range args {
+		if arg == "--check" {
+			checkOnly = true
+		} else {
+			remaining = append(remaining, arg)
+		}
+	}
+
+	if len(remaining) < 1 {
+		fmt.Println("Error: Expected a configuration file to update.")
+		return 1
+	}
+
+	configPath := parse.FindConfiguration(remaining[0])
+
+	globalConfig := parse.ParseConfiguration(configPath)
+
+	return exec.Update(configPath, globalConfig, remaining[1:], checkOnly)
+}
+
 func setIfUnset(cfg *bool, key string) *bool {
 	if cfg != nil {
 		return cfg
@@ -240,3 +609,182 @@ func setIfUnset(cfg *bool, key string) *bool {
 		return &value
 	}
 }
+
+func runPrune(args []string) int {
+	var (
+		dryRun      bool
+		keep        = 1
+		configPaths []string
+	)
+
+	for idx := 0; idx < len(args); idx++ {
+		switch arg := args[idx]; arg {
+		case "--dry-run":
+			dryRun = true
+
+		case "--keep":
+			if idx+1 >= len(args) {
+				fmt.Println("Error: Expected a number after --keep")
+				return 1
+			}
+
+			if n, err := strconv.Atoi(args[idx+1]); err != nil || n < 0 {
+				fmt.Println("Error: Invalid number for --keep:", args[idx+1])
+				return 1
+			} else {
+				keep = n
+			}
+
+			idx++
+
+		default:
+			configPaths = append(configPaths, arg)
+		}
+	}
+
+	return exec.Prune(configPaths, keep, dryRun)
+}
+
+func runBundle(args []string) int {
+	if len(args) < 1 {
+		fmt.Println("Error: Expected `export` or `import` for bundle")
+		return 1
+	}
+
+	switch args[0] {
+	case "export":
+		var configPath, output string
+
+		for idx := 1; idx < len(args); idx++ {
+			if args[idx] == "-o" || args[idx] == "--output" {
+				if idx+1 >= len(args) {
+					fmt.Println("Error: Expected a filename after", args[idx])
+					return 1
+				}
+
+				output = args[idx+1]
+				idx++
+			} else {
+				configPath = args[idx]
+			}
+		}
+
+		if configPath == "" {
+			fmt.Println("Error: Expected a configuration file to export")
+			return 1
+		}
+
+		configPath = parse.FindConfiguration(configPath)
+
+		if output == "" {
+			output = strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath)) + ".tar"
+		}
+
+		return exec.ExportBundle(configPath, parse.ParseConfiguration(configPath), output)
+
+	case "import":
+		var input string
+		var overwrite bool
+
+		for _, arg := range args[1:] {
+			if arg == "--force" {
+				overwrite = true
+			} else {
+				input = arg
+			}
+		}
+
+		if input == "" {
+			fmt.Println("Error: Expected a bundle file to import")
+			return 1
+		}
+
+		return exec.ImportBundle(input, overwrite)
+
+	default:
+		fmt.Println("Error: Unknown bundle command:", args[0])
+		return 1
+	}
+}
+
+func runAudit(args []string) int {
+	var (
+		filter  audit.Filter
+		asJSON  bool
+		options = map[string]*string{"--app": &filter.App}
+		times   = map[string]*time.Time{"--since": &filter.Since, "--until": &filter.Until}
+	)
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+
+		if arg == "--json" {
+			asJSON = true
+			continue
+		}
+
+		if idx+1 >= len(args) {
+			fmt.Println("Error: Expected a value after", arg)
+			return 1
+		}
+
+		if target, ok := options[arg]; ok {
+			*target = args[idx+1]
+		} else if target, ok := times[arg]; ok {
+			if parsed, err := parseAuditTime(args[idx+1]); err != nil {
+				fmt.Println("Error: Invalid time for", arg+":", args[idx+1])
+				return 1
+			} else {
+				*target = parsed
+			}
+		} else {
+			fmt.Println("Error: Unknown audit option:", arg)
+			return 1
+		}
+
+		idx++
+	}
+
+	entries, err := audit.Read(filter)
+	if err != nil {
+		fmt.Println("Error: Failed to read the audit log:", err)
+		return 1
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			encoder.Encode(&entry)
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, entry := range entries {
+		arguments := ""
+		if entry.Arguments != nil {
+			if data, err := json.Marshal(entry.Arguments); err == nil {
+				arguments = string(data)
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"), entry.App, entry.Action, entry.Result, arguments)
+	}
+
+	return 0
+}
+
+func parseAuditTime(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -3,7 +3,7 @@ module github.com/rycus86/ddexec
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20170929234023-d6e3b3328b78 // indirect
 	github.com/Microsoft/go-winio v0.4.14 // indirect
-	github.com/docker/distribution v2.7.1+incompatible // indirect
+	github.com/docker/distribution v2.7.1+incompatible
 	github.com/docker/docker v0.7.3-0.20190204092823-e7a9a7cdbc8f
 	github.com/docker/go-connections v0.4.0
 	github.com/docker/go-units v0.4.0
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// the actions of the containers started by ddexec
+const (
+	ContainerCreate = "container.create"
+	ContainerStart  = "container.start"
+	ContainerStop   = "container.stop"
+	ContainerExit   = "container.exit"
+
+	// ControlPrefix is followed by the name of the endpoint for the requests on the control socket
+	ControlPrefix = "control."
+
+	ResultOK = "ok"
+)
+
+// Entry is one line of the audit log
+type Entry struct {
+	Time      time.Time   `json:"time"`
+	App       string      `json:"app,omitempty"`
+	Container string      `json:"container,omitempty"`
+	Pid       int         `json:"pid,omitempty"` // of the caller on the control socket
+	Action    string      `json:"action"`
+	Arguments interface{} `json:"arguments,omitempty"`
+	Result    string      `json:"result"`
+}
+
+var lock sync.Mutex
+
+// Path returns the location of the audit log, in the state directory of the user
+func Path() string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+
+	return filepath.Join(stateHome, "ddexec", "audit.log")
+}
+
+// Log appends the entry to the audit log, failures are only reported in debug mode
+// so that they don't stop the apps
+func Log(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		logFailure(err)
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(Path()), 0700); err != nil {
+		logFailure(err)
+		return
+	}
+
+	f, err := os.OpenFile(Path(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logFailure(err)
+		return
+	}
+	defer f.Close()
+
+	// one write for each line, so that concurrent ddexec processes don't mix them up
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logFailure(err)
+	}
+}
+
+func logFailure(err error) {
+	if debug.IsEnabled() {
+		fmt.Println("Failed to write the audit log:", err)
+	}
+}
+
+// Filter selects the entries of an app within a time range, the empty fields match everything
+type Filter struct {
+	App   string
+	Since time.Time
+	Until time.Time
+}
+
+func (f Filter) matches(entry *Entry) bool {
+	if f.App != "" && entry.App != f.App {
+		return false
+	}
+
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Read returns the entries of the audit log matching the filter, in the order they were written
+func Read(filter Filter) ([]Entry, error) {
+	f, err := os.Open(Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // a line cut short by a crash
+		}
+
+		if filter.matches(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadFiltered(t *testing.T) {
+	stateHome, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateHome)
+
+	defer os.Setenv("XDG_STATE_HOME", os.Getenv("XDG_STATE_HOME"))
+	os.Setenv("XDG_STATE_HOME", stateHome)
+
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	Log(Entry{Time: start, App: "browser", Action: ContainerCreate, Result: ResultOK})
+	Log(Entry{Time: start.Add(time.Hour), App: "editor", Action: ContainerStart, Result: ResultOK})
+	Log(Entry{Time: start.Add(2 * time.Hour), App: "browser", Action: ControlPrefix + "mkdir",
+		Arguments: map[string]string{"Path": "/tmp"}, Result: "forbidden"})
+
+	if fi, err := os.Stat(Path()); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Error("unexpected permissions:", fi.Mode())
+	}
+
+	for _, item := range []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{}, []string{ContainerCreate, ContainerStart, ControlPrefix + "mkdir"}},
+		{Filter{App: "browser"}, []string{ContainerCreate, ControlPrefix + "mkdir"}},
+		{Filter{Since: start.Add(30 * time.Minute)}, []string{ContainerStart, ControlPrefix + "mkdir"}},
+		{Filter{App: "browser", Until: start.Add(time.Hour)}, []string{ContainerCreate}},
+	} {
+		entries, err := Read(item.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != len(item.expected) {
+			t.Errorf("unexpected entries for %+v: %+v", item.filter, entries)
+			continue
+		}
+
+		for idx, entry := range entries {
+			if entry.Action != item.expected[idx] {
+				t.Errorf("unexpected entry for %+v: %+v", item.filter, entry)
+			}
+		}
+	}
+}
@@ -22,26 +22,88 @@ type StartupConfiguration struct {
 	FixHomeArgs    bool `yaml:"fix_home_args"`
 	YubiKeySupport bool `yaml:"yubikey_support"`
 	DaemonMode     bool `yaml:"daemon"`
+	PinDigest      bool `yaml:"pin_digest"`
+	ShareWayland   bool `yaml:"share_wayland"`
+
+	ForwardNotifications bool `yaml:"forward_notifications"`
+	SshAgent             bool `yaml:"ssh_agent"`
+	GpgAgent             bool `yaml:"gpg_agent"`
+
+	ClipboardConfirm bool `yaml:"clipboard_confirm"`
 
 	PasswordFile string `yaml:"password_file"`
+	SoundBackend string `yaml:"sound_backend"`
+	X11Trust     string `yaml:"x11_trust"`
+	X11Timeout   int    `yaml:"x11_timeout"`
+	Display      string `yaml:"display"`
+	Clipboard    string `yaml:"clipboard"` // read, write, both or none (the default), for nested displays only
+
+	NestedDisplay *NestedDisplayConfiguration `yaml:"nested_display"`
+	DBus          *DBusConfiguration          `yaml:"dbus"`
+	Control       *ControlConfiguration       `yaml:"control"`
 
 	Hostnames       []string          `yaml:"hostnames"`
+	RuntimeSockets  []string          `yaml:"runtime_sockets"` // relative to the runtime directory
 	XdgOpenMappings map[string]string `yaml:"xdg_open"`
+	XdgOpenAuto     *bool             `yaml:"xdg_open_auto"` // register the handlers of the .desktop files (true by default)
 
 	XorgLogs string `yaml:"-"`
 
+	NestedDisplaySocketDir string `yaml:"-"`
+	DBusProxySocketDir     string `yaml:"-"`
+	SecretsDir             string `yaml:"-"`
+	ControlToken           string `yaml:"-"`
+
+	XdgOpenAutoMappings map[string]string `yaml:"-"`
+
 	Args []string `yaml:"-"`
 
+	ConfigPath string `yaml:"-"`
+
 	EnvPath   string `yaml:"-"`
 	ImageID   string `yaml:"-"`
 	ImageUser string `yaml:"-"`
 	ImageHome string `yaml:"-"`
 
+	ImageUID          int  `yaml:"-"`
+	ImageGID          int  `yaml:"-"`
+	ImageUserResolved bool `yaml:"-"` // the numeric IDs of the image user are known (for keep_user)
+
+	ImageEntrypoint []string `yaml:"-"`
+	ImageCmd        []string `yaml:"-"`
+
 	DaemonHasSeccompSupport bool `yaml:"-"`
+	DaemonIsRootless        bool `yaml:"-"`
+	DaemonIsPodman          bool `yaml:"-"`
 	StdInIsTerminal         bool `yaml:"-"`
 	StdOutIsTerminal        bool `yaml:"-"`
 }
 
+type NestedDisplayConfiguration struct {
+	Server string // xephyr, xpra or xvfb
+	Size   string
+	Dpi    int
+	Image  string
+}
+
+// DBusConfiguration lists the names the app can see, talk to or own on the session bus,
+// like `org.freedesktop.Notifications` or `org.example.*`
+type DBusConfiguration struct {
+	See  []string
+	Talk []string
+	Own  []string
+}
+
+// ControlConfiguration lists what the app may do through the control socket of ddexec
+type ControlConfiguration struct {
+	Mkdir       *bool    // true by default
+	CheckDevice *bool    `yaml:"check_device"` // true by default
+	Notify      *bool    // true by default
+	XdgOpen     *bool    `yaml:"xdg_open"`    // run the xdg-open handlers registered by other apps (true by default)
+	RunCommand  []string `yaml:"run_command"` // app names, or * for any app started by ddexec
+	Launch      []string // configuration names from the search path, or * for any of them
+}
+
 func (sc *StartupConfiguration) IsSet(cfg *bool) bool {
 	return cfg != nil && *cfg
 }
@@ -90,7 +152,18 @@ type AppConfiguration struct {
 
 	Dockerfile string
 
+	// references to the top-level secrets, like `name` or `{source, target, uid, gid, mode}`
+	Secrets []interface{}
+
 	StartupConfiguration *StartupConfiguration `yaml:"x-startup"`
+
+	SecretDefinitions map[string]SecretDefinition `yaml:"-"`
+}
+
+// SecretDefinition is a top-level secret, read from a file or from an environment variable
+type SecretDefinition struct {
+	File        string
+	Environment string
 }
 
 type GlobalConfiguration map[string]*AppConfiguration
@@ -0,0 +1,141 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/rycus86/ddexec/pkg/audit"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// the largest request body recorded in the audit log
+const maxAuditedBody = 1024 * 1024
+
+type auditKey struct{}
+
+// auditRecord collects the details of a control request the handlers know about
+type auditRecord struct {
+	app       string
+	container string
+	result    string
+}
+
+// auditRecorder captures the outcome of the response
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	error  string
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// audited records every request of the endpoint in the audit log, with the caller and the result
+func audited(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAuditedBody))
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		record := &auditRecord{}
+		recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, record)))
+
+		entry := audit.Entry{
+			App:       record.app,
+			Container: record.container,
+			Action:    audit.ControlPrefix + name,
+			Arguments: auditArguments(name, body),
+			Result:    record.result,
+		}
+
+		if cred, ok := r.Context().Value(peerKey{}).(*syscall.Ucred); ok {
+			entry.Pid = int(cred.Pid)
+		}
+
+		if recorder.status != http.StatusOK {
+			entry.Result = recorder.error
+			if entry.Result == "" {
+				entry.Result = http.StatusText(recorder.status)
+			}
+		} else if exitCode := recorder.Header().Get(exitCodeTrailer); exitCode != "" {
+			entry.Result = "exit code " + exitCode
+		} else if message := recorder.Header().Get(execErrorTrailer); message != "" {
+			entry.Result = message
+		} else if entry.Result == "" {
+			entry.Result = audit.ResultOK
+		}
+
+		audit.Log(entry)
+	}
+}
+
+// auditedFields are the fields of the requests recorded in the audit log, the others may hold secrets,
+// like the tokens, or the environment of the clients of the daemon
+var auditedFields = map[string][]string{
+	"mkdir":       {"Path"},
+	"checkDevice": {"Path"},
+	"runCommand":  {"ContainerId", "Args", "Command"},
+	"exec":        {"ContainerId", "Args", "Command"},
+	"launch":      {"Name", "Args", "Dir"},
+	"notify":      {"AppName"},
+	"issueToken":  {"AppName", "Permissions"},
+	"bindToken":   {"ContainerId"},
+	"revokeToken": {"ContainerId"},
+}
+
+// auditArguments decodes the allowed fields of the request for the log
+func auditArguments(name string, body []byte) interface{} {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil
+	}
+
+	arguments := map[string]interface{}{}
+
+	for key, value := range request {
+		for _, field := range auditedFields[name] {
+			if strings.EqualFold(key, field) { // as the requests are decoded
+				arguments[field] = value
+			}
+		}
+	}
+
+	if len(arguments) == 0 {
+		return nil
+	}
+
+	return arguments
+}
+
+func setAuditIdentity(r *http.Request, identity *Identity) {
+	if record, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		record.app = identity.AppName
+		record.container = identity.ContainerID
+	}
+}
+
+func setAuditExitCode(r *http.Request, exitCode int) {
+	if record, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		record.result = "exit code " + strconv.Itoa(exitCode)
+	}
+}
+
+func setAuditError(w http.ResponseWriter, code, message string) {
+	if recorder, ok := w.(*auditRecorder); ok {
+		recorder.error = code + ": " + message
+	}
+}
@@ -0,0 +1,51 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rycus86/ddexec/pkg/audit"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuditedLeavesOutTheEnvironment(t *testing.T) {
+	stateHome, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateHome)
+
+	defer os.Setenv("XDG_STATE_HOME", os.Getenv("XDG_STATE_HOME"))
+	os.Setenv("XDG_STATE_HOME", stateHome)
+
+	data := new(bytes.Buffer)
+	json.NewEncoder(data).Encode(LaunchRequest{
+		Name: "/home/user/apps/editor.yml",
+		Args: []string{"notes.txt"},
+		Dir:  "/home/user",
+		Env:  []string{"API_KEY=secret-value", "SSH_AUTH_SOCK=/run/user/1000/ssh-agent.sock"},
+	})
+
+	handler := audited("launch", func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, &LaunchResponse{})
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", apiPrefix+"/launch", data))
+
+	logged, err := ioutil.ReadFile(audit.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(logged), "secret-value") || strings.Contains(string(logged), "SSH_AUTH_SOCK") {
+		t.Error("the environment was written to the audit log:", string(logged))
+	}
+
+	if !strings.Contains(string(logged), "editor.yml") || !strings.Contains(string(logged), "notes.txt") {
+		t.Error("the name and the arguments are missing from the audit log:", string(logged))
+	}
+}
@@ -0,0 +1,326 @@
+package control
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	EnvControlToken = "DDEXEC_CONTROL_TOKEN"
+
+	tokenHeader = "X-Ddexec-Token"
+
+	// AnyApp allows running commands in the containers of any app started by ddexec
+	AnyApp = "*"
+)
+
+// Permissions are the actions an app may perform through the control socket
+type Permissions struct {
+	Mkdir       bool
+	CheckDevice bool
+	Notify      bool
+	XdgOpen     bool     // may run the xdg-open handlers registered by the other apps
+	RunCommand  []string // the names of the apps it may run commands in
+	Launch      []string // the names of the apps it may start on the host
+}
+
+// Identity is an app with a token for the control socket, bound to its container once created
+type Identity struct {
+	AppName     string
+	ContainerID string
+	Permissions Permissions
+
+	token   string
+	issuer  *Identity // the app that asked for the token (for nested ddexec)
+	session bool      // a process of the user outside of containers, talking to the daemon
+
+	notifications []uint32 // the IDs of the notifications shown for the app, the ones it may replace
+}
+
+type peerKey struct{}
+
+var (
+	tokensLock sync.Mutex
+	tokens     = map[string]*Identity{}
+)
+
+func newToken() string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+// isLocalServer returns true when the control server runs in this process
+func isLocalServer() bool {
+	return serverSocket != ""
+}
+
+// IssueToken creates a token for the app, asking the control server of the parent ddexec when nested
+func IssueToken(appName string, permissions Permissions) (string, error) {
+	if !isLocalServer() {
+		return requestToken(appName, permissions)
+	}
+
+	return registerToken(appName, permissions, nil), nil
+}
+
+func registerToken(appName string, permissions Permissions, issuer *Identity) string {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if issuer != nil {
+		permissions = permissions.restrictTo(issuer.Permissions)
+
+		// the apps of nested ddexec can't pretend to be the apps of the parent
+		appName = issuer.AppName + "/" + appName
+	}
+
+	token := newToken()
+	tokens[token] = &Identity{AppName: appName, Permissions: permissions, token: token, issuer: issuer}
+	return token
+}
+
+// BindToken binds the token to the container it was created for
+func BindToken(token, containerID string) error {
+	if !isLocalServer() {
+		return requestTokenChange("bindToken", token, containerID)
+	}
+
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if identity, ok := tokens[token]; ok {
+		return bindContainer(identity, containerID)
+	}
+
+	return errors.New("unknown token")
+}
+
+// RevokeToken invalidates the token after its container exited
+func RevokeToken(token string) {
+	if !isLocalServer() {
+		if err := requestTokenChange("revokeToken", token, ""); err != nil && debug.IsEnabled() {
+			fmt.Println("Failed to revoke the control token:", err)
+		}
+		return
+	}
+
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	delete(tokens, token)
+}
+
+func findIdentity(token string) *Identity {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	return tokens[token]
+}
+
+// findIdentityByContainer returns the identity bound to the container, by its full ID
+func findIdentityByContainer(containerID string) *Identity {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if containerID == "" {
+		return nil
+	}
+
+	for _, identity := range tokens {
+		if identity.ContainerID == containerID {
+			return identity
+		}
+	}
+
+	return nil
+}
+
+// CanRunCommandIn checks whether the app may run commands in the container of the target app
+func (p Permissions) CanRunCommandIn(target *Identity) bool {
+	if target == nil {
+		return false // only containers started by ddexec
+	}
+
+	return containsApp(p.RunCommand, target.AppName)
+}
+
+// CanLaunch checks whether the app may start the app with the configuration name
+func (p Permissions) CanLaunch(name string) bool {
+	return containsApp(p.Launch, name)
+}
+
+func containsApp(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == AnyApp || candidate == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restrictTo limits the permissions to the ones of the parent app
+func (p Permissions) restrictTo(parent Permissions) Permissions {
+	return Permissions{
+		Mkdir:       p.Mkdir && parent.Mkdir,
+		CheckDevice: p.CheckDevice && parent.CheckDevice,
+		Notify:      p.Notify && parent.Notify,
+		XdgOpen:     p.XdgOpen && parent.XdgOpen,
+		RunCommand:  restrictApps(p.RunCommand, parent.RunCommand),
+		Launch:      restrictApps(p.Launch, parent.Launch),
+	}
+}
+
+// restrictApps returns the app names allowed by both lists
+func restrictApps(names, parent []string) []string {
+	var restricted []string
+
+	for _, name := range names {
+		for _, allowed := range parent {
+			if allowed == AnyApp || allowed == name {
+				restricted = append(restricted, name)
+				break
+			} else if name == AnyApp {
+				restricted = append(restricted, allowed)
+			}
+		}
+	}
+
+	return restricted
+}
+
+// withPeerCredentials stores the credentials of the process on the other end of the connection
+func withPeerCredentials(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var cred *syscall.Ucred
+
+	raw.Control(func(fd uintptr) {
+		cred, _ = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if cred == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerKey{}, cred)
+}
+
+// verifyPeer checks that the calling process runs in the container the token is bound to,
+// rejecting the callers whose process is not visible to us
+func verifyPeer(r *http.Request, identity *Identity) error {
+	cred, ok := r.Context().Value(peerKey{}).(*syscall.Ucred)
+	if !ok {
+		return errors.New("no peer credentials")
+	}
+
+	if cred.Pid <= 0 {
+		return errors.New("the process of the caller is not visible")
+	}
+
+	cgroups, err := ioutil.ReadFile("/proc/" + strconv.Itoa(int(cred.Pid)) + "/cgroup")
+	if err != nil {
+		return errors.Wrap(err, "failed to check the container of the caller")
+	}
+
+	if !inContainerCgroup(string(cgroups), identity.ContainerID) {
+		return errors.New("the caller does not run in the container of " + identity.AppName)
+	}
+
+	return nil
+}
+
+// inContainerCgroup checks whether any of the cgroup paths (from /proc/<pid>/cgroup) belongs to the container,
+// either as a docker-<id>.scope (or libpod-<id>.scope) unit with the systemd driver, or as /docker/<id> with cgroupfs
+func inContainerCgroup(cgroups, containerID string) bool {
+	if containerID == "" {
+		return false
+	}
+
+	for _, line := range strings.Split(cgroups, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		segments := strings.Split(parts[2], "/")
+
+		for idx, segment := range segments {
+			switch segment {
+			case "docker-" + containerID + ".scope", "libpod-" + containerID + ".scope":
+				return true
+			case containerID:
+				if idx > 0 && (segments[idx-1] == "docker" || segments[idx-1] == "libpod") {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// authenticated resolves the identity of the caller from its token and peer credentials
+func authenticated(handler func(w http.ResponseWriter, r *http.Request, identity *Identity)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := findIdentity(r.Header.Get(tokenHeader))
+		if identity == nil && r.Header.Get(tokenHeader) == "" {
+			identity = sessionIdentity(r)
+		}
+
+		if identity != nil {
+			setAuditIdentity(r, identity)
+		}
+
+		if identity == nil {
+			r.Body.Close()
+			writeError(w, http.StatusUnauthorized, ErrorUnauthorized, "missing or unknown token")
+			return
+		}
+
+		if identity.session {
+			handler(w, r, identity)
+			return
+		}
+
+		if identity.ContainerID == "" {
+			r.Body.Close()
+			writeError(w, http.StatusForbidden, ErrorForbidden, "the token is not bound to a container yet")
+			return
+		}
+
+		if err := verifyPeer(r, identity); err != nil {
+			if debug.IsEnabled() {
+				fmt.Println("Rejected control request for", identity.AppName+":", err)
+			}
+
+			r.Body.Close()
+			writeError(w, http.StatusForbidden, ErrorForbidden, "the caller does not match the token")
+			return
+		}
+
+		handler(w, r, identity)
+	}
+}
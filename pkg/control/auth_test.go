@@ -0,0 +1,175 @@
+package control
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanRunCommandIn(t *testing.T) {
+	browser := &Identity{AppName: "browser"}
+	editor := &Identity{AppName: "editor"}
+
+	p := Permissions{RunCommand: []string{"browser"}}
+
+	if !p.CanRunCommandIn(browser) {
+		t.Error("expected to allow the browser")
+	}
+	if p.CanRunCommandIn(editor) {
+		t.Error("expected to deny the editor")
+	}
+	if p.CanRunCommandIn(nil) {
+		t.Error("expected to deny containers not started by ddexec")
+	}
+
+	if !(Permissions{RunCommand: []string{AnyApp}}).CanRunCommandIn(editor) {
+		t.Error("expected to allow any app")
+	}
+}
+
+func TestRestrictTo(t *testing.T) {
+	parent := Permissions{Mkdir: true, Notify: true, RunCommand: []string{"browser", "editor"}, Launch: []string{"browser"}}
+
+	for _, item := range []struct {
+		child    Permissions
+		expected Permissions
+	}{
+		{
+			child:    Permissions{Mkdir: true, CheckDevice: true, RunCommand: []string{"browser", "terminal"}},
+			expected: Permissions{Mkdir: true, RunCommand: []string{"browser"}},
+		},
+		{
+			child:    Permissions{Notify: true, RunCommand: []string{AnyApp}, Launch: []string{"browser", "terminal"}},
+			expected: Permissions{Notify: true, RunCommand: []string{"browser", "editor"}, Launch: []string{"browser"}},
+		},
+	} {
+		if restricted := item.child.restrictTo(parent); !reflect.DeepEqual(restricted, item.expected) {
+			t.Errorf("unexpected permissions: %+v", restricted)
+		}
+	}
+
+	anyParent := Permissions{RunCommand: []string{AnyApp}}
+	if restricted := (Permissions{RunCommand: []string{"editor"}}).restrictTo(anyParent); !reflect.DeepEqual(restricted.RunCommand, []string{"editor"}) {
+		t.Errorf("unexpected permissions: %+v", restricted)
+	}
+}
+
+func TestCanLaunch(t *testing.T) {
+	p := Permissions{Launch: []string{"browser"}}
+
+	if !p.CanLaunch(AppName("browser.yml")) {
+		t.Error("expected to allow the browser")
+	}
+	if p.CanLaunch("editor") {
+		t.Error("expected to deny the editor")
+	}
+	if (Permissions{}).CanLaunch("browser") {
+		t.Error("expected to deny launching by default")
+	}
+}
+
+func TestBindContainer(t *testing.T) {
+	const (
+		parentID = "aaaa000000000000000000000000000000000000000000000000000000000000"
+		childID  = "bbbb000000000000000000000000000000000000000000000000000000000000"
+		otherID  = "cccc000000000000000000000000000000000000000000000000000000000000"
+	)
+
+	defer func(original func(string) (string, map[string]string, error)) { inspectContainer = original }(inspectContainer)
+
+	parent := &Identity{AppName: "terminal", ContainerID: parentID, token: "parent-token"}
+
+	tokensLock.Lock()
+	tokens[parent.token] = parent
+	tokensLock.Unlock()
+
+	childToken := registerToken("browser", Permissions{}, parent)
+
+	defer func() {
+		tokensLock.Lock()
+		defer tokensLock.Unlock()
+
+		delete(tokens, parent.token)
+		delete(tokens, childToken)
+	}()
+
+	child := findIdentity(childToken)
+	if child.AppName != "terminal/browser" {
+		t.Error("unexpected name for the nested app:", child.AppName)
+	}
+
+	// only the child container was created with the label of its token
+	inspectContainer = func(containerID string) (string, map[string]string, error) {
+		for _, id := range []string{parentID, childID, otherID} {
+			if strings.HasPrefix(id, containerID) {
+				labels := map[string]string{}
+				if id == childID {
+					labels[LabelBinding] = BindingValue(childToken)
+				}
+				return id, labels, nil
+			}
+		}
+		return "", nil, errors.New("no such container")
+	}
+
+	bind := func(containerID string) error {
+		tokensLock.Lock()
+		defer tokensLock.Unlock()
+
+		return bindContainer(child, containerID)
+	}
+
+	for _, containerID := range []string{parentID, otherID, childID[:12], "unknown"} {
+		if err := bind(containerID); err == nil {
+			t.Error("expected to refuse binding to", containerID)
+		}
+	}
+
+	if err := bind(childID); err != nil {
+		t.Error("failed to bind the container:", err)
+	}
+
+	if err := bind(childID); err == nil {
+		t.Error("expected to refuse binding again")
+	}
+
+	if found := findIdentityByContainer(childID[:4]); found != nil {
+		t.Error("expected no identity for a short ID")
+	}
+	if found := findIdentityByContainer(childID); found != child {
+		t.Error("expected the identity for the full ID")
+	}
+}
+
+func TestInContainerCgroup(t *testing.T) {
+	containerID := strings.Repeat("ab", 32)
+	otherID := containerID[:63] + "c"
+
+	for _, cgroups := range []string{
+		"0::/system.slice/docker-" + containerID + ".scope\n",
+		"12:pids:/docker/" + containerID + "\n1:name=systemd:/docker/" + containerID + "\n",
+		"0::/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-" + containerID + ".scope/container\n",
+	} {
+		if !inContainerCgroup(cgroups, containerID) {
+			t.Error("expected the caller to be in the container:", cgroups)
+		}
+	}
+
+	for _, cgroups := range []string{
+		"0::/system.slice/docker-" + otherID + ".scope\n",
+		"0::/user.slice/" + containerID + "-fake.scope\n",
+		"0::/user.slice/app-" + containerID + ".scope\n",
+		"0::/" + containerID + "\n",
+		"12:pids:/docker/" + containerID[:12] + "\n",
+		"0::/\n",
+	} {
+		if inContainerCgroup(cgroups, containerID) {
+			t.Error("expected the caller not to be in the container:", cgroups)
+		}
+	}
+
+	if inContainerCgroup("0::/docker/\n", "") {
+		t.Error("expected no match without a container ID")
+	}
+}
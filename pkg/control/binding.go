@@ -0,0 +1,69 @@
+package control
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/dockerclient"
+)
+
+// LabelBinding marks the containers created for a control token, so that the holder of the token
+// can only bind it to a container it created itself, and not to the containers of other apps
+const LabelBinding = "com.github.rycus86.ddexec.control.binding"
+
+// BindingValue returns the value of the binding label for the token, without revealing the token
+func BindingValue(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// inspectContainer returns the full ID and the labels of the container (replaced in tests)
+var inspectContainer = func(containerID string) (string, map[string]string, error) {
+	cli, err := dockerclient.New()
+	if err != nil {
+		return "", nil, err
+	}
+	defer cli.Close()
+
+	info, err := cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if info.Config == nil {
+		return info.ID, nil, nil
+	}
+
+	return info.ID, info.Config.Labels, nil
+}
+
+// bindContainer binds the identity to the container, which has to be created for its token,
+// and not bound to any other identity yet, expects the tokens to be locked
+func bindContainer(identity *Identity, containerID string) error {
+	if identity.ContainerID != "" {
+		return errors.New("the token is already bound to a container")
+	}
+
+	for _, other := range tokens {
+		if other.ContainerID == containerID {
+			return errors.New("the container is already bound to " + other.AppName)
+		}
+	}
+
+	fullID, labels, err := inspectContainer(containerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to inspect the container")
+	}
+
+	if fullID != containerID {
+		return errors.New("the full ID of the container is required")
+	}
+
+	if labels[LabelBinding] != BindingValue(identity.token) {
+		return errors.New("the container was not created for the token")
+	}
+
+	identity.ContainerID = containerID
+	return nil
+}
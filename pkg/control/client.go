@@ -4,101 +4,183 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
+	"sync"
 )
 
-func MkdirAll(path string) (string, error) {
-	cli := getClient()
+var (
+	negotiateLock sync.Mutex
+	negotiated    bool
+	pathPrefix    string // empty for servers older than the versioned API
+)
 
-	data := new(bytes.Buffer)
-	if err := json.NewEncoder(data).Encode(MakeDirectoryRequest{
-		Path: path,
-	}); err != nil {
+func MkdirAll(path string) (string, error) {
+	decoded := MakeDirectoryResponse{}
+	if err := call("mkdir", MakeDirectoryRequest{Path: path}, &decoded); err != nil {
 		return path, err
 	}
 
-	resp, err := cli.Post("http://control/mkdir", "application/json", data)
+	return decoded.CreatedPath, nil
+}
+
+func CheckDevice(path string) (bool, error) {
+	decoded := CheckDeviceResponse{}
+	if err := call("checkDevice", CheckDeviceRequest{Path: path}, &decoded); err != nil {
+		return false, err
+	}
+
+	return decoded.Exists, nil
+}
+
+func Notify(request NotifyRequest) (uint32, error) {
+	decoded := NotifyResponse{}
+	if err := call("notify", request, &decoded); err != nil {
+		return 0, err
+	}
+
+	return decoded.ID, nil
+}
+
+func requestToken(appName string, permissions Permissions) (string, error) {
+	decoded := IssueTokenResponse{}
+	if err := call("issueToken", IssueTokenRequest{
+		AppName:     appName,
+		Permissions: permissions,
+	}, &decoded); err != nil {
+		return "", err
+	}
+
+	return decoded.Token, nil
+}
+
+func requestTokenChange(endpoint, token, containerId string) error {
+	return call(endpoint, TokenRequest{
+		Token:       token,
+		ContainerId: containerId,
+	}, nil)
+}
+
+// ServerVersion returns the API version of the control server, 0 for servers without the versioned API
+func ServerVersion() (int, error) {
+	resp, err := getClient().Get("http://control" + apiPrefix + "/version")
 	if err != nil {
-		return path, err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return path, err
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return 0, readError(resp)
 	}
 
-	decoded := MakeDirectoryResponse{}
+	decoded := VersionResponse{}
 	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return path, err
+		return 0, err
 	}
 
-	return decoded.CreatedPath, nil
+	return decoded.APIVersion, nil
 }
 
-func CheckDevice(path string) (bool, error) {
-	cli := getClient()
+// getPathPrefix checks once whether the server knows the versioned API
+func getPathPrefix() (string, error) {
+	negotiateLock.Lock()
+	defer negotiateLock.Unlock()
 
-	data := new(bytes.Buffer)
-	if err := json.NewEncoder(data).Encode(CheckDeviceRequest{
-		Path: path,
-	}); err != nil {
-		return false, err
+	if negotiated {
+		return pathPrefix, nil
 	}
 
-	resp, err := cli.Post("http://control/checkDevice", "application/json", data)
+	version, err := ServerVersion()
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return false, err
+	if version > 0 {
+		pathPrefix = apiPrefix
 	}
+	negotiated = true
 
-	decoded := CheckDeviceResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return false, err
-	}
-
-	return decoded.Exists, nil
+	return pathPrefix, nil
 }
 
-func RunCommand(containerId string, command string) (bool, error) {
-	cli := getClient()
+// call posts the request to the endpoint and decodes the response into the target (when not nil)
+func call(endpoint string, request, response interface{}) error {
+	prefix, err := getPathPrefix()
+	if err != nil {
+		return err
+	}
 
 	data := new(bytes.Buffer)
-	if err := json.NewEncoder(data).Encode(RunCommandRequest{
-		ContainerId: containerId,
-		Command:     command,
-	}); err != nil {
-		return false, err
+	if err := json.NewEncoder(data).Encode(request); err != nil {
+		return err
 	}
 
-	resp, err := cli.Post("http://control/runCommand", "application/json", data)
+	resp, err := getClient().Post("http://control"+prefix+"/"+endpoint, "application/json", data)
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, err
+		return readError(resp)
 	}
 
-	decoded := RunCommandResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return false, err
+	if response == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// readError converts a failed response to an APIError, older servers send no details
+func readError(resp *http.Response) error {
+	apiErr := &APIError{Status: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && len(body) > 0 {
+		decoded := ErrorResponse{}
+		if json.Unmarshal(body, &decoded) == nil {
+			apiErr.Code = decoded.Code
+			apiErr.Message = decoded.Message
+		}
 	}
 
-	return decoded.ExitCode == 0, nil // TODO exit code
+	return apiErr
+}
+
+// tokenTransport authenticates the requests with the token of the container
+type tokenTransport struct {
+	http.RoundTripper
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.token == "" {
+		return t.RoundTripper.RoundTrip(r)
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set(tokenHeader, t.token)
+	return t.RoundTripper.RoundTrip(r)
 }
 
 func getClient() *http.Client {
+	return newClient(GetServerSocket(), os.Getenv(EnvControlToken))
+}
+
+func newClient(socket, token string) *http.Client {
 	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", GetServerSocket())
+		Transport: &tokenTransport{
+			RoundTripper: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial("unix", socket)
+				},
 			},
+			token: token,
 		},
 	}
 }
@@ -0,0 +1,32 @@
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeError(recorder, http.StatusConflict, ErrorConflict, "/tmp/x exists")
+
+	err := readError(recorder.Result())
+
+	if apiErr, ok := err.(*APIError); !ok {
+		t.Fatal("Unexpected error type:", err)
+	} else if apiErr.Status != 409 || apiErr.Code != ErrorConflict || apiErr.Message != "/tmp/x exists" {
+		t.Error("Unexpected error:", apiErr)
+	}
+}
+
+func TestReadLegacyError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	recorder.WriteHeader(http.StatusInternalServerError)
+
+	err := readError(recorder.Result())
+
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Error("Unexpected error:", err)
+	}
+}
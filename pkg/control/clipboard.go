@@ -0,0 +1,409 @@
+package control
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/x11"
+	"github.com/rycus86/ddexec/pkg/xauth"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	ClipboardRead  = "read"  // the app can paste what was copied on the host
+	ClipboardWrite = "write" // the host can paste what was copied in the app
+	ClipboardBoth  = "both"
+	ClipboardNone  = "none"
+
+	clipboardFetchTimeout   = 2 * time.Second
+	clipboardConfirmTimeout = 30
+)
+
+// ClipboardBridge synchronizes the CLIPBOARD and PRIMARY selections
+// between the host display and an isolated (nested) display,
+// apps on the host display with an untrusted cookie are not supported, as the selections are shared
+// by all the clients of the same X server, and there is no separate display to bridge them to
+type ClipboardBridge struct {
+	name    string
+	policy  string
+	confirm bool
+
+	host     *clipboardDisplay
+	isolated *clipboardDisplay
+
+	approved  []byte                 // the last contents the user allowed the app to read
+	approvals chan clipboardApproval // the answers of the prompts, handled in the event loop
+	prompting bool
+	waiting   [][]byte // the selection requests of the app waiting for the prompt
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// clipboardApproval is the answer of the user to reading the contents
+type clipboardApproval struct {
+	data    []byte
+	allowed bool
+}
+
+type clipboardDisplay struct {
+	conn   *x11.Conn
+	xfixes *x11.XFixes
+	window uint32
+
+	selections []uint32
+	property   uint32
+	targets    uint32
+	utf8String uint32
+	text       uint32
+
+	// the contents of the selections we currently own on this display
+	owned map[uint32][]byte
+}
+
+// StartClipboardBridge connects to both displays and starts copying the selections
+// between them in the directions allowed by the policy
+func StartClipboardBridge(name, isolatedSocket, policy string, confirm bool) (*ClipboardBridge, error) {
+	switch policy {
+	case ClipboardRead, ClipboardWrite, ClipboardBoth:
+	default:
+		return nil, errors.New("unknown clipboard policy: " + policy)
+	}
+
+	hostCookie, err := xauth.HostCookie(os.Getenv("DISPLAY"))
+	if err != nil {
+		return nil, err
+	}
+
+	hostConn, err := x11.Dial(os.Getenv("DISPLAY"), hostCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := newClipboardDisplay(hostConn)
+	if err != nil {
+		hostConn.Close()
+		return nil, err
+	}
+
+	isolatedConn, err := x11.DialSocket(isolatedSocket, nil)
+	if err != nil {
+		hostConn.Close()
+		return nil, err
+	}
+
+	isolated, err := newClipboardDisplay(isolatedConn)
+	if err != nil {
+		hostConn.Close()
+		isolatedConn.Close()
+		return nil, err
+	}
+
+	b := &ClipboardBridge{
+		name:     name,
+		policy:   policy,
+		confirm:  confirm,
+		host:     host,
+		isolated: isolated,
+		// one prompt at a time, so its answer never blocks
+		approvals: make(chan clipboardApproval, 1),
+		stop:      make(chan struct{}),
+	}
+
+	b.done.Add(1)
+	go b.run()
+
+	return b, nil
+}
+
+// Stop disconnects from the displays
+func (b *ClipboardBridge) Stop() {
+	close(b.stop)
+	b.done.Wait()
+
+	b.host.conn.Close()
+	b.isolated.conn.Close()
+}
+
+func newClipboardDisplay(conn *x11.Conn) (*clipboardDisplay, error) {
+	d := &clipboardDisplay{conn: conn, owned: map[uint32][]byte{}}
+
+	for _, name := range []string{"CLIPBOARD", "PRIMARY"} {
+		if atom, err := conn.InternAtom(name); err != nil {
+			return nil, err
+		} else {
+			d.selections = append(d.selections, atom)
+		}
+	}
+
+	for name, atom := range map[string]*uint32{
+		"DDEXEC_SELECTION": &d.property,
+		"TARGETS":          &d.targets,
+		"UTF8_STRING":      &d.utf8String,
+		"TEXT":             &d.text,
+	} {
+		if value, err := conn.InternAtom(name); err != nil {
+			return nil, err
+		} else {
+			*atom = value
+		}
+	}
+
+	xfixes, err := conn.InitXFixes()
+	if err != nil {
+		return nil, err
+	}
+	d.xfixes = xfixes
+
+	window, err := conn.CreateWindow(x11.PropertyChangeMask)
+	if err != nil {
+		return nil, err
+	}
+	d.window = window
+
+	for _, selection := range d.selections {
+		err := xfixes.SelectSelectionInput(window, selection, x11.XFixesSetSelectionOwnerNotifyMask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (b *ClipboardBridge) run() {
+	defer b.done.Done()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+
+		case event, ok := <-b.host.conn.Events:
+			if !ok {
+				return
+			}
+			b.handleEvent(b.host, b.isolated, event)
+
+		case event, ok := <-b.isolated.conn.Events:
+			if !ok {
+				return
+			}
+			b.handleEvent(b.isolated, b.host, event)
+
+		case approval := <-b.approvals:
+			b.handleApproval(approval)
+		}
+	}
+}
+
+func (b *ClipboardBridge) canCopy(from *clipboardDisplay) bool {
+	if b.policy == ClipboardBoth {
+		return true
+	} else if from == b.host {
+		return b.policy == ClipboardRead
+	} else {
+		return b.policy == ClipboardWrite
+	}
+}
+
+func (b *ClipboardBridge) handleEvent(source, target *clipboardDisplay, event []byte) {
+	switch {
+	case source.xfixes.IsSelectionNotify(event):
+		var (
+			owner     = x11.Uint32At(event, 8)
+			selection = x11.Uint32At(event, 12)
+		)
+
+		if owner == x11.AtomNone || owner == source.window || !b.canCopy(source) {
+			return
+		}
+
+		data, err := source.fetch(selection, b.handleEvent, target)
+		if err != nil {
+			if debug.IsEnabled() {
+				fmt.Println("Failed to read the clipboard of", b.name+":", err)
+			}
+			return
+		}
+
+		target.own(selectionIndex(source, selection), data)
+
+	case x11.EventCode(event) == x11.SelectionRequest:
+		b.answer(source, event)
+
+	case x11.EventCode(event) == x11.SelectionClear:
+		selection := x11.Uint32At(event, 12)
+		delete(source.owned, selection)
+	}
+}
+
+// fetch converts the selection to UTF-8 text into the property of our window,
+// handling the other events of the display while waiting for the owner to respond
+func (d *clipboardDisplay) fetch(selection uint32, handle func(source, target *clipboardDisplay, event []byte), other *clipboardDisplay) ([]byte, error) {
+	if err := d.conn.ConvertSelection(d.window, selection, d.utf8String, d.property); err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(clipboardFetchTimeout)
+
+	for {
+		select {
+		case <-timeout:
+			return nil, errors.New("timed out")
+
+		case event, ok := <-d.conn.Events:
+			if !ok {
+				return nil, errors.New("connection closed")
+			}
+
+			if x11.EventCode(event) != x11.SelectionNotify || x11.Uint32At(event, 12) != selection {
+				handle(d, other, event)
+				continue
+			}
+
+			if x11.Uint32At(event, 20) == x11.AtomNone {
+				return nil, errors.New("the owner refused to convert the selection")
+			}
+
+			_, _, data, err := d.conn.GetProperty(d.window, d.property, true, d.conn.MaxRequestLength-32)
+			return data, err
+		}
+	}
+}
+
+func selectionIndex(d *clipboardDisplay, selection uint32) int {
+	for idx, s := range d.selections {
+		if s == selection {
+			return idx
+		}
+	}
+	return -1
+}
+
+// own takes ownership of the selection on this display, serving the data when requested
+func (d *clipboardDisplay) own(index int, data []byte) {
+	if index < 0 || len(data) > d.conn.MaxRequestLength-32 {
+		return // INCR transfers are not supported
+	}
+
+	selection := d.selections[index]
+
+	if err := d.conn.SetSelectionOwner(d.window, selection); err != nil {
+		return
+	}
+
+	d.owned[selection] = data
+}
+
+func (b *ClipboardBridge) answer(d *clipboardDisplay, event []byte) {
+	var (
+		timestamp = x11.Uint32At(event, 4)
+		requestor = x11.Uint32At(event, 12)
+		selection = x11.Uint32At(event, 16)
+		target    = x11.Uint32At(event, 20)
+		property  = x11.Uint32At(event, 24)
+	)
+
+	if property == x11.AtomNone {
+		property = target // obsolete clients
+	}
+
+	data, owned := d.owned[selection]
+
+	switch {
+	case !owned:
+		property = x11.AtomNone
+
+	case target == d.targets:
+		err := d.conn.ChangeProperty(requestor, property, x11.AtomAtom, 32,
+			x11.EncodeAtoms(d.targets, d.utf8String, x11.AtomString, d.text))
+		if err != nil {
+			property = x11.AtomNone
+		}
+
+	case target == d.utf8String || target == x11.AtomString || target == d.text:
+		if d == b.isolated && b.needsApproval(data) {
+			b.waitForApproval(data, event)
+			return // answered once the user decides
+		} else if err := d.conn.ChangeProperty(requestor, property, target, 8, data); err != nil {
+			property = x11.AtomNone
+		}
+
+	default:
+		property = x11.AtomNone
+	}
+
+	d.conn.SendSelectionNotify(timestamp, requestor, selection, target, property)
+}
+
+// needsApproval checks whether the user has to confirm that the app may read the host clipboard,
+// once for each new contents, when confirmation is enabled
+func (b *ClipboardBridge) needsApproval(data []byte) bool {
+	return b.confirm && (b.approved == nil || string(b.approved) != string(data))
+}
+
+// waitForApproval keeps the selection request until the user answers the prompt,
+// which runs in the background so that the events of the displays are still handled
+func (b *ClipboardBridge) waitForApproval(data []byte, event []byte) {
+	b.waiting = append(b.waiting, append([]byte(nil), event...))
+
+	if b.prompting {
+		return
+	}
+
+	b.prompting = true
+
+	go func() {
+		b.approvals <- clipboardApproval{data: data, allowed: b.prompt()}
+	}()
+}
+
+// handleApproval answers the selection requests waiting for the prompt,
+// the ones for contents changed in the meantime ask again
+func (b *ClipboardBridge) handleApproval(approval clipboardApproval) {
+	b.prompting = false
+
+	if approval.allowed {
+		b.approved = approval.data
+	}
+
+	waiting := b.waiting
+	b.waiting = nil
+
+	for _, event := range waiting {
+		if approval.allowed {
+			b.answer(b.isolated, event)
+		} else {
+			refuse(b.isolated, event)
+		}
+	}
+}
+
+// refuse tells the requestor that the selection can not be converted
+func refuse(d *clipboardDisplay, event []byte) {
+	d.conn.SendSelectionNotify(x11.Uint32At(event, 4), x11.Uint32At(event, 12),
+		x11.Uint32At(event, 16), x11.Uint32At(event, 20), x11.AtomNone)
+}
+
+// prompt asks the user whether the app may paste from the clipboard
+func (b *ClipboardBridge) prompt() bool {
+	message := b.name + " wants to paste from the clipboard. Allow it?"
+
+	var cmd *exec.Cmd
+
+	if path, err := exec.LookPath("zenity"); err == nil {
+		cmd = exec.Command(path, "--question", "--title=ddexec", "--text="+message,
+			fmt.Sprintf("--timeout=%d", clipboardConfirmTimeout))
+	} else if path, err := exec.LookPath("kdialog"); err == nil {
+		cmd = exec.Command(path, "--title", "ddexec", "--yesno", message)
+	} else {
+		fmt.Println("WARNING: Neither zenity nor kdialog found to confirm clipboard access for", b.name)
+		return false
+	}
+
+	return cmd.Run() == nil
+}
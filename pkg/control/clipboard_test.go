@@ -0,0 +1,225 @@
+package control
+
+import (
+	"github.com/rycus86/ddexec/pkg/x11"
+	"github.com/rycus86/ddexec/pkg/x11/x11test"
+	"testing"
+)
+
+const testXFixesOpcode, testXFixesFirstEvent = 138, 87
+
+func newTestClipboardDisplay(t *testing.T) (*x11test.Server, *clipboardDisplay) {
+	server, client := x11test.NewServer()
+	server.Extensions["XFIXES"] = x11test.Extension{Opcode: testXFixesOpcode, FirstEvent: testXFixesFirstEvent}
+
+	conn, err := x11.NewConn(client, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal("failed to connect:", err)
+	}
+
+	d, err := newClipboardDisplay(conn)
+	if err != nil {
+		server.Close()
+		t.Fatal("failed to set up the display:", err)
+	}
+
+	return server, d
+}
+
+// newTestClipboardBridge connects to two fake X servers, without handling their events in the background
+func newTestClipboardBridge(t *testing.T, policy string, confirm bool) (*ClipboardBridge, *x11test.Server, *x11test.Server) {
+	hostServer, host := newTestClipboardDisplay(t)
+	isolatedServer, isolated := newTestClipboardDisplay(t)
+
+	return &ClipboardBridge{
+		name:      "app",
+		policy:    policy,
+		confirm:   confirm,
+		host:      host,
+		isolated:  isolated,
+		approvals: make(chan clipboardApproval, 1),
+		stop:      make(chan struct{}),
+	}, hostServer, isolatedServer
+}
+
+func selectionRequest(requestor, selection, target, property uint32) []byte {
+	event := make([]byte, 32)
+	event[0] = x11.SelectionRequest
+	for offset, value := range map[int]uint32{4: 1234, 12: requestor, 16: selection, 20: target, 24: property} {
+		copy(event[offset:], x11.EncodeAtoms(value))
+	}
+	return event
+}
+
+// expectNotify checks the SelectionNotify event sent to the requestor, returning its property
+func expectNotify(t *testing.T, server *x11test.Server) uint32 {
+	request := server.Request(25) // SendEvent
+	if request == nil {
+		t.Fatal("no SelectionNotify sent")
+	}
+
+	event := request[12:]
+	if x11.EventCode(event) != x11.SelectionNotify {
+		t.Fatal("unexpected event:", event)
+	}
+
+	return x11.Uint32At(event, 20)
+}
+
+func TestClipboardPolicy(t *testing.T) {
+	for policy, expected := range map[string][2]bool{
+		ClipboardRead:  {true, false},
+		ClipboardWrite: {false, true},
+		ClipboardBoth:  {true, true},
+	} {
+		b := &ClipboardBridge{policy: policy, host: &clipboardDisplay{}, isolated: &clipboardDisplay{}}
+
+		if b.canCopy(b.host) != expected[0] {
+			t.Errorf("unexpected copy from the host with %s", policy)
+		}
+		if b.canCopy(b.isolated) != expected[1] {
+			t.Errorf("unexpected copy from the app with %s", policy)
+		}
+	}
+}
+
+func TestClipboardAnswer(t *testing.T) {
+	b, hostServer, isolatedServer := newTestClipboardBridge(t, ClipboardWrite, false)
+	defer hostServer.Close()
+	defer isolatedServer.Close()
+
+	var (
+		d         = b.host
+		requestor = uint32(0x3400001)
+		clipboard = d.selections[0]
+		property  = uint32(500)
+	)
+
+	b.answer(d, selectionRequest(requestor, clipboard, d.utf8String, property))
+
+	if notified := expectNotify(t, hostServer); notified != x11.AtomNone {
+		t.Error("expected to refuse a selection we don't own")
+	}
+
+	d.own(0, []byte("copied in the app"))
+
+	b.answer(d, selectionRequest(requestor, clipboard, d.targets, property))
+
+	if notified := expectNotify(t, hostServer); notified != property {
+		t.Error("expected to store the targets")
+	} else if _, format, data := hostServer.Property(requestor, property); format != 32 ||
+		string(data) != string(x11.EncodeAtoms(d.targets, d.utf8String, x11.AtomString, d.text)) {
+		t.Error("unexpected targets:", data)
+	}
+
+	b.answer(d, selectionRequest(requestor, clipboard, d.utf8String, property))
+
+	if notified := expectNotify(t, hostServer); notified != property {
+		t.Error("expected to store the selection")
+	} else if propertyType, _, data := hostServer.Property(requestor, property); propertyType != d.utf8String ||
+		string(data) != "copied in the app" {
+		t.Errorf("unexpected selection: %q", data)
+	}
+
+	b.answer(d, selectionRequest(requestor, clipboard, 999, property))
+
+	if notified := expectNotify(t, hostServer); notified != x11.AtomNone {
+		t.Error("expected to refuse unknown targets")
+	}
+}
+
+func TestClipboardConfirm(t *testing.T) {
+	b, hostServer, isolatedServer := newTestClipboardBridge(t, ClipboardRead, true)
+	defer hostServer.Close()
+	defer isolatedServer.Close()
+
+	var (
+		d         = b.isolated
+		requestor = uint32(0x3400001)
+		clipboard = d.selections[0]
+		property  = uint32(500)
+		data      = []byte("copied on the host")
+	)
+
+	d.own(0, data)
+
+	b.prompting = true // as if the prompt was already shown
+	b.answer(d, selectionRequest(requestor, clipboard, d.utf8String, property))
+
+	if len(b.waiting) != 1 {
+		t.Fatal("expected the request to wait for the prompt")
+	}
+
+	b.handleApproval(clipboardApproval{data: data, allowed: false})
+
+	if notified := expectNotify(t, isolatedServer); notified != x11.AtomNone {
+		t.Error("expected to refuse the request")
+	}
+
+	b.prompting = true
+	b.answer(d, selectionRequest(requestor, clipboard, d.utf8String, property))
+	b.handleApproval(clipboardApproval{data: data, allowed: true})
+
+	if notified := expectNotify(t, isolatedServer); notified != property {
+		t.Error("expected to store the selection once allowed")
+	} else if _, _, stored := isolatedServer.Property(requestor, property); string(stored) != string(data) {
+		t.Errorf("unexpected selection: %q", stored)
+	}
+
+	b.answer(d, selectionRequest(requestor, clipboard, d.utf8String, property))
+
+	if notified := expectNotify(t, isolatedServer); notified != property {
+		t.Error("expected to store the same contents again without asking")
+	}
+
+	if b.needsApproval(data) || !b.needsApproval([]byte("changed")) {
+		t.Error("expected to ask again only for new contents")
+	}
+}
+
+func TestClipboardCopy(t *testing.T) {
+	b, hostServer, isolatedServer := newTestClipboardBridge(t, ClipboardRead, false)
+	defer hostServer.Close()
+	defer isolatedServer.Close()
+
+	var (
+		owner     = uint32(0x3400001)
+		clipboard = b.host.selections[0]
+	)
+
+	go func() {
+		// the owner of the selection stores its contents, then notifies us
+		request := hostServer.Request(24) // ConvertSelection
+		if request == nil {
+			return
+		}
+
+		var (
+			requestor = x11.Uint32At(request, 4)
+			target    = x11.Uint32At(request, 12)
+			property  = x11.Uint32At(request, 16)
+		)
+
+		hostServer.SetProperty(requestor, property, target, 8, []byte("copied on the host"))
+
+		event := make([]byte, 32)
+		event[0] = x11.SelectionNotify
+		copy(event[8:], x11.EncodeAtoms(requestor, clipboard, target, property))
+		hostServer.Write(event)
+	}()
+
+	notify := make([]byte, 32)
+	notify[0] = testXFixesFirstEvent
+	copy(notify[8:], x11.EncodeAtoms(owner, clipboard))
+
+	b.handleEvent(b.host, b.isolated, notify)
+
+	if data := b.isolated.owned[b.isolated.selections[0]]; string(data) != "copied on the host" {
+		t.Errorf("expected the app to own the copied contents, got %q", data)
+	}
+
+	if request := isolatedServer.Request(22); request == nil || x11.Uint32At(request, 4) != b.isolated.window {
+		t.Error("expected to own the selection on the nested display")
+	}
+}
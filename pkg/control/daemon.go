@@ -0,0 +1,275 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/env"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	EnvNoDaemon = "DDEXEC_NO_DAEMON"
+
+	// the first file descriptor passed by systemd socket activation
+	listenFdsStart = 3
+)
+
+var (
+	// ErrNoDaemon is returned when the session daemon is not running
+	ErrNoDaemon = errors.New("the ddexec daemon is not running")
+
+	// ErrDifferentEnvironment is returned when the app would see a different environment in the daemon
+	ErrDifferentEnvironment = errors.New("the environment differs from the one of the ddexec daemon")
+)
+
+var (
+	// only the session daemon accepts requests from the processes of the user outside of containers
+	acceptSessionClients bool
+
+	sessionClient = &Identity{
+		AppName:     "session",
+		Permissions: Permissions{Launch: []string{AnyApp}},
+		session:     true,
+	}
+
+	// the environment variables ddexec reads after the apps have started, in the control server
+	// or when the X authority changes, so these can't differ between the daemon and its clients
+	sharedVariables = []string{"HOME", "USER", "DISPLAY", "XAUTHORITY", "XAUTH", "XDG_RUNTIME_DIR", EnvHome, "DOCKER_HOST"}
+)
+
+// DaemonSocket returns the stable path of the control socket of the session daemon
+func DaemonSocket() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join(os.TempDir(), "ddexec-"+strconv.Itoa(os.Getuid()))
+	}
+
+	// only the directory of the socket is shared with the containers
+	return filepath.Join(runtimeDir, "ddexec", "control", "ddexec.sock")
+}
+
+// StartDaemonServer serves the control socket at its stable path for the login session,
+// using the socket passed by systemd when socket activated
+func StartDaemonServer() (*Server, error) {
+	if env.IsSet(EnvServerSocket) {
+		return nil, errors.New("the daemon can not run inside ddexec")
+	}
+
+	l, activated, err := daemonListener()
+	if err != nil {
+		return nil, err
+	}
+
+	serverSocket = l.Addr().String()
+	acceptSessionClients = true
+
+	if debug.IsEnabled() {
+		fmt.Println("Serving the control socket at", serverSocket, "(socket activated:", strconv.FormatBool(activated)+")")
+	}
+
+	server := newServer(l, func() {
+		if !activated {
+			os.Remove(serverSocket) // systemd removes its own socket
+		}
+	})
+
+	go server.serve()
+
+	return server, nil
+}
+
+func daemonListener() (net.Listener, bool, error) {
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
+		if count, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err == nil && count > 0 {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+
+			f := os.NewFile(listenFdsStart, "ddexec.sock")
+			defer f.Close()
+
+			l, err := net.FileListener(f)
+			return l, true, err
+		}
+	}
+
+	socket := DaemonSocket()
+
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, false, err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, false, errors.New("a ddexec daemon is already listening on " + socket)
+		}
+
+		os.Remove(socket) // left behind by a daemon that was killed
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, false, err
+	}
+
+	return l, false, nil
+}
+
+// sessionIdentity accepts the processes of the same user running outside of containers,
+// which share our mount namespace, as clients of the daemon
+func sessionIdentity(r *http.Request) *Identity {
+	if !acceptSessionClients {
+		return nil
+	}
+
+	cred, ok := r.Context().Value(peerKey{}).(*syscall.Ucred)
+	if !ok || cred.Pid <= 0 || int(cred.Uid) != os.Getuid() {
+		return nil
+	}
+
+	own, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return nil
+	}
+
+	if peer, err := os.Readlink("/proc/" + strconv.Itoa(int(cred.Pid)) + "/ns/mnt"); err != nil || peer != own {
+		return nil
+	}
+
+	return sessionClient
+}
+
+// checkEnvironment makes sure that the variables the apps share with the daemon are the same for the client,
+// the apps are started with the working directory and the rest of the environment of the client
+func checkEnvironment(request *LaunchRequest) error {
+	return compareEnvironments(request.Env, os.Environ())
+}
+
+func compareEnvironments(client, daemon []string) error {
+	clientValues, daemonValues := environmentMap(client), environmentMap(daemon)
+
+	for _, name := range sharedVariables {
+		clientValue, clientSet := clientValues[name]
+		daemonValue, daemonSet := daemonValues[name]
+
+		if clientSet != daemonSet || clientValue != daemonValue {
+			return errors.New(name + " differs from the environment of the daemon")
+		}
+	}
+
+	return nil
+}
+
+func environmentMap(environment []string) map[string]string {
+	values := map[string]string{}
+
+	for _, item := range environment {
+		if idx := strings.Index(item, "="); idx > 0 {
+			values[item[:idx]] = item[idx+1:]
+		}
+	}
+
+	return values
+}
+
+// LaunchInDaemon asks the session daemon to start the app from the configuration file with
+// the working directory and environment of this process, and waits for it, returning ErrNoDaemon
+// when the daemon is not running, or ErrDifferentEnvironment when it can not start the app the same way,
+// the app is stopped when this process is interrupted or terminated
+func LaunchInDaemon(configPath string, args []string) (int, error) {
+	absolute, err := filepath.Abs(configPath)
+	if err != nil {
+		return -1, err
+	}
+
+	if conn, err := net.Dial("unix", DaemonSocket()); err != nil {
+		return -1, ErrNoDaemon
+	} else {
+		conn.Close()
+	}
+
+	dir, _ := os.Getwd()
+
+	data := new(bytes.Buffer)
+	if err := json.NewEncoder(data).Encode(LaunchRequest{Name: absolute, Args: args, Dir: dir, Env: os.Environ()}); err != nil {
+		return -1, err
+	}
+
+	req, err := http.NewRequest("POST", "http://control"+apiPrefix+"/launch", data)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// the daemon stops the app when the connection is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	interrupted := make(chan os.Signal, 1)
+
+	go func() {
+		select {
+		case s := <-signals:
+			interrupted <- s
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := newClient(DaemonSocket(), "").Do(req.WithContext(ctx))
+	if err != nil {
+		select {
+		case s := <-interrupted:
+			if debug.IsEnabled() {
+				fmt.Println("Stopped the app in the daemon on", s)
+			}
+
+			return 128 + int(s.(syscall.Signal)), nil
+		default:
+			return -1, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := readError(resp)
+
+		if apiErr, ok := err.(*APIError); ok && apiErr.Code == ErrorConflict {
+			if debug.IsEnabled() {
+				fmt.Println("Not using the daemon:", apiErr.Message)
+			}
+
+			return -1, ErrDifferentEnvironment
+		}
+
+		return -1, err
+	}
+
+	decoded := LaunchResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return -1, err
+	}
+
+	return decoded.ExitCode, nil
+}
@@ -0,0 +1,55 @@
+package control
+
+import (
+	"testing"
+)
+
+func TestCompareEnvironments(t *testing.T) {
+	// the environment of the systemd user manager, with the display imported by the session
+	daemon := []string{
+		"HOME=/home/user",
+		"USER=user",
+		"LANG=en_US.UTF-8",
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"XDG_RUNTIME_DIR=/run/user/1000",
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus",
+		"DISPLAY=:0",
+		"XAUTHORITY=/run/user/1000/gdm/Xauthority",
+		"MANAGERPID=1234",
+		"INVOCATION_ID=0123456789abcdef",
+		"JOURNAL_STREAM=8:12345",
+	}
+
+	// a terminal in the desktop session
+	terminal := []string{
+		"HOME=/home/user",
+		"USER=user",
+		"LANG=en_US.UTF-8",
+		"PATH=/home/user/bin:/usr/local/bin:/usr/bin:/bin",
+		"XDG_RUNTIME_DIR=/run/user/1000",
+		"XDG_SESSION_ID=2",
+		"XDG_SESSION_TYPE=x11",
+		"XDG_VTNR=2",
+		"XDG_SEAT=seat0",
+		"XDG_CURRENT_DESKTOP=GNOME",
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus",
+		"DISPLAY=:0",
+		"XAUTHORITY=/run/user/1000/gdm/Xauthority",
+		"SSH_AUTH_SOCK=/run/user/1000/keyring/ssh",
+		"TERM=xterm-256color",
+		"SHLVL=1",
+		"PWD=/home/user/projects",
+		"OLDPWD=/home/user",
+		"DO_NOT_SHARE_DOCKER=1",
+	}
+
+	if err := compareEnvironments(terminal, daemon); err != nil {
+		t.Error("unexpected difference from the session:", err)
+	}
+
+	for _, variable := range []string{"DISPLAY=:1", "HOME=/tmp/home", "DOCKER_HOST=unix:///run/user/1000/docker.sock"} {
+		if err := compareEnvironments(append(terminal[:len(terminal):len(terminal)], variable), daemon); err == nil {
+			t.Error("expected the environment to differ with", variable)
+		}
+	}
+}
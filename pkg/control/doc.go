@@ -0,0 +1,28 @@
+/*
+Package control implements the control socket ddexec shares with the apps it starts,
+so that ddexec running inside a container can act on the host.
+
+The API is served as JSON over HTTP on a unix socket, under the /v1/ prefix:
+
+	GET  /v1/version      the API version of the server (no authentication)
+	POST /v1/mkdir        MakeDirectoryRequest  -> MakeDirectoryResponse
+	POST /v1/checkDevice  CheckDeviceRequest    -> CheckDeviceResponse
+	POST /v1/runCommand   RunCommandRequest     -> RunCommandResponse
+	POST /v1/exec         RunCommandRequest     -> output stream, exit code trailer
+	POST /v1/launch       LaunchRequest         -> LaunchResponse
+	POST /v1/notify       NotifyRequest         -> NotifyResponse
+	POST /v1/issueToken   IssueTokenRequest     -> IssueTokenResponse
+	POST /v1/bindToken    TokenRequest
+	POST /v1/revokeToken  TokenRequest
+
+Every request except the version check carries the token of the app in the
+X-Ddexec-Token header. The exec endpoint streams the stdout and stderr of the
+command in the multiplexed format of docker attach, then sends its exit code
+in the X-Ddexec-Exit-Code trailer, or X-Ddexec-Error if it could not finish.
+Failed requests return an ErrorResponse with one of the Error* codes.
+
+The endpoints are also served without the prefix for the clients of older
+versions, and the client falls back to them when the server does not know
+/v1/version.
+*/
+package control
@@ -0,0 +1,150 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/xdgexec"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the output of /v1/exec uses the multiplexed stream format of docker attach
+	execContentType = "application/vnd.docker.multiplexed-stream"
+
+	exitCodeTrailer  = "X-Ddexec-Exit-Code"
+	execErrorTrailer = "X-Ddexec-Error"
+)
+
+// streamWriter sends the response headers on the first write and flushes every chunk,
+// so that failures before any output can still be reported with an error status
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if !s.started {
+		s.w.Header().Set("Content-Type", execContentType)
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+
+	n, err := s.w.Write(p)
+
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return n, err
+}
+
+// handleExec runs a command in the container of another app,
+// streaming its output back and sending the exit code in a trailer
+func handleExec(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	defer r.Body.Close()
+
+	request := RunCommandRequest{}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	if !checkRunCommand(w, identity, &request) {
+		return
+	}
+
+	w.Header().Set("Trailer", exitCodeTrailer+", "+execErrorTrailer)
+
+	output := &streamWriter{w: w}
+
+	exitCode, err := xdgexec.ExecInContainer(r.Context(), request.ContainerId, request.Args,
+		stdcopy.NewStdWriter(output, stdcopy.Stdout), stdcopy.NewStdWriter(output, stdcopy.Stderr))
+
+	if err != nil && !output.started {
+		writeError(w, http.StatusBadGateway, ErrorUnavailable, "failed to run the command: "+err.Error())
+		return
+	}
+
+	if !output.started {
+		output.Write(nil) // no output, send the headers before the trailers
+	}
+
+	if err != nil {
+		w.Header().Set(execErrorTrailer, err.Error())
+	} else {
+		w.Header().Set(exitCodeTrailer, strconv.Itoa(exitCode))
+	}
+}
+
+// Exec runs the command in the container of another app through the control socket,
+// copying its output to the writers, and returns its exit code
+func Exec(containerId string, command []string, stdout, stderr io.Writer) (int, error) {
+	prefix, err := getPathPrefix()
+	if err != nil {
+		return -1, err
+	}
+
+	request := RunCommandRequest{
+		ContainerId: containerId,
+		Args:        command,
+	}
+
+	if prefix == "" {
+		// older servers only report success or failure, keep the output, and only know shell commands
+		request.Args, request.Command = nil, legacyShellCommand(command)
+
+		decoded := RunCommandResponse{}
+		if err := call("runCommand", request, &decoded); err != nil {
+			return -1, err
+		}
+		return decoded.ExitCode, nil
+	}
+
+	data := new(bytes.Buffer)
+	if err := json.NewEncoder(data).Encode(request); err != nil {
+		return -1, err
+	}
+
+	resp, err := getClient().Post("http://control"+prefix+"/exec", "application/json", data)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1, readError(resp)
+	}
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Body); err != nil {
+		return -1, err
+	}
+
+	// the trailers are available once the body was read
+	if message := resp.Trailer.Get(execErrorTrailer); message != "" {
+		return -1, errors.New("failed to run the command: " + message)
+	}
+
+	exitCode, err := strconv.Atoi(resp.Trailer.Get(exitCodeTrailer))
+	if err != nil {
+		return -1, errors.New("no exit code received for the command")
+	}
+
+	return exitCode, nil
+}
+
+// legacyShellCommand quotes the arguments for the older servers, which run the command with /bin/sh
+// after escaping the & characters in it, so those are left out of the quotes
+func legacyShellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for idx, arg := range args {
+		arg = strings.ReplaceAll(arg, "'", `'\''`)
+		arg = strings.ReplaceAll(arg, "&", `'&'`)
+		quoted[idx] = "'" + arg + "'"
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,70 @@
+package control
+
+import (
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"net/http"
+	"sync"
+)
+
+// HandlerLookup checks whether the command runs one of the xdg-open handlers registered for the container
+type HandlerLookup func(containerId string, command []string) bool
+
+var (
+	handlerLookupLock sync.Mutex
+	handlerLookup     HandlerLookup
+)
+
+// SetHandlerLookup lets the apps run the xdg-open handlers of the other apps without the run_command permission
+func SetHandlerLookup(l HandlerLookup) {
+	handlerLookupLock.Lock()
+	defer handlerLookupLock.Unlock()
+
+	handlerLookup = l
+}
+
+func getHandlerLookup() HandlerLookup {
+	handlerLookupLock.Lock()
+	defer handlerLookupLock.Unlock()
+
+	return handlerLookup
+}
+
+// mayRunCommand checks whether the app may run the command in the container of another app started by ddexec,
+// either with the run_command permission, or as one of the xdg-open handlers registered for that container
+func mayRunCommand(identity *Identity, containerId string, command []string) bool {
+	target := findIdentityByContainer(containerId)
+	if target == nil {
+		return false // only containers started by ddexec
+	}
+
+	if identity.Permissions.CanRunCommandIn(target) {
+		return true
+	}
+
+	lookup := getHandlerLookup()
+	return identity.Permissions.XdgOpen && lookup != nil && lookup(containerId, command)
+}
+
+// checkRunCommand writes the error response when the app may not run the command, returning false
+func checkRunCommand(w http.ResponseWriter, identity *Identity, request *RunCommandRequest) bool {
+	if len(request.Args) == 0 && request.Command != "" {
+		writeError(w, http.StatusBadRequest, ErrorBadRequest, "shell commands are not supported, the arguments are expected in Args")
+		return false
+	} else if len(request.Args) == 0 {
+		writeError(w, http.StatusBadRequest, ErrorBadRequest, "no command to run")
+		return false
+	}
+
+	if !mayRunCommand(identity, request.ContainerId, request.Args) {
+		if debug.IsEnabled() {
+			fmt.Println(identity.AppName, "is not allowed to run", request.Args, "in", request.ContainerId)
+		}
+
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may not run commands in "+request.ContainerId+
+			" other than its xdg-open handlers, unless the app is listed in control: run_command of x-startup")
+		return false
+	}
+
+	return true
+}
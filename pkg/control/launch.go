@@ -0,0 +1,127 @@
+package control
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Launcher starts the app with the configuration name from the search path of the host
+// (or from an absolute path for the clients of the daemon, with their working directory and environment),
+// and returns its exit code once it has finished, the app is stopped early when the stop channel is closed
+type Launcher func(request *LaunchRequest, stop <-chan struct{}) (int, error)
+
+// ErrUnknownApp is returned by the launcher when the configuration is not in the search path
+var ErrUnknownApp = errors.New("app configuration not found")
+
+var (
+	launcherLock sync.Mutex
+	launcher     Launcher
+)
+
+// SetLauncher enables the launch endpoint, the apps are started by the callback
+func SetLauncher(l Launcher) {
+	launcherLock.Lock()
+	defer launcherLock.Unlock()
+
+	launcher = l
+}
+
+func getLauncher() Launcher {
+	launcherLock.Lock()
+	defer launcherLock.Unlock()
+
+	return launcher
+}
+
+// AppName returns the name of the app from its configuration name, as used in the allow-lists
+func AppName(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".yml"), ".yaml")
+}
+
+func isValidAppName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.Contains(name, "/")
+}
+
+func handleLaunch(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	defer r.Body.Close()
+
+	request := LaunchRequest{}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	// the server only notices the client going away once the body is read to the end
+	io.Copy(ioutil.Discard, r.Body)
+
+	// the processes of the user may start any configuration file, apps only the ones in the search path
+	if !(identity.session && filepath.IsAbs(request.Name)) && !isValidAppName(request.Name) {
+		writeError(w, http.StatusBadRequest, ErrorBadRequest, "invalid app name: "+request.Name)
+		return
+	}
+
+	if !identity.Permissions.CanLaunch(AppName(request.Name)) {
+		if debug.IsEnabled() {
+			fmt.Println(identity.AppName, "is not allowed to launch", request.Name)
+		}
+
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may not launch "+request.Name)
+		return
+	}
+
+	if identity.session {
+		if err := checkEnvironment(&request); err != nil {
+			writeError(w, http.StatusConflict, ErrorConflict, "can not launch "+request.Name+" in the daemon: "+err.Error())
+			return
+		}
+	} else {
+		// the apps are started with the environment of the host
+		request.Dir, request.Env = "", nil
+	}
+
+	launch := getLauncher()
+	if launch == nil {
+		writeError(w, http.StatusNotImplemented, ErrorUnavailable, "launching apps is not supported by this server")
+		return
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println(identity.AppName, "is launching", request.Name, request.Args)
+	}
+
+	// stops the app when the client disconnects, on an interrupt for example
+	exitCode, err := launch(&request, r.Context().Done())
+	if err == ErrUnknownApp {
+		writeError(w, http.StatusNotFound, ErrorNotFound, request.Name+" was not found in the app search path")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrorInternal, "failed to launch "+request.Name+": "+err.Error())
+		return
+	}
+
+	setAuditExitCode(r, exitCode)
+
+	writeResponse(w, &LaunchResponse{
+		ExitCode: exitCode,
+	})
+}
+
+// Launch asks the control server to start the app from its own search path, and waits for it
+func Launch(name string, args []string) (int, error) {
+	if !isValidAppName(name) {
+		return -1, errors.New("only apps from the search path can be launched through the control socket, without a directory: " + name)
+	}
+
+	decoded := LaunchResponse{}
+	if err := call("launch", LaunchRequest{Name: name, Args: args}, &decoded); err != nil {
+		return -1, err
+	}
+
+	return decoded.ExitCode, nil
+}
@@ -0,0 +1,129 @@
+package control
+
+import (
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/dbus"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"net/http"
+	"strings"
+)
+
+// the number of recent notifications an app may replace
+const maxNotifications = 64
+
+func handleNotify(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	defer r.Body.Close()
+
+	if !identity.Permissions.Notify {
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may not show notifications")
+		return
+	}
+
+	request := NotifyRequest{}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	// the name shown comes from the token, not from the (untrusted) app
+	request.AppName = identity.AppName
+
+	if request.ReplacesID != 0 && !hasNotification(identity, request.ReplacesID) {
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may only replace its own notifications")
+		return
+	}
+
+	id, err := showNotification(request)
+	if err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("Failed to show the notification:", err)
+		}
+
+		writeError(w, http.StatusBadGateway, ErrorUnavailable, "failed to show the notification: "+err.Error())
+		return
+	}
+
+	addNotification(identity, id)
+
+	writeResponse(w, &NotifyResponse{
+		ID: id,
+	})
+}
+
+// hasNotification checks whether the notification was shown for the app
+func hasNotification(identity *Identity, id uint32) bool {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	return containsNotification(identity.notifications, id)
+}
+
+// addNotification records the notification shown for the app, keeping only the recent ones
+func addNotification(identity *Identity, id uint32) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if id == 0 || containsNotification(identity.notifications, id) {
+		return
+	}
+
+	identity.notifications = append(identity.notifications, id)
+
+	if len(identity.notifications) > maxNotifications {
+		identity.notifications = identity.notifications[len(identity.notifications)-maxNotifications:]
+	}
+}
+
+func containsNotification(ids []uint32, id uint32) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// showNotification sends the notification to the notification server on the host session bus,
+// prefixing it with the name of the app it came from (replaced in tests)
+var showNotification = func(request NotifyRequest) (uint32, error) {
+	conn, err := dbus.Dial(dbus.SessionBusAddress())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	summary := request.Summary
+	if request.AppName != "" {
+		summary = request.AppName + ": " + summary
+	}
+
+	icon := request.Icon
+	if strings.Contains(icon, "/") {
+		icon = "" // paths in the container don't make sense on the host
+	}
+
+	hints := map[string]dbus.Variant{
+		"urgency": {Signature: "y", Value: request.Urgency},
+	}
+	if request.Category != "" {
+		hints["category"] = dbus.Variant{Signature: "s", Value: request.Category}
+	}
+
+	reply, err := conn.Call(dbus.NewMethodCall(
+		"org.freedesktop.Notifications", "/org/freedesktop/Notifications",
+		"org.freedesktop.Notifications", "Notify", "susssasa{sv}i",
+		"ddexec: "+request.AppName, request.ReplacesID, icon, summary, request.Body,
+		[]string{}, hints, request.Timeout,
+	))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(reply.Body) > 0 {
+		if id, ok := reply.Body[0].(uint32); ok {
+			return id, nil
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,65 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotifyReplacesOwnNotifications(t *testing.T) {
+	defer func(original func(NotifyRequest) (uint32, error)) { showNotification = original }(showNotification)
+
+	var lastID uint32
+	showNotification = func(request NotifyRequest) (uint32, error) {
+		if request.ReplacesID != 0 {
+			return request.ReplacesID, nil
+		}
+
+		lastID++
+		return lastID, nil
+	}
+
+	var (
+		browser = &Identity{AppName: "browser", Permissions: Permissions{Notify: true}}
+		editor  = &Identity{AppName: "editor", Permissions: Permissions{Notify: true}}
+	)
+
+	notify := func(identity *Identity, replacesID uint32) (int, uint32) {
+		data := new(bytes.Buffer)
+		json.NewEncoder(data).Encode(NotifyRequest{Summary: "Hello", ReplacesID: replacesID})
+
+		recorder := httptest.NewRecorder()
+		handleNotify(recorder, httptest.NewRequest("POST", apiPrefix+"/notify", data), identity)
+
+		response := NotifyResponse{}
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response.ID
+	}
+
+	status, browserID := notify(browser, 0)
+	if status != http.StatusOK || browserID == 0 {
+		t.Fatal("failed to show the notification:", status)
+	}
+
+	if status, id := notify(browser, browserID); status != http.StatusOK || id != browserID {
+		t.Error("expected to replace the own notification:", status, id)
+	}
+
+	if status, _ := notify(editor, browserID); status != http.StatusForbidden {
+		t.Error("expected to refuse replacing the notification of another app:", status)
+	}
+
+	if status, _ := notify(browser, browserID+100); status != http.StatusForbidden {
+		t.Error("expected to refuse replacing an unknown notification:", status)
+	}
+
+	for idx := 0; idx < maxNotifications; idx++ {
+		notify(editor, 0)
+	}
+
+	if len(editor.notifications) != maxNotifications {
+		t.Error("expected to keep only the recent notifications:", len(editor.notifications))
+	}
+}
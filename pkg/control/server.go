@@ -1,9 +1,11 @@
 package control
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/debug"
 	"github.com/rycus86/ddexec/pkg/env"
 	"github.com/rycus86/ddexec/pkg/xdgexec"
@@ -12,10 +14,18 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 )
 
 const EnvServerSocket = "DDEXEC_SERVER_SOCK"
 
+const (
+	apiPrefix = "/v1"
+
+	controlDirPrefix = "ddexec"
+	pidFile          = "ddexec.pid"
+)
+
 var serverSocket string
 
 func GetServerSocket() string {
@@ -34,48 +44,172 @@ func GetDirectoryToShare() string {
 	}
 }
 
-func StartServerIfNecessary() {
+// Server serves the control API on a unix socket
+type Server struct {
+	http     *http.Server
+	listener net.Listener
+	cleanup  func()
+}
+
+func newServer(l net.Listener, cleanup func()) *Server {
+	return &Server{
+		http: &http.Server{
+			Handler:     newMux(),
+			ConnContext: withPeerCredentials,
+		},
+		listener: l,
+		cleanup:  cleanup,
+	}
+}
+
+// StartServerIfNecessary starts the control server in a new temporary directory,
+// unless we are a nested ddexec using the one of the parent, returning nil then
+func StartServerIfNecessary() *Server {
 	if env.IsSet(EnvServerSocket) {
-		return
+		return nil
 	}
 
-	tmpDir, err := ioutil.TempDir("", "ddexec")
+	sweepStaleDirectories()
+
+	tmpDir, err := ioutil.TempDir("", controlDirPrefix)
 	if err != nil {
 		panic(err)
 	}
 
-	serverSocket = path.Join(tmpDir, "ddexec.sock")
+	if err := ioutil.WriteFile(path.Join(tmpDir, pidFile), []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		os.RemoveAll(tmpDir)
+		panic(err)
+	}
+
+	socket := path.Join(tmpDir, "ddexec.sock")
 
-	l, err := net.Listen("unix", serverSocket)
+	l, err := net.Listen("unix", socket)
 	if err != nil {
+		os.RemoveAll(tmpDir)
+		panic(err)
+	}
+
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		os.RemoveAll(tmpDir)
 		panic(err)
 	}
 
-	http.HandleFunc("/mkdir", handleMkdir)
-	http.HandleFunc("/checkDevice", handleCheckDevice)
-	http.HandleFunc("/runCommand", handleRunCommand)
+	serverSocket = socket
 
-	go runServer(l, tmpDir)
+	server := newServer(l, func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	go server.serve()
+
+	return server
 }
 
-func runServer(l net.Listener, tmpDir string) {
-	defer os.RemoveAll(tmpDir)
-	defer l.Close()
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	for name, handler := range map[string]func(http.ResponseWriter, *http.Request, *Identity){
+		"mkdir":       handleMkdir,
+		"checkDevice": handleCheckDevice,
+		"runCommand":  handleRunCommand,
+		"exec":        handleExec,
+		"launch":      handleLaunch,
+		"notify":      handleNotify,
+		"issueToken":  handleIssueToken,
+		"bindToken":   handleBindToken,
+		"revokeToken": handleRevokeToken,
+	} {
+		mux.HandleFunc(apiPrefix+"/"+name, audited(name, authenticated(handler)))
+		mux.HandleFunc("/"+name, audited(name, authenticated(handler))) // for older clients
+	}
 
-	http.Serve(l, nil)
+	mux.HandleFunc(apiPrefix+"/version", audited("version", handleVersion))
+
+	return mux
 }
 
-func handleMkdir(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
+func (s *Server) serve() {
+	if err := s.http.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		fmt.Println("WARNING: The control server has stopped:", err)
+	}
+}
+
+// Shutdown stops accepting new requests, waits for the active ones until the context is done,
+// then closes the remaining connections and removes the socket
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.cleanup()
+
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		s.http.Close()
+	}
+
+	return err
+}
+
+// writeResponse sends the successful JSON response
+func writeResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if response != nil {
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// writeError sends an ErrorResponse with the status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	setAuditError(w, code, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(&ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+}
 
+// decodeRequest reads the JSON body of a POST request, answering with an error when it fails
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
 	if r.Method != "POST" {
-		w.WriteHeader(400)
+		writeError(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, r.Method+" is not supported")
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrorBadRequest, "invalid request: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrorMethodNotAllowed, r.Method+" is not supported")
+		return
+	}
+
+	writeResponse(w, &VersionResponse{
+		APIVersion: APIVersion,
+		Version:    config.GetVersion(),
+	})
+}
+
+func handleMkdir(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	defer r.Body.Close()
+
+	if !identity.Permissions.Mkdir {
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may not create directories")
 		return
 	}
 
 	request := MakeDirectoryRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		w.WriteHeader(400)
+	if !decodeRequest(w, r, &request) {
 		return
 	}
 
@@ -83,13 +217,11 @@ func handleMkdir(w http.ResponseWriter, r *http.Request) {
 
 	if fi, err := os.Stat(targetPath); err == nil {
 		if fi.IsDir() {
-			w.WriteHeader(200)
-			w.Header().Add("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(&MakeDirectoryResponse{
+			writeResponse(w, &MakeDirectoryResponse{
 				CreatedPath: targetPath,
 			})
 		} else {
-			w.WriteHeader(409)
+			writeError(w, http.StatusConflict, ErrorConflict, targetPath+" exists and is not a directory")
 		}
 		return
 	}
@@ -100,66 +232,106 @@ func handleMkdir(w http.ResponseWriter, r *http.Request) {
 
 	defer func() {
 		if e := recover(); e != nil {
-			w.WriteHeader(500)
+			writeError(w, http.StatusInternalServerError, ErrorInternal, fmt.Sprint("failed to create ", targetPath, ": ", e))
 		}
 	}()
 
 	created := EnsureSourceExists(targetPath)
 
-	w.WriteHeader(200)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&MakeDirectoryResponse{
+	writeResponse(w, &MakeDirectoryResponse{
 		CreatedPath: created,
 	})
 }
 
-func handleCheckDevice(w http.ResponseWriter, r *http.Request) {
+func handleCheckDevice(w http.ResponseWriter, r *http.Request, identity *Identity) {
 	defer r.Body.Close()
 
-	if r.Method != "POST" {
-		w.WriteHeader(400)
+	if !identity.Permissions.CheckDevice {
+		writeError(w, http.StatusForbidden, ErrorForbidden, identity.AppName+" may not check devices")
 		return
 	}
 
 	request := CheckDeviceRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		w.WriteHeader(400)
+	if !decodeRequest(w, r, &request) {
 		return
 	}
 
 	_, err := os.Stat(request.Path)
 
-	w.WriteHeader(200)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&CheckDeviceResponse{
+	writeResponse(w, &CheckDeviceResponse{
 		Exists: err == nil,
 	})
 }
 
-func handleRunCommand(w http.ResponseWriter, r *http.Request) {
+func handleRunCommand(w http.ResponseWriter, r *http.Request, identity *Identity) {
 	defer r.Body.Close()
 
-	if r.Method != "POST" {
-		w.WriteHeader(400)
+	request := RunCommandRequest{}
+	if !decodeRequest(w, r, &request) {
 		return
 	}
 
-	request := RunCommandRequest{}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		w.WriteHeader(400)
+	if !checkRunCommand(w, identity, &request) {
 		return
 	}
 
-	var exitCode int
-	if ok, err := xdgexec.ExecInContainer(request.ContainerId, request.Command); err == nil && ok {
-		exitCode = 0
-	} else {
-		exitCode = 1 // TODO proxy the actual exit code, maybe logs too
+	// the output stays on the host, the exec endpoint streams it back
+	exitCode, err := xdgexec.ExecInContainer(r.Context(), request.ContainerId, request.Args, os.Stdout, os.Stderr)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, ErrorUnavailable, "failed to run the command: "+err.Error())
+		return
 	}
 
-	w.WriteHeader(200)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(&RunCommandResponse{
+	setAuditExitCode(r, exitCode)
+
+	writeResponse(w, &RunCommandResponse{
 		ExitCode: exitCode,
 	})
 }
+
+func handleIssueToken(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	defer r.Body.Close()
+
+	request := IssueTokenRequest{}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	writeResponse(w, &IssueTokenResponse{
+		Token: registerToken(request.AppName, request.Permissions, identity),
+	})
+}
+
+func handleBindToken(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	handleTokenChange(w, r, identity, func(child *Identity, request TokenRequest) error {
+		return bindContainer(child, request.ContainerId)
+	})
+}
+
+func handleRevokeToken(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	handleTokenChange(w, r, identity, func(child *Identity, request TokenRequest) error {
+		delete(tokens, child.token)
+		return nil
+	})
+}
+
+// handleTokenChange updates a token issued to the caller (for apps started by nested ddexec)
+func handleTokenChange(w http.ResponseWriter, r *http.Request, identity *Identity, change func(*Identity, TokenRequest) error) {
+	defer r.Body.Close()
+
+	request := TokenRequest{}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	if child, ok := tokens[request.Token]; !ok || child.issuer != identity {
+		writeError(w, http.StatusForbidden, ErrorForbidden, "the token was not issued to "+identity.AppName)
+	} else if err := change(child, request); err != nil {
+		writeError(w, http.StatusConflict, ErrorConflict, err.Error())
+	} else {
+		writeResponse(w, nil)
+	}
+}
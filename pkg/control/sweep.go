@@ -0,0 +1,71 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sweepStaleDirectories removes the control directories left behind
+// by ddexec processes that are gone, for example after they were killed
+func sweepStaleDirectories() {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), controlDirPrefix+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, dir := range dirs {
+		if !isStaleDirectory(dir) {
+			continue
+		}
+
+		if debug.IsEnabled() {
+			fmt.Println("Removing stale control directory:", dir)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+// isStaleDirectory checks whether the directory belongs to a control server of ours that is gone,
+// ignoring the other directories ddexec creates in the temp directory
+func isStaleDirectory(dir string) bool {
+	fi, err := os.Lstat(dir)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	if stat, ok := fi.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return false
+	}
+
+	if contents, err := ioutil.ReadFile(filepath.Join(dir, pidFile)); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+		if err != nil || pid <= 0 {
+			return false
+		}
+
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}
+
+	// older versions did not write a pid file, check whether anyone listens on the socket
+	socket := filepath.Join(dir, "ddexec.sock")
+	if _, err := os.Stat(socket); err != nil {
+		return false
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+
+	conn.Close()
+	return false
+}
@@ -0,0 +1,52 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSweepStaleDirectories(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sweep-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmpDir)
+
+	// a process that has exited already
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("can not start a process:", err)
+	}
+
+	var (
+		stale  = filepath.Join(os.TempDir(), "ddexec111")
+		active = filepath.Join(os.TempDir(), "ddexec222")
+		other  = filepath.Join(os.TempDir(), "ddexec-secrets333")
+	)
+
+	for dir, pid := range map[string]int{stale: cmd.Process.Pid, active: os.Getpid()} {
+		os.Mkdir(dir, 0700)
+		ioutil.WriteFile(filepath.Join(dir, pidFile), []byte(strconv.Itoa(pid)), 0600)
+	}
+
+	os.Mkdir(other, 0700)
+
+	sweepStaleDirectories()
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected the stale directory to be removed")
+	}
+
+	for _, dir := range []string{active, other} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Error("expected to keep", dir)
+		}
+	}
+}
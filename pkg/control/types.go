@@ -1,5 +1,49 @@
 package control
 
+import "fmt"
+
+// APIVersion is the version of the control API served under /v1/
+const APIVersion = 1
+
+// the codes of the errors returned by the control API
+const (
+	ErrorBadRequest       = "bad_request"
+	ErrorMethodNotAllowed = "method_not_allowed"
+	ErrorUnauthorized     = "unauthorized"
+	ErrorForbidden        = "forbidden"
+	ErrorNotFound         = "not_found"
+	ErrorConflict         = "conflict"
+	ErrorInternal         = "internal"
+	ErrorUnavailable      = "unavailable"
+)
+
+// VersionResponse is returned by /v1/version, without authentication
+type VersionResponse struct {
+	APIVersion int
+	Version    string // the version of ddexec serving the API
+}
+
+// ErrorResponse is the body of every non-200 response of the /v1/ API
+type ErrorResponse struct {
+	Code    string
+	Message string
+}
+
+// APIError is returned by the client for the failed requests
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("control request failed with status %d", e.Status)
+	}
+	return fmt.Sprintf("control request failed with status %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// MakeDirectoryRequest creates a directory on the host for a volume of a nested app
 type MakeDirectoryRequest struct {
 	Path string
 }
@@ -8,6 +52,7 @@ type MakeDirectoryResponse struct {
 	CreatedPath string
 }
 
+// CheckDeviceRequest checks whether a device exists on the host
 type CheckDeviceRequest struct {
 	Path string
 }
@@ -16,11 +61,58 @@ type CheckDeviceResponse struct {
 	Exists bool
 }
 
+// RunCommandRequest runs a command in the container of another app started by ddexec
 type RunCommandRequest struct {
 	ContainerId string
-	Command     string
+	Args        []string // executed without a shell
+	Command     string   // deprecated: a shell command for the older servers, rejected by this version
 }
 
 type RunCommandResponse struct {
 	ExitCode int
 }
+
+// LaunchRequest starts an app from the search path of the host, waiting for it to exit
+type LaunchRequest struct {
+	Name string // the name of the configuration, without a directory (or its absolute path for the clients of the daemon)
+	Args []string
+	Dir  string   // the working directory of the clients of the daemon
+	Env  []string // the environment of the clients of the daemon
+}
+
+type LaunchResponse struct {
+	ExitCode int
+}
+
+// NotifyRequest shows a desktop notification on the host, the app name comes from the token
+type NotifyRequest struct {
+	AppName    string
+	ReplacesID uint32
+	Icon       string
+	Summary    string
+	Body       string
+	Category   string
+	Urgency    byte
+	Timeout    int32
+}
+
+type NotifyResponse struct {
+	ID uint32
+}
+
+// IssueTokenRequest asks for a token for an app started by a nested ddexec,
+// with at most the permissions of the caller
+type IssueTokenRequest struct {
+	AppName     string
+	Permissions Permissions
+}
+
+type IssueTokenResponse struct {
+	Token string
+}
+
+// TokenRequest binds or revokes a token issued to the caller
+type TokenRequest struct {
+	Token       string
+	ContainerId string
+}
@@ -0,0 +1,359 @@
+package dbus
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Conn is a connection to a message bus (or from a client, on the bus side)
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	lock   sync.Mutex
+	serial uint32
+
+	// Name is the unique name assigned by the bus
+	Name string
+}
+
+// SessionBusAddress returns the address of the session bus of the current user
+func SessionBusAddress() string {
+	if address := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); address != "" {
+		return address
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = "/run/user/" + strconv.Itoa(os.Getuid())
+	}
+
+	return "unix:path=" + runtimeDir + "/bus"
+}
+
+// SystemBusAddress returns the address of the system bus
+func SystemBusAddress() string {
+	if address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); address != "" {
+		return address
+	}
+
+	return "unix:path=/var/run/dbus/system_bus_socket"
+}
+
+// SocketPath returns the unix socket path of the address (abstract sockets start with @)
+func SocketPath(address string) (string, error) {
+	for _, candidate := range strings.Split(address, ";") {
+		if !strings.HasPrefix(candidate, "unix:") {
+			continue
+		}
+
+		for _, param := range strings.Split(strings.TrimPrefix(candidate, "unix:"), ",") {
+			if strings.HasPrefix(param, "path=") {
+				return unescape(strings.TrimPrefix(param, "path="))
+			} else if strings.HasPrefix(param, "abstract=") {
+				path, err := unescape(strings.TrimPrefix(param, "abstract="))
+				return "@" + path, err
+			}
+		}
+	}
+
+	return "", errors.New("no supported unix socket address in " + address)
+}
+
+func unescape(value string) (string, error) {
+	var result []byte
+
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] == '%' && idx+2 < len(value) {
+			decoded, err := hex.DecodeString(value[idx+1 : idx+3])
+			if err != nil {
+				return "", err
+			}
+
+			result = append(result, decoded...)
+			idx += 2
+		} else {
+			result = append(result, value[idx])
+		}
+	}
+
+	return string(result), nil
+}
+
+// Dial connects to the message bus at the address, authenticates and registers the connection
+func Dial(address string) (*Conn, error) {
+	c, err := Connect(address)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.Call(NewMethodCall(BusName, BusPath, BusInterface, "Hello", ""))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if len(reply.Body) > 0 {
+		c.Name, _ = reply.Body[0].(string)
+	}
+
+	return c, nil
+}
+
+// Connect connects to the message bus at the address and authenticates,
+// leaving the registration with Hello to the caller
+func Connect(address string) (*Conn, error) {
+	path, err := SocketPath(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if err := c.authenticate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// authenticate does the client side of the SASL EXTERNAL authentication
+func (c *Conn) authenticate() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "OK ") {
+		return errors.New("DBus authentication failed: " + strings.TrimSpace(line))
+	}
+
+	_, err = c.conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+// Accept does the server side of the authentication for a client connection,
+// accepting the EXTERNAL mechanism for the processes of the user with the uid only
+func Accept(conn net.Conn, guid string, uid int) (*Conn, error) {
+	peer, err := peerUID(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if b, err := c.reader.ReadByte(); err != nil {
+		return nil, err
+	} else if b != 0 {
+		return nil, errors.New("expected a NUL byte to start the authentication")
+	}
+
+	var authenticated, waitingForData bool
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		} else if len(line) > 16*1024 {
+			return nil, errors.New("authentication line too long")
+		}
+
+		command := strings.Fields(strings.TrimSpace(line))
+		if len(command) == 0 {
+			command = []string{""}
+		}
+
+		var response string
+
+		switch command[0] {
+		case "AUTH":
+			authenticated, waitingForData = false, false
+
+			if len(command) == 2 && command[1] == "EXTERNAL" {
+				response = "DATA" // the identity comes in the next line, or the peer credentials are used
+				waitingForData = true
+			} else if len(command) == 3 && command[1] == "EXTERNAL" && isAllowedPeer(command[2], peer, uid) {
+				response = "OK " + guid
+				authenticated = true
+			} else {
+				response = "REJECTED EXTERNAL"
+			}
+
+		case "DATA":
+			if !waitingForData {
+				response = "ERROR unexpected DATA"
+			} else if len(command) <= 2 && isAllowedPeer(strings.Join(command[1:], ""), peer, uid) {
+				response = "OK " + guid
+				authenticated, waitingForData = true, false
+			} else {
+				response = "REJECTED EXTERNAL"
+				waitingForData = false
+			}
+
+		case "NEGOTIATE_UNIX_FD":
+			response = "ERROR file descriptor passing is not supported"
+
+		case "BEGIN":
+			if !authenticated {
+				return nil, errors.New("BEGIN before a successful authentication")
+			}
+			return c, nil
+
+		case "CANCEL":
+			authenticated, waitingForData = false, false
+			response = "REJECTED EXTERNAL"
+
+		default:
+			response = "ERROR unknown command"
+		}
+
+		if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isAllowedPeer checks that the peer runs as the user with the uid,
+// and that the identity it claims (hex encoded, optional) is the same
+func isAllowedPeer(identity string, peer, uid int) bool {
+	if peer != uid {
+		return false
+	}
+
+	if identity == "" {
+		return true
+	}
+
+	claimed, err := hex.DecodeString(identity)
+	return err == nil && string(claimed) == strconv.Itoa(peer)
+}
+
+// peerUID returns the uid of the process on the other end of the unix socket
+func peerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, errors.New("not a unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return -1, err
+	} else if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(cred.Uid), nil
+}
+
+// NewGUID generates a random server GUID
+func NewGUID() string {
+	data := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Send sends the message, assigning it the next serial if it does not have one yet
+func (c *Conn) Send(m *Message) (uint32, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if m.Serial == 0 {
+		c.serial++
+		m.Serial = c.serial
+	}
+
+	data, err := m.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = c.conn.Write(data)
+	return m.Serial, err
+}
+
+// SendRaw writes an already serialized message
+func (c *Conn) SendRaw(data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Receive reads the next message
+func (c *Conn) Receive() (*Message, error) {
+	m, _, err := ReadMessage(c.reader)
+	return m, err
+}
+
+// ReceiveRaw reads the next message along with its serialized form
+func (c *Conn) ReceiveRaw() (*Message, []byte, error) {
+	return ReadMessage(c.reader)
+}
+
+// Call sends the method call and waits for its reply, skipping other messages,
+// so it should only be used on connections without concurrent readers
+func (c *Conn) Call(m *Message) (*Message, error) {
+	serial, err := c.Send(m)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := c.Receive()
+		if err != nil {
+			return nil, err
+		}
+
+		if reply.ReplySerial != serial {
+			continue
+		}
+
+		if reply.Type == TypeError {
+			e := &Error{Name: reply.ErrorName}
+			if len(reply.Body) > 0 {
+				e.Message, _ = reply.Body[0].(string)
+			}
+			return nil, e
+		}
+
+		return reply, nil
+	}
+}
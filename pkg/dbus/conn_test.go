@@ -0,0 +1,114 @@
+package dbus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// acceptOne authenticates the first client on a new socket, with the result sent to the channel
+func acceptOne(t *testing.T, uid int) (string, chan error, func()) {
+	dir, err := ioutil.TempDir("", "dbus-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(dir, "bus")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			result <- err
+			return
+		}
+
+		_, err = Accept(conn, NewGUID(), uid)
+		result <- err
+		conn.Close()
+	}()
+
+	return socket, result, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	socket, result, cleanup := acceptOne(t, os.Getuid())
+	defer cleanup()
+
+	c, err := Connect("unix:path=" + socket)
+	if err != nil {
+		t.Fatal("failed to authenticate:", err)
+	}
+	defer c.Close()
+
+	if err := <-result; err != nil {
+		t.Error("expected to accept the client:", err)
+	}
+}
+
+func TestAcceptOtherUser(t *testing.T) {
+	socket, result, cleanup := acceptOne(t, os.Getuid()+1)
+	defer cleanup()
+
+	if c, err := Connect("unix:path=" + socket); err == nil {
+		c.Close()
+		t.Error("expected to reject the client")
+	}
+
+	if err := <-result; err == nil {
+		t.Error("expected to fail authenticating the client")
+	}
+}
+
+func TestAcceptAuthenticationRequired(t *testing.T) {
+	for _, lines := range [][]string{
+		{"BEGIN"},
+		{"AUTH EXTERNAL " + hex.EncodeToString([]byte(strconv.Itoa(os.Getuid()+1))), "BEGIN"},
+		{"AUTH EXTERNAL", "DATA " + hex.EncodeToString([]byte("x")), "BEGIN"},
+		{"DATA", "BEGIN"},
+	} {
+		socket, result, cleanup := acceptOne(t, os.Getuid())
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+
+		conn.Write([]byte("\x00"))
+		reader := bufio.NewReader(conn)
+
+		for _, line := range lines {
+			conn.Write([]byte(line + "\r\n"))
+
+			if line != "BEGIN" {
+				if response, _ := reader.ReadString('\n'); strings.HasPrefix(response, "OK") {
+					t.Errorf("unexpected response for %q: %s", line, response)
+				}
+			}
+		}
+
+		if err := <-result; err == nil {
+			t.Errorf("expected to reject %q", lines)
+		}
+
+		conn.Close()
+		cleanup()
+	}
+}
@@ -0,0 +1,691 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+const maxMessageSize = 64 * 1024 * 1024
+
+// the byte order we use for sending messages
+var order = binary.LittleEndian
+
+func alignment(typeCode byte) int {
+	switch typeCode {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 4
+	}
+}
+
+// nextType returns the first single complete type of the signature
+func nextType(sig string) (string, error) {
+	if sig == "" {
+		return "", errors.New("empty signature")
+	}
+
+	switch sig[0] {
+	case 'a':
+		elem, err := nextType(sig[1:])
+		if err != nil {
+			return "", err
+		}
+		return "a" + elem, nil
+
+	case '(', '{':
+		closing := byte(')')
+		if sig[0] == '{' {
+			closing = '}'
+		}
+
+		for idx := 1; idx < len(sig); {
+			if sig[idx] == closing {
+				return sig[:idx+1], nil
+			}
+
+			field, err := nextType(sig[idx:])
+			if err != nil {
+				return "", err
+			}
+			idx += len(field)
+		}
+		return "", errors.New("unterminated signature: " + sig)
+
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 's', 'o', 'g', 'v', 'h':
+		return sig[:1], nil
+
+	default:
+		return "", errors.New("invalid signature: " + sig)
+	}
+}
+
+// splitSignature returns the single complete types of the signature
+func splitSignature(sig string) ([]string, error) {
+	var types []string
+
+	for len(sig) > 0 {
+		t, err := nextType(sig)
+		if err != nil {
+			return nil, err
+		}
+
+		types = append(types, t)
+		sig = sig[len(t):]
+	}
+
+	return types, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	order.PutUint32(e.buf[len(e.buf)-4:], v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.align(8)
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0)
+	order.PutUint64(e.buf[len(e.buf)-8:], v)
+}
+
+func (e *encoder) encodeAll(sig string, values []interface{}) error {
+	types, err := splitSignature(sig)
+	if err != nil {
+		return err
+	} else if len(types) != len(values) {
+		return errors.Errorf("signature %s does not match %d values", sig, len(values))
+	}
+
+	for idx, t := range types {
+		if err := e.encode(t, values[idx]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *encoder) encode(t string, value interface{}) (err error) {
+	defer func() {
+		// type assertion failures on mismatching values
+		if r := recover(); r != nil {
+			err = errors.Errorf("cannot encode %T as %s", value, t)
+		}
+	}()
+
+	switch t[0] {
+	case 'y':
+		e.buf = append(e.buf, value.(byte))
+
+	case 'b':
+		if value.(bool) {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+
+	case 'n':
+		e.align(2)
+		e.buf = append(e.buf, 0, 0)
+		order.PutUint16(e.buf[len(e.buf)-2:], uint16(value.(int16)))
+
+	case 'q':
+		e.align(2)
+		e.buf = append(e.buf, 0, 0)
+		order.PutUint16(e.buf[len(e.buf)-2:], value.(uint16))
+
+	case 'i':
+		e.uint32(uint32(value.(int32)))
+
+	case 'u', 'h':
+		e.uint32(value.(uint32))
+
+	case 'x':
+		e.uint64(uint64(value.(int64)))
+
+	case 't':
+		e.uint64(value.(uint64))
+
+	case 'd':
+		e.uint64(math.Float64bits(value.(float64)))
+
+	case 's':
+		e.string(value.(string))
+
+	case 'o':
+		e.string(string(value.(ObjectPath)))
+
+	case 'g':
+		e.signature(string(value.(Signature)))
+
+	case 'v':
+		variant := value.(Variant)
+		e.signature(string(variant.Signature))
+		return e.encode(string(variant.Signature), variant.Value)
+
+	case 'a':
+		return e.array(t[1:], value)
+
+	case '(':
+		fields := value.([]interface{})
+		e.align(8)
+		return e.encodeAll(t[1:len(t)-1], fields)
+
+	default:
+		return errors.New("unsupported type: " + t)
+	}
+
+	return nil
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) array(elem string, value interface{}) error {
+	e.uint32(0)
+	lengthAt := len(e.buf) - 4
+
+	e.align(alignment(elem[0]))
+	start := len(e.buf)
+
+	v := reflect.ValueOf(value)
+
+	if elem[0] == '{' {
+		if v.Kind() != reflect.Map {
+			return errors.Errorf("cannot encode %T as a%s", value, elem)
+		}
+
+		types, err := splitSignature(elem[1 : len(elem)-1])
+		if err != nil {
+			return err
+		} else if len(types) != 2 {
+			return errors.New("invalid dict entry: " + elem)
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		for _, key := range keys {
+			e.align(8)
+			if err := e.encode(types[0], key.Interface()); err != nil {
+				return err
+			}
+			if err := e.encode(types[1], v.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+
+	} else {
+		if value != nil && v.Kind() != reflect.Slice {
+			return errors.Errorf("cannot encode %T as a%s", value, elem)
+		}
+
+		for idx := 0; value != nil && idx < v.Len(); idx++ {
+			if err := e.encode(elem, v.Index(idx).Interface()); err != nil {
+				return err
+			}
+		}
+	}
+
+	order.PutUint32(e.buf[lengthAt:], uint32(len(e.buf)-start))
+	return nil
+}
+
+type decoder struct {
+	buf    []byte
+	pos    int
+	order  binary.ByteOrder
+	offset int // the position of buf in the message, for alignment
+}
+
+func (d *decoder) align(n int) error {
+	for (d.offset+d.pos)%n != 0 {
+		d.pos++
+	}
+
+	if d.pos > len(d.buf) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if d.pos+n > len(d.buf) || n < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	data := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return data, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+
+	data, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(data), nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	if err := d.align(8); err != nil {
+		return 0, err
+	}
+
+	data, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint64(data), nil
+}
+
+func (d *decoder) decodeAll(sig string) ([]interface{}, error) {
+	types, err := splitSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(types))
+
+	for _, t := range types {
+		if value, err := d.decode(t, 0); err != nil {
+			return nil, err
+		} else {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+func (d *decoder) decode(t string, depth int) (interface{}, error) {
+	if depth > 64 {
+		return nil, errors.New("nesting too deep")
+	}
+
+	switch t[0] {
+	case 'y':
+		data, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return data[0], nil
+
+	case 'b':
+		v, err := d.uint32()
+		return v != 0, err
+
+	case 'n', 'q':
+		if err := d.align(2); err != nil {
+			return nil, err
+		}
+		data, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == 'n' {
+			return int16(d.order.Uint16(data)), nil
+		}
+		return d.order.Uint16(data), nil
+
+	case 'i':
+		v, err := d.uint32()
+		return int32(v), err
+
+	case 'u', 'h':
+		return d.uint32()
+
+	case 'x':
+		v, err := d.uint64()
+		return int64(v), err
+
+	case 't':
+		return d.uint64()
+
+	case 'd':
+		v, err := d.uint64()
+		return math.Float64frombits(v), err
+
+	case 's':
+		return d.string()
+
+	case 'o':
+		s, err := d.string()
+		return ObjectPath(s), err
+
+	case 'g':
+		s, err := d.signature()
+		return Signature(s), err
+
+	case 'v':
+		sig, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		if vt, err := nextType(sig); err != nil || vt != sig {
+			return nil, errors.New("invalid variant signature: " + sig)
+		}
+		value, err := d.decode(sig, depth+1)
+		return Variant{Signature: Signature(sig), Value: value}, err
+
+	case 'a':
+		return d.array(t[1:], depth)
+
+	case '(':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+
+		types, err := splitSignature(t[1 : len(t)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		var fields []interface{}
+		for _, ft := range types {
+			if value, err := d.decode(ft, depth+1); err != nil {
+				return nil, err
+			} else {
+				fields = append(fields, value)
+			}
+		}
+		return fields, nil
+
+	default:
+		return nil, errors.New("unsupported type: " + t)
+	}
+}
+
+func (d *decoder) string() (string, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := d.read(int(length) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(data[:length]), nil
+}
+
+func (d *decoder) signature() (string, error) {
+	length, err := d.read(1)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := d.read(int(length[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(data[:length[0]]), nil
+}
+
+func (d *decoder) array(elem string, depth int) (interface{}, error) {
+	length, err := d.uint32()
+	if err != nil {
+		return nil, err
+	} else if length > maxMessageSize {
+		return nil, errors.New("array too long")
+	}
+
+	if err := d.align(alignment(elem[0])); err != nil {
+		return nil, err
+	}
+
+	end := d.pos + int(length)
+	if end > len(d.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if elem == "y" {
+		data, err := d.read(int(length))
+		return append([]byte{}, data...), err
+	}
+
+	if elem[0] == '{' {
+		types, err := splitSignature(elem[1 : len(elem)-1])
+		if err != nil {
+			return nil, err
+		} else if len(types) != 2 {
+			return nil, errors.New("invalid dict entry: " + elem)
+		}
+
+		entries := map[interface{}]interface{}{}
+
+		for d.pos < end {
+			if err := d.align(8); err != nil {
+				return nil, err
+			}
+
+			key, err := d.decode(types[0], depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := d.decode(types[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+
+			entries[key] = value
+		}
+
+		return entries, nil
+	}
+
+	var items []interface{}
+
+	for d.pos < end {
+		item, err := d.decode(elem, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Marshal serializes the message in little-endian byte order
+func (m *Message) Marshal() ([]byte, error) {
+	body := &encoder{}
+	if m.Signature != "" {
+		if err := body.encodeAll(string(m.Signature), m.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := map[byte]Variant{}
+
+	if m.Path != "" {
+		fields[fieldPath] = Variant{"o", m.Path}
+	}
+	if m.Interface != "" {
+		fields[fieldInterface] = Variant{"s", m.Interface}
+	}
+	if m.Member != "" {
+		fields[fieldMember] = Variant{"s", m.Member}
+	}
+	if m.ErrorName != "" {
+		fields[fieldErrorName] = Variant{"s", m.ErrorName}
+	}
+	if m.ReplySerial != 0 {
+		fields[fieldReplySerial] = Variant{"u", m.ReplySerial}
+	}
+	if m.Destination != "" {
+		fields[fieldDestination] = Variant{"s", m.Destination}
+	}
+	if m.Sender != "" {
+		fields[fieldSender] = Variant{"s", m.Sender}
+	}
+	if m.Signature != "" {
+		fields[fieldSignature] = Variant{"g", m.Signature}
+	}
+	if m.UnixFds != 0 {
+		fields[fieldUnixFds] = Variant{"u", m.UnixFds}
+	}
+
+	var codes []int
+	for code := range fields {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	var headerFields []interface{}
+	for _, code := range codes {
+		headerFields = append(headerFields, []interface{}{byte(code), fields[byte(code)]})
+	}
+
+	header := &encoder{buf: []byte{'l', m.Type, m.Flags, 1}}
+	header.uint32(uint32(len(body.buf)))
+	header.uint32(m.Serial)
+
+	if err := header.encode("a(yv)", headerFields); err != nil {
+		return nil, err
+	}
+
+	header.align(8)
+
+	return append(header.buf, body.buf...), nil
+}
+
+// ReadMessage reads and parses the next message from the reader
+func ReadMessage(r io.Reader) (*Message, []byte, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, err
+	}
+
+	var byteOrder binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		byteOrder = binary.LittleEndian
+	case 'B':
+		byteOrder = binary.BigEndian
+	default:
+		return nil, nil, errors.New("invalid byte order in the message")
+	}
+
+	var (
+		bodyLength   = int(byteOrder.Uint32(fixed[4:]))
+		fieldsLength = int(byteOrder.Uint32(fixed[12:]))
+		headerLength = 16 + fieldsLength
+	)
+
+	if headerLength%8 != 0 {
+		headerLength += 8 - headerLength%8
+	}
+
+	if bodyLength > maxMessageSize || fieldsLength > maxMessageSize {
+		return nil, nil, errors.New("message too large")
+	}
+
+	raw := make([]byte, headerLength+bodyLength)
+	copy(raw, fixed)
+
+	if _, err := io.ReadFull(r, raw[16:]); err != nil {
+		return nil, nil, err
+	}
+
+	m, err := parseMessage(raw, byteOrder)
+	return m, raw, err
+}
+
+func parseMessage(raw []byte, byteOrder binary.ByteOrder) (*Message, error) {
+	m := &Message{
+		Type:   raw[1],
+		Flags:  raw[2],
+		Serial: byteOrder.Uint32(raw[8:]),
+	}
+
+	header := &decoder{buf: raw, pos: 12, order: byteOrder}
+
+	fields, err := header.decode("a(yv)", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields.([]interface{}) {
+		var (
+			code  = field.([]interface{})[0].(byte)
+			value = field.([]interface{})[1].(Variant).Value
+			ok    = true
+		)
+
+		switch code {
+		case fieldPath:
+			m.Path, ok = value.(ObjectPath)
+		case fieldInterface:
+			m.Interface, ok = value.(string)
+		case fieldMember:
+			m.Member, ok = value.(string)
+		case fieldErrorName:
+			m.ErrorName, ok = value.(string)
+		case fieldReplySerial:
+			m.ReplySerial, ok = value.(uint32)
+		case fieldDestination:
+			m.Destination, ok = value.(string)
+		case fieldSender:
+			m.Sender, ok = value.(string)
+		case fieldSignature:
+			m.Signature, ok = value.(Signature)
+		case fieldUnixFds:
+			m.UnixFds, ok = value.(uint32)
+		}
+
+		if !ok {
+			return nil, errors.Errorf("invalid type for header field %d", code)
+		}
+	}
+
+	if err := header.align(8); err != nil {
+		return nil, err
+	}
+
+	if m.Signature != "" {
+		body := &decoder{buf: raw[header.pos:], order: byteOrder}
+
+		if m.Body, err = body.decodeAll(string(m.Signature)); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,72 @@
+package dbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalAndRead(t *testing.T) {
+	m := NewMethodCall(
+		"org.freedesktop.Notifications", "/org/freedesktop/Notifications",
+		"org.freedesktop.Notifications", "Notify", "susssasa{sv}i",
+		"app", uint32(3), "icon", "Summary", "Body", []string{"default", "Open"},
+		map[string]Variant{"urgency": {Signature: "y", Value: byte(2)}}, int32(-1),
+	)
+	m.Serial = 7
+
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal("failed to marshal:", err)
+	}
+
+	parsed, raw, err := ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("failed to read:", err)
+	}
+
+	if !bytes.Equal(raw, data) {
+		t.Error("unexpected raw message")
+	}
+
+	if parsed.Serial != 7 || parsed.Member != "Notify" || parsed.Path != m.Path ||
+		parsed.Destination != m.Destination || parsed.Signature != m.Signature {
+		t.Errorf("unexpected header: %+v", parsed)
+	}
+
+	if len(parsed.Body) != 8 {
+		t.Fatal("unexpected body:", parsed.Body)
+	}
+
+	if parsed.Body[1].(uint32) != 3 || parsed.Body[4].(string) != "Body" || parsed.Body[7].(int32) != -1 {
+		t.Errorf("unexpected values: %v", parsed.Body)
+	}
+
+	if actions := parsed.Body[5].([]interface{}); len(actions) != 2 || actions[1] != "Open" {
+		t.Errorf("unexpected array: %v", actions)
+	}
+
+	hints := parsed.Body[6].(map[interface{}]interface{})
+	if urgency := hints["urgency"].(Variant); urgency.Signature != "y" || urgency.Value.(byte) != 2 {
+		t.Errorf("unexpected dict: %v", hints)
+	}
+}
+
+func TestSignatureMismatch(t *testing.T) {
+	m := NewMethodCall("a.b", "/", "a.b", "C", "su", "text", "not a number")
+
+	if _, err := m.Marshal(); err == nil {
+		t.Fatal("expected an error for mismatching values")
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	for address, expected := range map[string]string{
+		"unix:path=/run/user/1000/bus":                "/run/user/1000/bus",
+		"unix:abstract=/tmp/dbus-x,guid=1234":         "@/tmp/dbus-x",
+		"tcp:host=localhost;unix:path=/tmp/with%20sp": "/tmp/with sp",
+	} {
+		if path, err := SocketPath(address); err != nil || path != expected {
+			t.Errorf("unexpected path for %s: %s (%v)", address, path, err)
+		}
+	}
+}
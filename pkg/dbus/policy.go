@@ -0,0 +1,58 @@
+package dbus
+
+import "strings"
+
+// Access levels for bus names, each one including the ones before it
+const (
+	AccessNone = iota
+	AccessSee
+	AccessTalk
+	AccessOwn
+)
+
+// Policy decides which names a client of the proxy can see, talk to or own,
+// with rules like `org.example.App` or `org.example.*` (matching org.example and its sub-names)
+type Policy struct {
+	rules map[string]int
+}
+
+// NewPolicy creates a policy from the lists of names
+func NewPolicy(see, talk, own []string) *Policy {
+	p := &Policy{rules: map[string]int{}}
+
+	for level, names := range map[int][]string{AccessSee: see, AccessTalk: talk, AccessOwn: own} {
+		for _, name := range names {
+			if p.rules[name] < level {
+				p.rules[name] = level
+			}
+		}
+	}
+
+	return p
+}
+
+// Access returns the access level for a well-known name
+func (p *Policy) Access(name string) int {
+	if name == BusName {
+		return AccessTalk
+	}
+
+	access := AccessNone
+
+	for rule, level := range p.rules {
+		if level > access && matches(rule, name) {
+			access = level
+		}
+	}
+
+	return access
+}
+
+func matches(rule, name string) bool {
+	if strings.HasSuffix(rule, ".*") {
+		prefix := strings.TrimSuffix(rule, ".*")
+		return name == prefix || strings.HasPrefix(name, prefix+".")
+	}
+
+	return rule == name
+}
@@ -0,0 +1,26 @@
+package dbus
+
+import "testing"
+
+func TestPolicyAccess(t *testing.T) {
+	p := NewPolicy(
+		[]string{"org.example.Visible"},
+		[]string{"org.freedesktop.Notifications", "org.example.*"},
+		[]string{"org.example.App.*"},
+	)
+
+	for name, expected := range map[string]int{
+		BusName:                         AccessTalk,
+		"org.freedesktop.Notifications": AccessTalk,
+		"org.freedesktop.secrets":       AccessNone,
+		"org.example":                   AccessTalk,
+		"org.example.Visible":           AccessTalk,
+		"org.example.App":               AccessOwn,
+		"org.example.App.Window1":       AccessOwn,
+		"org.examples":                  AccessNone,
+	} {
+		if access := p.Access(name); access != expected {
+			t.Errorf("unexpected access for %s: %d", name, access)
+		}
+	}
+}
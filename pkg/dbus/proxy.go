@@ -0,0 +1,498 @@
+package dbus
+
+import (
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	errorAccessDenied   = "org.freedesktop.DBus.Error.AccessDenied"
+	errorNameHasNoOwner = "org.freedesktop.DBus.Error.NameHasNoOwner"
+
+	// serials of the messages generated by the proxy for its clients
+	firstProxySerial uint32 = 0x80000000
+)
+
+// reply filters for the calls of the clients
+const (
+	replyAsIs = iota
+	replyToHello
+	replyWithNames
+)
+
+// Proxy exposes a filtered view of a message bus on a unix socket,
+// each client getting its own connection to the bus
+type Proxy struct {
+	address  string
+	policy   *Policy
+	guid     string
+	listener net.Listener
+	names    *nameTracker
+}
+
+// proxyClient is a client connected to the proxy, with its connection to the bus
+type proxyClient struct {
+	proxy  *Proxy
+	client *Conn
+	bus    *Conn
+
+	lock     sync.Mutex
+	name     string
+	serial   uint32
+	pending  map[uint32]int  // the calls of the client waiting for replies
+	incoming map[string]bool // the calls to the client it may reply to
+}
+
+// NewProxy starts listening on the socket for clients of the bus at the address
+func NewProxy(address, socket string, policy *Policy) (*Proxy, error) {
+	names, err := newNameTracker(address)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		names.conn.Close()
+		return nil, err
+	}
+
+	// only the user of ddexec may connect, the directory of the socket is private too
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		names.conn.Close()
+		return nil, err
+	}
+
+	return &Proxy{
+		address:  address,
+		policy:   policy,
+		guid:     NewGUID(),
+		listener: l,
+		names:    names,
+	}, nil
+}
+
+// Serve accepts clients until the proxy is closed
+func (p *Proxy) Serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go p.serve(conn)
+	}
+}
+
+// Close stops accepting clients
+func (p *Proxy) Close() error {
+	p.names.conn.Close()
+	return p.listener.Close()
+}
+
+func (p *Proxy) serve(conn net.Conn) {
+	client, err := Accept(conn, p.guid, os.Getuid())
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	bus, err := Connect(p.address)
+	if err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("DBus proxy failed to connect to the bus:", err)
+		}
+
+		conn.Close()
+		return
+	}
+
+	c := &proxyClient{
+		proxy:    p,
+		client:   client,
+		bus:      bus,
+		serial:   firstProxySerial,
+		pending:  map[uint32]int{},
+		incoming: map[string]bool{},
+	}
+
+	go c.forwardFromBus()
+	c.forwardFromClient()
+}
+
+func (c *proxyClient) close() {
+	c.client.Close()
+	c.bus.Close()
+}
+
+// access returns the access level of the client to a well-known or unique name
+func (c *proxyClient) access(name string) int {
+	c.lock.Lock()
+	own := c.name
+	c.lock.Unlock()
+
+	if name == own {
+		return AccessOwn
+	}
+
+	if !strings.HasPrefix(name, ":") {
+		return c.proxy.policy.Access(name)
+	}
+
+	access := AccessNone
+
+	for _, owned := range c.proxy.names.ownedBy(name) {
+		if level := c.proxy.policy.Access(owned); level > access {
+			access = level
+		}
+	}
+
+	return access
+}
+
+func (c *proxyClient) reply(m *Message) {
+	c.lock.Lock()
+	c.serial++
+	m.Serial = c.serial
+	c.lock.Unlock()
+
+	m.Sender = BusName
+	c.client.Send(m)
+}
+
+func (c *proxyClient) deny(m *Message, name, message string) {
+	if debug.IsEnabled() {
+		fmt.Println("DBus proxy denied", m)
+	}
+
+	if m.ExpectsReply() {
+		c.reply(NewError(m, name, message))
+	}
+}
+
+func (c *proxyClient) forwardFromClient() {
+	defer c.close()
+
+	for {
+		m, raw, err := c.client.ReceiveRaw()
+		if err != nil {
+			return
+		}
+
+		if m.UnixFds > 0 {
+			c.deny(m, errorAccessDenied, "File descriptor passing is not supported")
+			continue
+		}
+
+		switch m.Type {
+		case TypeMethodCall:
+			if !c.allowCall(m) {
+				continue
+			}
+
+		case TypeMethodReturn, TypeError:
+			key := m.Destination + "/" + strconv.FormatUint(uint64(m.ReplySerial), 10)
+
+			c.lock.Lock()
+			allowed := c.incoming[key]
+			delete(c.incoming, key)
+			c.lock.Unlock()
+
+			if !allowed {
+				continue
+			}
+
+		case TypeSignal:
+			if m.Destination != "" && c.access(m.Destination) < AccessTalk {
+				continue
+			}
+		}
+
+		if err := c.bus.SendRaw(raw); err != nil {
+			return
+		}
+	}
+}
+
+// allowCall checks the method call of the client, answering it when it is denied
+func (c *proxyClient) allowCall(m *Message) bool {
+	filter := replyAsIs
+
+	if m.Destination == BusName {
+		var ok bool
+		if filter, ok = c.allowBusCall(m); !ok {
+			return false
+		}
+	} else if m.Destination == "" || c.access(m.Destination) < AccessTalk {
+		c.deny(m, errorAccessDenied, "Access to "+m.Destination+" is not allowed")
+		return false
+	}
+
+	if m.ExpectsReply() {
+		c.lock.Lock()
+		c.pending[m.Serial] = filter
+		c.lock.Unlock()
+	}
+
+	return true
+}
+
+func (c *proxyClient) allowBusCall(m *Message) (int, bool) {
+	var arg string
+	if len(m.Body) > 0 {
+		arg, _ = m.Body[0].(string)
+	}
+
+	switch m.Member {
+	case "Hello":
+		return replyToHello, true
+
+	case "GetId", "RemoveMatch", "Ping", "Introspect", "GetMachineId":
+		return replyAsIs, true
+
+	case "AddMatch":
+		if strings.Contains(arg, "eavesdrop") {
+			c.deny(m, errorAccessDenied, "Eavesdropping is not allowed")
+			return 0, false
+		}
+		return replyAsIs, true
+
+	case "ListNames", "ListActivatableNames":
+		return replyWithNames, true
+
+	case "RequestName", "ReleaseName":
+		if c.access(arg) < AccessOwn {
+			c.deny(m, errorAccessDenied, "Owning "+arg+" is not allowed")
+			return 0, false
+		}
+		return replyAsIs, true
+
+	case "StartServiceByName":
+		if c.access(arg) < AccessTalk {
+			c.deny(m, errorAccessDenied, "Starting "+arg+" is not allowed")
+			return 0, false
+		}
+		return replyAsIs, true
+
+	case "NameHasOwner":
+		if c.access(arg) < AccessSee {
+			if m.ExpectsReply() {
+				c.reply(NewReply(m, "b", false))
+			}
+			return 0, false
+		}
+		return replyAsIs, true
+
+	case "GetNameOwner", "ListQueuedOwners", "GetConnectionUnixUser", "GetConnectionUnixProcessID",
+		"GetConnectionCredentials", "GetAdtAuditSessionData", "GetConnectionSELinuxSecurityContext":
+		if c.access(arg) < AccessSee {
+			c.deny(m, errorNameHasNoOwner, "Could not get the owner of "+arg)
+			return 0, false
+		}
+		return replyAsIs, true
+
+	default:
+		c.deny(m, errorAccessDenied, "Calling "+m.Member+" on the bus is not allowed")
+		return 0, false
+	}
+}
+
+func (c *proxyClient) forwardFromBus() {
+	defer c.close()
+
+	for {
+		m, raw, err := c.bus.ReceiveRaw()
+		if err != nil {
+			return
+		}
+
+		switch m.Type {
+		case TypeMethodReturn, TypeError:
+			c.lock.Lock()
+			filter, ok := c.pending[m.ReplySerial]
+			delete(c.pending, m.ReplySerial)
+			c.lock.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			if filter == replyToHello && len(m.Body) > 0 {
+				c.lock.Lock()
+				c.name, _ = m.Body[0].(string)
+				c.lock.Unlock()
+			} else if filter == replyWithNames && m.Type == TypeMethodReturn {
+				if raw, err = c.filterNames(m); err != nil {
+					continue
+				}
+			}
+
+		case TypeSignal:
+			if !c.allowSignal(m) {
+				continue
+			}
+
+		case TypeMethodCall:
+			if c.access(m.Sender) < AccessTalk {
+				continue
+			}
+
+			if m.ExpectsReply() {
+				c.lock.Lock()
+				c.incoming[m.Sender+"/"+strconv.FormatUint(uint64(m.Serial), 10)] = true
+				c.lock.Unlock()
+			}
+		}
+
+		if err := c.client.SendRaw(raw); err != nil {
+			return
+		}
+	}
+}
+
+func (c *proxyClient) allowSignal(m *Message) bool {
+	if m.Sender != BusName {
+		return c.access(m.Sender) >= AccessTalk
+	}
+
+	switch m.Member {
+	case "NameAcquired", "NameLost":
+		return true
+
+	case "NameOwnerChanged":
+		if len(m.Body) == 0 {
+			return false
+		}
+
+		name, _ := m.Body[0].(string)
+		return c.access(name) >= AccessSee
+
+	default:
+		return false
+	}
+}
+
+// filterNames removes the names the client can not see from a ListNames reply
+func (c *proxyClient) filterNames(m *Message) ([]byte, error) {
+	if len(m.Body) > 0 {
+		names, _ := m.Body[0].([]interface{})
+
+		visible := []string{}
+		for _, name := range names {
+			if s, ok := name.(string); ok && c.access(s) >= AccessSee {
+				visible = append(visible, s)
+			}
+		}
+
+		m.Body[0] = visible
+	}
+
+	return m.Marshal()
+}
+
+// nameTracker follows the owners of the well-known names on the bus
+type nameTracker struct {
+	conn *Conn
+
+	lock   sync.Mutex
+	owners map[string]string
+}
+
+func newNameTracker(address string) (*nameTracker, error) {
+	conn, err := Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &nameTracker{conn: conn, owners: map[string]string{}}
+
+	if _, err := conn.Call(NewMethodCall(BusName, BusPath, BusInterface, "AddMatch", "s",
+		"type='signal',sender='org.freedesktop.DBus',interface='org.freedesktop.DBus',member='NameOwnerChanged'")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reply, err := conn.Call(NewMethodCall(BusName, BusPath, BusInterface, "ListNames", ""))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	pending := map[uint32]string{}
+
+	if len(reply.Body) > 0 {
+		names, _ := reply.Body[0].([]interface{})
+
+		for _, name := range names {
+			if s, ok := name.(string); ok && !strings.HasPrefix(s, ":") && s != BusName {
+				serial, err := conn.Send(NewMethodCall(BusName, BusPath, BusInterface, "GetNameOwner", "s", s))
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+
+				pending[serial] = s
+			}
+		}
+	}
+
+	go t.run(pending)
+
+	return t, nil
+}
+
+func (t *nameTracker) run(pending map[uint32]string) {
+	changed := map[string]bool{}
+
+	for {
+		m, err := t.conn.Receive()
+		if err != nil {
+			return
+		}
+
+		t.lock.Lock()
+
+		if m.Type == TypeSignal && m.Member == "NameOwnerChanged" && len(m.Body) == 3 {
+			name, _ := m.Body[0].(string)
+			owner, _ := m.Body[2].(string)
+
+			if owner == "" {
+				delete(t.owners, name)
+			} else if !strings.HasPrefix(name, ":") {
+				t.owners[name] = owner
+			}
+
+			changed[name] = true
+
+		} else if name, ok := pending[m.ReplySerial]; ok && m.ReplySerial != 0 {
+			delete(pending, m.ReplySerial)
+
+			// a change seen after the query is more recent than its reply
+			if m.Type == TypeMethodReturn && len(m.Body) > 0 && !changed[name] {
+				t.owners[name], _ = m.Body[0].(string)
+			}
+		}
+
+		t.lock.Unlock()
+	}
+}
+
+// ownedBy returns the well-known names owned by the unique name
+func (t *nameTracker) ownedBy(unique string) []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var names []string
+	for name, owner := range t.owners {
+		if owner == unique {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,139 @@
+package dbus
+
+import "fmt"
+
+// Message types
+const (
+	TypeMethodCall   byte = 1
+	TypeMethodReturn byte = 2
+	TypeError        byte = 3
+	TypeSignal       byte = 4
+)
+
+// Message flags
+const (
+	FlagNoReplyExpected byte = 0x1
+	FlagNoAutoStart     byte = 0x2
+)
+
+// Header field codes
+const (
+	fieldPath        byte = 1
+	fieldInterface   byte = 2
+	fieldMember      byte = 3
+	fieldErrorName   byte = 4
+	fieldReplySerial byte = 5
+	fieldDestination byte = 6
+	fieldSender      byte = 7
+	fieldSignature   byte = 8
+	fieldUnixFds     byte = 9
+)
+
+// Well-known names of the message bus
+const (
+	BusName      = "org.freedesktop.DBus"
+	BusPath      = ObjectPath("/org/freedesktop/DBus")
+	BusInterface = "org.freedesktop.DBus"
+)
+
+// ObjectPath is a value of the `o` type
+type ObjectPath string
+
+// Signature is a value of the `g` type
+type Signature string
+
+// Variant is a value of the `v` type
+type Variant struct {
+	Signature Signature
+	Value     interface{}
+}
+
+// Message is a DBus message with its header fields and body
+type Message struct {
+	Type   byte
+	Flags  byte
+	Serial uint32
+
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Signature   Signature
+	UnixFds     uint32
+
+	Body []interface{}
+}
+
+func (m *Message) String() string {
+	switch m.Type {
+	case TypeMethodCall:
+		return fmt.Sprintf("call %s.%s on %s at %s", m.Interface, m.Member, m.Path, m.Destination)
+	case TypeMethodReturn:
+		return fmt.Sprintf("return for %d to %s", m.ReplySerial, m.Destination)
+	case TypeError:
+		return fmt.Sprintf("error %s for %d to %s", m.ErrorName, m.ReplySerial, m.Destination)
+	case TypeSignal:
+		return fmt.Sprintf("signal %s.%s from %s", m.Interface, m.Member, m.Sender)
+	default:
+		return fmt.Sprintf("message of type %d", m.Type)
+	}
+}
+
+// ExpectsReply returns true for method calls waiting for a response
+func (m *Message) ExpectsReply() bool {
+	return m.Type == TypeMethodCall && m.Flags&FlagNoReplyExpected == 0
+}
+
+// NewMethodCall creates a method call message
+func NewMethodCall(destination string, path ObjectPath, iface, member string, signature Signature, args ...interface{}) *Message {
+	return &Message{
+		Type:        TypeMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: destination,
+		Signature:   signature,
+		Body:        args,
+	}
+}
+
+// NewReply creates a method return message for the call
+func NewReply(call *Message, signature Signature, args ...interface{}) *Message {
+	return &Message{
+		Type:        TypeMethodReturn,
+		Flags:       FlagNoReplyExpected,
+		ReplySerial: call.Serial,
+		Destination: call.Sender,
+		Signature:   signature,
+		Body:        args,
+	}
+}
+
+// NewError creates an error message for the call
+func NewError(call *Message, name, message string) *Message {
+	return &Message{
+		Type:        TypeError,
+		Flags:       FlagNoReplyExpected,
+		ErrorName:   name,
+		ReplySerial: call.Serial,
+		Destination: call.Sender,
+		Signature:   "s",
+		Body:        []interface{}{message},
+	}
+}
+
+// Error is an error reply received for a method call
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Name + ": " + e.Message
+	}
+	return e.Name
+}
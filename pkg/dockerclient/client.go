@@ -0,0 +1,72 @@
+package dockerclient
+
+import (
+	"context"
+	"github.com/docker/docker/client"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSocket is where the Docker daemon listens by default, and where the apps find the shared socket
+const DefaultSocket = "/var/run/docker.sock"
+
+// New connects to the Docker (compatible) daemon at DOCKER_HOST, or at the socket found by Socket
+func New() (*client.Client, error) {
+	opts := []func(*client.Client) error{client.FromEnv}
+
+	if os.Getenv("DOCKER_HOST") == "" {
+		if socket := Socket(); socket != DefaultSocket {
+			opts = append(opts, client.WithHost("unix://"+socket))
+		}
+	}
+
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cli.NegotiateAPIVersion(context.Background())
+
+	return cli, nil
+}
+
+// IsAvailable checks whether the Docker (compatible) daemon can be reached directly
+func IsAvailable() bool {
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return true // assume remote daemons are reachable
+	}
+
+	fi, err := os.Stat(Socket())
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// Socket returns the path to the Unix socket of the Docker (compatible) daemon,
+// looking for rootless Docker and Podman sockets if the default one does not exist
+func Socket() string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if strings.HasPrefix(host, "unix://") {
+			return strings.TrimPrefix(host, "unix://")
+		} else {
+			return "" // not a local socket
+		}
+	}
+
+	candidates := []string{DefaultSocket}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates,
+			filepath.Join(runtimeDir, "docker.sock"),
+			filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+
+	candidates = append(candidates, "/run/podman/podman.sock")
+
+	for _, candidate := range candidates {
+		if fi, err := os.Stat(candidate); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			return candidate
+		}
+	}
+
+	return DefaultSocket
+}
@@ -0,0 +1,96 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/rycus86/ddexec/pkg/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the stable path of the forwarded SSH agent socket in the container
+const sshAgentSocket = "/run/ddexec/ssh-agent.sock"
+
+// getGpgAgentExtraSocket returns the path to the restricted (extra) socket of the gpg-agent on the host,
+// which allows signing and decrypting but not managing the keys
+func getGpgAgentExtraSocket() string {
+	if output, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output(); err == nil {
+		if socket := socketIfExists(strings.TrimSpace(string(output))); socket != "" {
+			return socket
+		}
+	}
+
+	if runtimeDir := getHostRuntimeDir(); runtimeDir != "" {
+		if socket := socketIfExists(filepath.Join(runtimeDir, "gnupg", "S.gpg-agent.extra")); socket != "" {
+			return socket
+		}
+	}
+
+	return socketIfExists(filepath.Join(os.Getenv("HOME"), ".gnupg", "S.gpg-agent.extra"))
+}
+
+// getContainerGpgAgentDir returns where gpg in the container looks for the agent socket,
+// which is in the runtime directory when it exists (for both the private one and the XdbusUser volume)
+func getContainerGpgAgentDir() string {
+	return filepath.Join(getContainerRuntimeDir(), "gnupg")
+}
+
+// prepareAgentTmpfs returns a private directory for the gpg-agent socket on top of the shared XdbusUser volume,
+// so that the socket is not added to the runtime directory of the other apps
+func prepareAgentTmpfs(sc *config.StartupConfiguration) map[string]string {
+	if !sc.GpgAgent || !usesDBusVolume(sc) || getGpgAgentExtraSocket() == "" {
+		return nil
+	}
+
+	options := "mode=0700"
+
+	if sc.KeepUser {
+		// we don't know the numeric IDs of the image user
+		options = "mode=1777"
+	} else if uid, gid, _ := getPrivateFileOwnership(sc); uid != 0 || gid != 0 {
+		options += ",uid=" + strconv.Itoa(uid) + ",gid=" + strconv.Itoa(gid)
+	}
+
+	return map[string]string{getContainerGpgAgentDir(): options}
+}
+
+func prepareAgentMounts(sc *config.StartupConfiguration) []mount.Mount {
+	var mounts []mount.Mount
+
+	if sc.SshAgent {
+		if socket := socketIfExists(os.Getenv("SSH_AUTH_SOCK")); socket == "" {
+			fmt.Println("WARNING: No SSH agent found at SSH_AUTH_SOCK, the SSH agent is not shared")
+		} else {
+			mounts = append(mounts, mount.Mount{
+				Type:   mount.TypeBind,
+				Source: socket,
+				Target: sshAgentSocket,
+			})
+		}
+	}
+
+	if sc.GpgAgent {
+		if socket := getGpgAgentExtraSocket(); socket == "" {
+			fmt.Println("WARNING: No gpg-agent extra socket found, the GPG agent is not shared")
+		} else {
+			mounts = append(mounts, mount.Mount{
+				Type:   mount.TypeBind,
+				Source: socket,
+				Target: filepath.Join(getContainerGpgAgentDir(), "S.gpg-agent"),
+			})
+		}
+	}
+
+	return mounts
+}
+
+func prepareAgentEnvironment(sc *config.StartupConfiguration) []string {
+	if sc.SshAgent && socketIfExists(os.Getenv("SSH_AUTH_SOCK")) != "" {
+		return []string{"SSH_AUTH_SOCK=" + sshAgentSocket}
+	}
+
+	return nil
+}
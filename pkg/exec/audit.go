@@ -0,0 +1,23 @@
+package exec
+
+import (
+	"github.com/rycus86/ddexec/pkg/audit"
+)
+
+// auditContainer records a lifecycle action of the container of the app in the audit log
+func auditContainer(name, containerID, action string, arguments interface{}, result string) {
+	audit.Log(audit.Entry{
+		App:       name,
+		Container: containerID,
+		Action:    action,
+		Arguments: arguments,
+		Result:    result,
+	})
+}
+
+func auditResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return audit.ResultOK
+}
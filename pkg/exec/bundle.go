@@ -0,0 +1,377 @@
+package exec
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/lock"
+	"github.com/rycus86/ddexec/pkg/parse"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	bundleManifestName = "bundle.yml"
+	bundleImagesName   = "images.tar"
+)
+
+type bundleManifest struct {
+	Version int                         `yaml:"version"`
+	Created string                      `yaml:"created"`
+	Config  string                      `yaml:"config"`
+	Lock    string                      `yaml:"lock,omitempty"`
+	Apps    map[string]*bundledAppImage `yaml:"apps"`
+}
+
+type bundledAppImage struct {
+	Image     string `yaml:"image"`
+	Reference string `yaml:"reference"`
+	ID        string `yaml:"id"`
+	Digest    string `yaml:"digest,omitempty"`
+}
+
+// ExportBundle writes the images of the apps in the configuration,
+// along with the configuration and its lock file into a single archive
+func ExportBundle(configPath string, g *config.GlobalConfiguration, output string) int {
+	cli := newClient()
+	defer cli.Close()
+
+	lf := lock.Read(lock.PathFor(configPath))
+
+	manifest := bundleManifest{
+		Version: 1,
+		Created: time.Now().Format(time.RFC3339),
+		Config:  filepath.Base(configPath),
+		Apps:    map[string]*bundledAppImage{},
+	}
+
+	var references []string
+
+	for _, item := range Sorted(g) {
+		c := item.Config
+		if c.Name == "" {
+			c.Name = item.Name
+		}
+
+		bundled := &bundledAppImage{Image: c.Image}
+
+		if locked, ok := (*lf)[c.Name]; ok && locked.Image == c.Image {
+			bundled.Digest = locked.Digest
+		}
+
+		image, ok := inspectBundledImage(cli, c, bundled, (*lf)[c.Name])
+		if !ok {
+			fmt.Println("Error: The image for", c.Name, "was not found locally:", c.Image)
+			fmt.Println("Run (or pull) the app before exporting it")
+			return 1
+		}
+
+		bundled.ID = image.ID
+
+		if hasTag(image.RepoTags, c.Image) {
+			bundled.Reference = c.Image
+		} else {
+			bundled.Reference = image.ID
+		}
+
+		references = appendUnique(references, bundled.Reference)
+
+		manifest.Apps[c.Name] = bundled
+
+		fmt.Println("Exporting", c.Name, ":", bundled.Reference, "("+shortImageID(bundled.ID)+")")
+	}
+
+	imagesFile, err := ioutil.TempFile("", "ddexec*.images.tar")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(imagesFile.Name())
+	defer imagesFile.Close()
+
+	if reader, err := cli.ImageSave(context.Background(), references); err != nil {
+		panic(err)
+	} else {
+		defer reader.Close()
+
+		if _, err := io.Copy(imagesFile, reader); err != nil {
+			panic(err)
+		}
+	}
+
+	configContents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		panic(err)
+	}
+
+	lockContents, err := ioutil.ReadFile(lock.PathFor(configPath))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	} else if err == nil {
+		manifest.Lock = filepath.Base(lock.PathFor(configPath))
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		panic(err)
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+
+	manifestContents, err := yaml.Marshal(&manifest)
+	if err != nil {
+		panic(err)
+	}
+
+	writeBundleEntry(tw, bundleManifestName, int64(len(manifestContents)), bytes.NewReader(manifestContents))
+	writeBundleEntry(tw, manifest.Config, int64(len(configContents)), bytes.NewReader(configContents))
+
+	if manifest.Lock != "" {
+		writeBundleEntry(tw, manifest.Lock, int64(len(lockContents)), bytes.NewReader(lockContents))
+	}
+
+	if fi, err := imagesFile.Stat(); err != nil {
+		panic(err)
+	} else if _, err := imagesFile.Seek(0, io.SeekStart); err != nil {
+		panic(err)
+	} else {
+		writeBundleEntry(tw, bundleImagesName, fi.Size(), imagesFile)
+	}
+
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Bundle written to", output)
+
+	return 0
+}
+
+func inspectBundledImage(
+	cli *client.Client, c *config.AppConfiguration,
+	bundled *bundledAppImage, locked *lock.LockedImage) (types.ImageInspect, bool) {
+
+	var candidates []string
+
+	if locked != nil && locked.Image == c.Image {
+		if locked.Digest != "" {
+			candidates = append(candidates, locked.Reference())
+		}
+		if locked.ID != "" {
+			candidates = append(candidates, locked.ID)
+		}
+	}
+
+	candidates = append(candidates, c.Image)
+
+	for _, candidate := range candidates {
+		image, _, err := cli.ImageInspectWithRaw(context.Background(), candidate)
+		if err == nil {
+			return image, true
+		} else if !client.IsErrNotFound(err) {
+			panic(err)
+		}
+	}
+
+	return types.ImageInspect{}, false
+}
+
+func writeBundleEntry(tw *tar.Writer, name string, size int64, contents io.Reader) {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		panic(err)
+	}
+
+	if _, err := io.Copy(tw, contents); err != nil {
+		panic(err)
+	}
+}
+
+// ImportBundle loads the images from a bundle, verifies them
+// against the bundle manifest, then installs the configuration
+// into the first directory of the app search path
+func ImportBundle(input string, overwrite bool) int {
+	cli := newClient()
+	defer cli.Close()
+
+	in, err := os.Open(input)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+
+	var (
+		manifest     bundleManifest
+		files        = map[string][]byte{}
+		imagesLoaded bool
+	)
+
+	tr := tar.NewReader(in)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+
+		if hdr.Name == bundleImagesName {
+			if manifest.Version == 0 {
+				panic(errors.New("invalid bundle: the manifest has to come before the images"))
+			}
+
+			loadBundledImages(cli, tr)
+			imagesLoaded = true
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			panic(err)
+		}
+
+		if hdr.Name == bundleManifestName {
+			if err := yaml.Unmarshal(contents, &manifest); err != nil {
+				panic(err)
+			}
+		} else {
+			files[hdr.Name] = contents
+		}
+	}
+
+	if manifest.Version != 1 {
+		panic(errors.New(fmt.Sprintf("invalid bundle: unexpected version %d", manifest.Version)))
+	} else if !imagesLoaded {
+		panic(errors.New("invalid bundle: no images found"))
+	} else if files[manifest.Config] == nil {
+		panic(errors.New("invalid bundle: no configuration found"))
+	}
+
+	var names []string
+	for name := range manifest.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed bool
+
+	for _, name := range names {
+		if !verifyBundledImage(cli, name, manifest.Apps[name]) {
+			failed = true
+		}
+	}
+
+	if failed {
+		fmt.Println("Error: Failed to verify the images in the bundle, not installing the configuration")
+		return 1
+	}
+
+	searchPath := parse.SearchPath()
+	if len(searchPath) == 0 {
+		panic(errors.New("no directory found in the app search path"))
+	}
+
+	targetDir := searchPath[0]
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		panic(err)
+	}
+
+	configPath := filepath.Join(targetDir, filepath.Base(manifest.Config))
+
+	if _, err := os.Stat(configPath); err == nil && !overwrite {
+		fmt.Println("Error: The configuration already exists at", configPath, "(use --force to overwrite it)")
+		return 1
+	}
+
+	if err := ioutil.WriteFile(configPath, files[manifest.Config], 0644); err != nil {
+		panic(err)
+	}
+
+	lockPath := lock.PathFor(configPath)
+
+	lf := &lock.LockFile{}
+	if manifest.Lock != "" && files[manifest.Lock] != nil {
+		if err := yaml.Unmarshal(files[manifest.Lock], lf); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, name := range names {
+		bundled := manifest.Apps[name]
+
+		if bundled.Digest != "" {
+			// the repository digests are not kept by `docker save`, so we'll record the image IDs too
+			(*lf)[name] = &lock.LockedImage{
+				Image:  bundled.Image,
+				Digest: bundled.Digest,
+				ID:     bundled.ID,
+			}
+		}
+	}
+
+	if len(*lf) > 0 {
+		lock.Write(lockPath, lf)
+	}
+
+	fmt.Println("Configuration installed to", configPath)
+
+	return 0
+}
+
+func loadBundledImages(cli *client.Client, reader io.Reader) {
+	response, err := cli.ImageLoad(context.Background(), reader, true)
+	if err != nil {
+		panic(err)
+	}
+	defer response.Body.Close()
+
+	if response.JSON {
+		processPullMessages(response.Body)
+	} else if debug.IsEnabled() {
+		io.Copy(os.Stdout, response.Body)
+	}
+}
+
+func verifyBundledImage(cli *client.Client, name string, bundled *bundledAppImage) bool {
+	image, _, err := cli.ImageInspectWithRaw(context.Background(), bundled.Reference)
+	if err != nil {
+		fmt.Println(name, ": failed to find", bundled.Reference, "-", err)
+		return false
+	}
+
+	if image.ID != bundled.ID {
+		fmt.Println(name, ": image ID mismatch for", bundled.Reference)
+		fmt.Println("    expected:", bundled.ID)
+		fmt.Println("    found:   ", image.ID)
+		return false
+	}
+
+	fmt.Println(name, ": verified", bundled.Reference, "("+shortImageID(image.ID)+")")
+	return true
+}
+
+func appendUnique(items []string, item string) []string {
+	for _, existing := range items {
+		if existing == item {
+			return items
+		}
+	}
+
+	return append(items, item)
+}
@@ -2,8 +2,10 @@ package exec
 
 import (
 	"context"
+	"fmt"
 	"github.com/docker/docker/client"
 	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
 	"strings"
 )
 
@@ -16,6 +18,20 @@ func loadDaemonCapabilities(cli *client.Client, sc *config.StartupConfiguration)
 	for _, opt := range info.SecurityOptions {
 		if strings.Contains(opt, "name=seccomp") {
 			sc.DaemonHasSeccompSupport = true
+		} else if strings.Contains(opt, "name=rootless") {
+			sc.DaemonIsRootless = true
 		}
 	}
+
+	if version, err := cli.ServerVersion(context.Background()); err == nil {
+		for _, component := range version.Components {
+			if strings.Contains(strings.ToLower(component.Name), "podman") {
+				sc.DaemonIsPodman = true
+			}
+		}
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Daemon is rootless:", sc.DaemonIsRootless, "Daemon is Podman:", sc.DaemonIsPodman)
+	}
 }
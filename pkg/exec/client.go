@@ -1,17 +1,15 @@
 package exec
 
 import (
-	"context"
 	"github.com/docker/docker/client"
+	"github.com/rycus86/ddexec/pkg/dockerclient"
 )
 
 func newClient() *client.Client {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := dockerclient.New()
 	if err != nil {
 		panic(err)
 	}
 
-	cli.NegotiateAPIVersion(context.Background())
-
 	return cli
 }
@@ -0,0 +1,49 @@
+package exec
+
+import (
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/control"
+)
+
+// getControlPermissions returns what the app may do through the control socket, which is
+// everything except running commands in other containers besides their xdg-open handlers,
+// and launching other apps on the host unless configured otherwise
+func getControlPermissions(sc *config.StartupConfiguration) control.Permissions {
+	cc := config.ControlConfiguration{}
+	if sc.Control != nil {
+		cc = *sc.Control
+	}
+
+	return control.Permissions{
+		Mkdir:       isEnabledByDefault(cc.Mkdir),
+		CheckDevice: isEnabledByDefault(cc.CheckDevice),
+		Notify:      isEnabledByDefault(cc.Notify),
+		XdgOpen:     isEnabledByDefault(cc.XdgOpen),
+		RunCommand:  cc.RunCommand,
+		Launch:      cc.Launch,
+	}
+}
+
+func isEnabledByDefault(cfg *bool) bool {
+	return cfg == nil || *cfg
+}
+
+// issueControlToken creates the token the app uses to authenticate on the control socket
+func issueControlToken(c *config.AppConfiguration, sc *config.StartupConfiguration) func() {
+	token, err := control.IssueToken(c.Name, getControlPermissions(sc))
+	if err != nil {
+		panic(err)
+	}
+
+	sc.ControlToken = token
+
+	return func() {
+		control.RevokeToken(token)
+	}
+}
+
+func bindControlToken(containerID string, sc *config.StartupConfiguration) {
+	if err := control.BindToken(sc.ControlToken, containerID); err != nil {
+		panic(err)
+	}
+}
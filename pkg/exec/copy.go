@@ -23,8 +23,15 @@ type fileToCopy struct {
 	Header   *tar.Header
 }
 
-func copyFiles(cli *client.Client, containerID string, sc *config.StartupConfiguration) {
+func copyFiles(cli *client.Client, containerID string, sc *config.StartupConfiguration) *containerXauth {
 	var toCopy []fileToCopy
+	var x *containerXauth
+
+	if sc.KeepUser {
+		if err := resolveImageUser(cli, containerID, sc); err != nil {
+			fmt.Println("WARNING: Failed to find the IDs of the image user:", err)
+		}
+	}
 
 	if !sc.KeepUser {
 		passwdFiles := prepareUserAndGroupFiles(sc)
@@ -47,17 +54,23 @@ func copyFiles(cli *client.Client, containerID string, sc *config.StartupConfigu
 	// TODO condition?
 	toCopy = append(toCopy, fileToCopy{Source: getExecutable(), Target: "/usr/local/ddexec-xdg/bin/xdg-open"})
 
-	if !sc.DesktopMode {
-		if err := prepareXauth(); err != nil {
-			panic(err)
-		}
-
-		toCopy = append(toCopy, fileToCopy{Source: getXauth(), Target: getXauth()})
+	if !sc.DesktopMode && !isNestedDisplay(sc) && sharesX11(sc) {
+		x = newContainerXauth(sc)
+		toCopy = append(toCopy, x.prepareFile())
 	}
 
+	toCopy = append(toCopy, prepareSoundFiles(sc)...)
+	toCopy = append(toCopy, prepareNotificationFiles(sc)...)
+
 	if err := copyToContainer(cli, containerID, "/", toCopy...); err != nil {
+		if x != nil {
+			x.revoke()
+		}
+
 		panic(err)
 	}
+
+	return x
 }
 
 func copyToContainer(cli *client.Client, containerId string, dstPath string, files ...fileToCopy) error {
@@ -7,6 +7,7 @@ import (
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/rycus86/ddexec/pkg/audit"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/env"
 	"regexp"
@@ -19,17 +20,29 @@ func createContainer(
 	cli *client.Client, c *config.AppConfiguration, sc *config.StartupConfiguration,
 	env []string, mounts []mount.Mount, extraHosts []string) string {
 
-	if created, err := cli.ContainerCreate(
+	var (
+		containerConfig = newContainerConfig(c, sc, env)
+		name            = generateName(cli, c)
+	)
+
+	created, err := cli.ContainerCreate(
 		context.Background(),
-		newContainerConfig(c, sc, env),
+		containerConfig,
 		newHostConfig(c, sc, mounts, extraHosts),
 		&network.NetworkingConfig{},
-		generateName(cli, c),
-	); err != nil {
+		name,
+	)
+
+	auditContainer(c.Name, created.ID, audit.ContainerCreate, map[string]string{
+		"name":  name,
+		"image": containerConfig.Image,
+	}, auditResult(err))
+
+	if err != nil {
 		panic(err)
-	} else {
-		return created.ID
 	}
+
+	return created.ID
 }
 
 func generateName(cli *client.Client, c *config.AppConfiguration) string {
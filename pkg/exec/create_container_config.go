@@ -41,7 +41,7 @@ func newContainerConfig(c *config.AppConfiguration, sc *config.StartupConfigurat
 
 	var user string
 	if !sc.KeepUser {
-		user = getUserAndGroup()
+		user = getUserAndGroup(sc)
 	}
 
 	var stopTimeout *int
@@ -57,6 +57,9 @@ func newContainerConfig(c *config.AppConfiguration, sc *config.StartupConfigurat
 	for key, value := range c.Labels {
 		labels[key] = value
 	}
+	if sc.ControlToken != "" {
+		labels[control.LabelBinding] = control.BindingValue(sc.ControlToken)
+	}
 
 	exposed, _, err := nat.ParsePortSpecs(c.Ports)
 	if err != nil {
@@ -67,10 +70,13 @@ func newContainerConfig(c *config.AppConfiguration, sc *config.StartupConfigurat
 		fmt.Println("Running with command:", c.Command)
 	}
 
+	entrypoint, command := prepareNotificationEntrypoint(sc, command)
+
 	return &container.Config{
 		Image:        c.Image,
 		Env:          environment,
 		User:         user,
+		Entrypoint:   entrypoint,
 		Cmd:          command,
 		WorkingDir:   control.Target(c.WorkingDir, sc),
 		Labels:       labels,
@@ -25,7 +25,21 @@ func newHostConfig(
 
 	additionalGroups := c.GroupAdd
 
-	if !sc.KeepUser {
+	if sc.DaemonIsRootless {
+		warnAboutRootlessLimitations(c, sc)
+	}
+
+	var usernsMode container.UsernsMode
+	if isRootlessPodman(sc) && !sc.KeepUser {
+		usernsMode = "keep-id"
+	}
+
+	if !sc.KeepUser && sc.DaemonIsRootless {
+		if isRootlessPodman(sc) && len(additionalGroups) == 0 && needsHostGroups(sc) {
+			// keep the supplementary groups of the host user (for audio, video, etc.)
+			additionalGroups = []string{"keep-groups"}
+		}
+	} else if !sc.KeepUser {
 		hasDocker := false
 		hasAudio := false
 		hasVideo := false
@@ -46,7 +60,7 @@ func newHostConfig(
 		if sc.IsSet(sc.ShareDockerSocket) && !hasDocker {
 			additionalGroups = append(additionalGroups, "docker")
 		}
-		if sc.IsSet(sc.ShareSound) && !hasAudio {
+		if isAlsaShared(sc) && !hasAudio {
 			additionalGroups = append(additionalGroups, "audio")
 		}
 		if sc.IsSet(sc.ShareVideo) && !hasVideo {
@@ -71,7 +85,7 @@ func newHostConfig(
 			existingDevices[device] = true
 		}
 	}
-	if sc.IsSet(sc.ShareSound) && deviceExists("/dev/snd") && !existingDevices["/dev/snd"] {
+	if isAlsaShared(sc) && deviceExists("/dev/snd") && !existingDevices["/dev/snd"] {
 		devices = append(devices, container.DeviceMapping{
 			PathOnHost:        "/dev/snd",
 			PathInContainer:   "/dev/snd",
@@ -126,6 +140,18 @@ func newHostConfig(
 		}
 	}
 
+	for target, options := range prepareRuntimeDirTmpfs(sc) {
+		if _, ok := tmpfs[target]; !ok {
+			tmpfs[target] = options
+		}
+	}
+
+	for target, options := range prepareAgentTmpfs(sc) {
+		if _, ok := tmpfs[target]; !ok {
+			tmpfs[target] = options
+		}
+	}
+
 	_, ports, err := nat.ParsePortSpecs(c.Ports)
 	if err != nil {
 		panic(err)
@@ -134,6 +160,7 @@ func newHostConfig(
 	return &container.HostConfig{
 		AutoRemove: true,
 		Privileged: c.Privileged || sc.YubiKeySupport,
+		UsernsMode: usernsMode,
 		// TODO is Privileged absolutely necessary for starting X ?
 		// TODO can we have YubiKey support without Privileged ?
 		ReadonlyRootfs: c.ReadOnly,
@@ -168,6 +195,26 @@ func newHostConfig(
 	}
 }
 
+func needsHostGroups(sc *config.StartupConfiguration) bool {
+	return isAlsaShared(sc) || sc.IsSet(sc.ShareVideo) || sc.YubiKeySupport
+}
+
+func warnAboutRootlessLimitations(c *config.AppConfiguration, sc *config.StartupConfiguration) {
+	if sc.YubiKeySupport {
+		fmt.Println("WARNING: YubiKey support needs privileged mode, which can't access host USB devices with a rootless daemon")
+	} else if c.Privileged {
+		fmt.Println("WARNING: Privileged mode with a rootless daemon does not give access to host devices")
+	}
+
+	if sc.DesktopMode {
+		fmt.Println("WARNING: Starting an X desktop is unlikely to work with a rootless daemon")
+	}
+
+	if isRootlessDocker(sc) && needsHostGroups(sc) {
+		fmt.Println("WARNING: The host user's supplementary groups (audio, video, ...) are not available with rootless Docker")
+	}
+}
+
 func deviceExists(path string) bool {
 	if exists, err := control.CheckDevice(path); err != nil {
 		if debug.IsEnabled() {
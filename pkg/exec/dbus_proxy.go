@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/dbus"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const dbusProxySocketDir = "/tmp/.ddexec-dbus"
+
+// usesDBusProxy returns true when the app only gets a filtered view of the session bus
+func usesDBusProxy(sc *config.StartupConfiguration) bool {
+	return sc.IsSet(sc.ShareDBus) && sc.DBus != nil
+}
+
+// startDBusProxy starts a filtering proxy for the session bus with the policy of the app,
+// listening in a private directory, and returns a function to stop it
+func startDBusProxy(c *config.AppConfiguration, sc *config.StartupConfiguration) func() {
+	if !usesDBusProxy(sc) {
+		return func() {}
+	}
+
+	if sc.KeepUser {
+		fmt.Println("WARNING: the filtering DBus proxy only accepts the processes of the current user")
+	}
+
+	// private to the user, the proxy checks the credentials of the clients too
+	socketDir, err := ioutil.TempDir("", "ddexec-dbus")
+	if err != nil {
+		panic(err)
+	}
+
+	policy := dbus.NewPolicy(sc.DBus.See, sc.DBus.Talk, sc.DBus.Own)
+
+	proxy, err := dbus.NewProxy(dbus.SessionBusAddress(), filepath.Join(socketDir, "bus"), policy)
+	if err != nil {
+		os.RemoveAll(socketDir)
+		panic(err)
+	}
+
+	go proxy.Serve()
+
+	if debug.IsEnabled() {
+		fmt.Println("Started the filtering DBus proxy for", c.Name, "at", socketDir)
+	}
+
+	sc.DBusProxySocketDir = socketDir
+
+	return func() {
+		proxy.Close()
+		os.RemoveAll(socketDir)
+	}
+}
+
+func prepareDBusProxyMounts(sc *config.StartupConfiguration) []mount.Mount {
+	return []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: sc.DBusProxySocketDir,
+			Target: dbusProxySocketDir,
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/xdgopen"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	desktopEntriesDir = "/usr/share/applications"
+
+	// larger files are unlikely to be desktop entries
+	maxDesktopEntrySize = 64 * 1024
+)
+
+func usesXdgOpenAuto(sc *config.StartupConfiguration) bool {
+	return sc.XdgOpenAuto == nil || *sc.XdgOpenAuto
+}
+
+// loadDesktopMappings reads the .desktop files of the created container
+// to register its applications as xdg-open handlers for their mime types
+func loadDesktopMappings(cli *client.Client, containerID string, sc *config.StartupConfiguration) {
+	if !usesXdgOpenAuto(sc) {
+		return
+	}
+
+	entries, err := readDesktopEntries(cli, containerID)
+	if err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("Failed to read the desktop entries:", err)
+		}
+		return
+	}
+
+	sc.XdgOpenAutoMappings = xdgopen.DesktopMappings(entries)
+}
+
+func readDesktopEntries(cli *client.Client, containerID string) (map[string]*xdgopen.DesktopEntry, error) {
+	reader, _, err := cli.CopyFromContainer(context.Background(), containerID, desktopEntriesDir)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, nil // no applications in the image
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := map[string]*xdgopen.DesktopEntry{}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".desktop") || header.Size > maxDesktopEntrySize {
+			continue
+		}
+
+		entry, err := xdgopen.ParseDesktopEntry(tr)
+		if err != nil {
+			if debug.IsEnabled() {
+				fmt.Println("Failed to parse", header.Name+":", err)
+			}
+			continue
+		}
+
+		// the desktop file ID is the path relative to the applications directory
+		entries[strings.TrimPrefix(header.Name, path.Base(desktopEntriesDir)+"/")] = entry
+	}
+
+	return entries, nil
+}
@@ -19,8 +19,12 @@ const DDEXEC_ENV = "DDEXEC_ENV"
 func prepareEnvironment(c *config.AppConfiguration, sc *config.StartupConfiguration) []string {
 	var env []string
 
-	env = append(env, prepareDdexecEnvironment()...)
+	env = append(env, prepareDdexecEnvironment(sc)...)
 	env = append(env, prepareX11Environment(sc)...)
+	env = append(env, prepareWaylandEnvironment(sc)...)
+	env = append(env, prepareSoundEnvironment(sc)...)
+	env = append(env, prepareRuntimeDirEnvironment(sc)...)
+	env = append(env, prepareAgentEnvironment(sc)...)
 	env = append(env, prepareTimezoneEnvironment()...)
 	env = append(env, preparePathEnvironment(sc)...)
 	env = append(env, prepareTtySizeEnvironment(c, sc)...)
@@ -30,13 +34,19 @@ func prepareEnvironment(c *config.AppConfiguration, sc *config.StartupConfigurat
 	}
 
 	if sc.IsSet(sc.ShareDBus) {
-		env = append(env, prepareDBusEnvironment()...)
+		env = append(env, prepareDBusEnvironment(sc)...)
+	} else {
+		env = append(env, prepareNotificationEnvironment(c, sc)...)
 	}
 
 	env = append(env, convert.ToStringSlice(c.Environment)...)
 
 	if debug.IsEnabled() {
 		for _, e := range env {
+			if strings.HasPrefix(e, control.EnvControlToken+"=") {
+				e = control.EnvControlToken + "=<hidden>"
+			}
+
 			fmt.Println("env:", e)
 		}
 	}
@@ -44,11 +54,12 @@ func prepareEnvironment(c *config.AppConfiguration, sc *config.StartupConfigurat
 	return env
 }
 
-func prepareDdexecEnvironment() []string {
+func prepareDdexecEnvironment(sc *config.StartupConfiguration) []string {
 	env := []string{
 		DDEXEC_ENV + "=" + strconv.Itoa(1),
 		control.EnvHome + "=" + control.GetHostHome(),
 		control.EnvServerSocket + "=" + control.GetServerSocket(),
+		control.EnvControlToken + "=" + sc.ControlToken,
 		xdgopen.EnvControlDir + "=" + xdgopen.GetMappingDirectory(),
 	}
 
@@ -68,6 +79,8 @@ func prepareX11Environment(sc *config.StartupConfiguration) []string {
 
 	if sc.DesktopMode {
 		env = append(env, "XAUTHORITY=/tmp/.server.xauth")
+	} else if isNestedDisplay(sc) {
+		env = append(env, "DISPLAY=:"+getNestedDisplayNumber())
 	} else {
 		env = append(env, "DISPLAY="+os.Getenv("DISPLAY"))
 		env = append(env, "XAUTHORITY="+getXauth())
@@ -119,7 +132,13 @@ func prepareUserEnvironment() []string {
 	}
 }
 
-func prepareDBusEnvironment() []string {
+func prepareDBusEnvironment(sc *config.StartupConfiguration) []string {
+	if usesDBusProxy(sc) {
+		return []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + dbusProxySocketDir + "/bus"}
+	} else if usesHostSessionBus(sc) {
+		return []string{"DBUS_SESSION_BUS_ADDRESS=unix:path=" + getContainerRuntimeDir() + "/bus"}
+	}
+
 	var env []string
 
 	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
@@ -17,13 +17,22 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
+const (
+	labelBuiltAt        = "com.github.rycus86.ddexec.built_at"
+	labelDockerfileHash = "com.github.rycus86.ddexec.dockerfile.hash"
+	labelImageName      = "com.github.rycus86.ddexec.image"
+	labelConfigPath     = "com.github.rycus86.ddexec.config"
+)
+
 func prepareAndProcessImage(cli *client.Client, c *config.AppConfiguration, sc *config.StartupConfiguration) {
 	var (
 		image             types.ImageInspect
+		pinned            = usePinnedImage(c, sc)
 		shouldBuildOrPull = shouldPullImage()
 
 		err error
@@ -32,44 +41,22 @@ func prepareAndProcessImage(cli *client.Client, c *config.AppConfiguration, sc *
 	if !shouldBuildOrPull {
 		image, _, err = cli.ImageInspectWithRaw(context.Background(), c.Image)
 		if err != nil {
-			if client.IsErrNotFound(err) {
-				shouldBuildOrPull = true
-			} else {
+			if !client.IsErrNotFound(err) {
 				panic(err)
+			} else if pinnedImage, ok := usePinnedImageID(cli, c, pinned); ok {
+				image = pinnedImage
+			} else {
+				shouldBuildOrPull = true
 			}
 		}
 	}
 
 	if shouldBuildOrPull {
 		if c.Dockerfile != "" {
-			buildImage(cli, c)
+			buildImage(cli, c, sc)
 		} else {
-			if debug.IsEnabled() {
-				fmt.Println("Pulling image for", c.Image, "...")
-			}
-
-			if reader, err := cli.ImagePull(
-				context.Background(),
-				c.Image, // TODO maybe allow having the image name empty and default to the filename
-				types.ImagePullOptions{}); err != nil {
-				panic(err)
-			} else {
-				defer reader.Close()
-
-				var pullMessage jsonmessage.JSONMessage
-				for {
-					if err := json.NewDecoder(reader).Decode(&pullMessage); err != nil {
-						break // TODO probably should check if this was EOF or something
-					}
-
-					if pullMessage.Error != nil {
-						panic(pullMessage.Error.Error())
-					} else if debug.IsEnabled() {
-						_, isTerminal := term.GetFdInfo(os.Stdout)
-						pullMessage.Display(os.Stdout, isTerminal)
-					}
-				}
-			}
+			// TODO maybe allow having the image name empty and default to the filename
+			pullImage(cli, c.Image)
 		}
 
 		if image, _, err = cli.ImageInspectWithRaw(context.Background(), c.Image); err != nil {
@@ -77,23 +64,29 @@ func prepareAndProcessImage(cli *client.Client, c *config.AppConfiguration, sc *
 		}
 	}
 
+	if pinned == nil {
+		recordPinnedImage(c, sc, image)
+	}
+
 	if c.Dockerfile != "" {
 		hash := hashDockerfile(c.Dockerfile)
 
-		if prevHash, ok := image.Config.Labels["com.github.rycus86.ddexec.dockerfile.hash"]; ok && hash == prevHash {
+		if prevHash, ok := image.Config.Labels[labelDockerfileHash]; ok && hash == prevHash {
 			// OK, we're up to date
 		} else {
-			buildImage(cli, c)
+			buildImage(cli, c, sc)
 
 			if image, _, err = cli.ImageInspectWithRaw(context.Background(), c.Image); err != nil {
 				panic(err)
-			} else if image.Config.Labels["com.github.rycus86.ddexec.dockerfile.hash"] != hash {
+			} else if image.Config.Labels[labelDockerfileHash] != hash {
 				panic(errors.New("the new image hash does not match the Dockerfile contents"))
 			}
 		}
 	}
 
 	sc.ImageUser = image.Config.User
+	sc.ImageEntrypoint = image.Config.Entrypoint
+	sc.ImageCmd = image.Config.Cmd
 
 	for _, item := range image.Config.Env {
 		if strings.HasPrefix(item, "PATH=") {
@@ -106,18 +99,27 @@ func prepareAndProcessImage(cli *client.Client, c *config.AppConfiguration, sc *
 	}
 }
 
-func buildImage(cli *client.Client, c *config.AppConfiguration) {
+func buildImage(cli *client.Client, c *config.AppConfiguration, sc *config.StartupConfiguration) {
 	if debug.IsEnabled() {
 		fmt.Println("Building image for", c.Image, "...")
 	}
 
 	bctx := prepareBuildContext(c)
 
+	labels := map[string]string{
+		labelBuiltAt:        time.Now().Format(time.RFC3339),
+		labelDockerfileHash: hashDockerfile(c.Dockerfile),
+		labelImageName:      c.Image,
+	}
+
+	if sc.ConfigPath != "" {
+		if configPath, err := filepath.Abs(sc.ConfigPath); err == nil {
+			labels[labelConfigPath] = configPath
+		}
+	}
+
 	if response, err := cli.ImageBuild(context.Background(), bctx, types.ImageBuildOptions{
-		Labels: map[string]string{
-			"com.github.rycus86.ddexec.built_at":        time.Now().Format(time.RFC3339),
-			"com.github.rycus86.ddexec.dockerfile.hash": hashDockerfile(c.Dockerfile), // TODO const label key
-		},
+		Labels:      labels,
 		Tags:        []string{c.Image}, // TODO infer image name from filename if empty?
 		Remove:      true,
 		ForceRemove: true,
@@ -172,6 +174,22 @@ func prepareBuildContext(c *config.AppConfiguration) io.Reader {
 	}
 }
 
+func processPullMessages(reader io.Reader) {
+	var pullMessage jsonmessage.JSONMessage
+	for {
+		if err := json.NewDecoder(reader).Decode(&pullMessage); err != nil {
+			break // TODO probably should check if this was EOF or something
+		}
+
+		if pullMessage.Error != nil {
+			panic(pullMessage.Error.Error())
+		} else if debug.IsEnabled() {
+			_, isTerminal := term.GetFdInfo(os.Stdout)
+			pullMessage.Display(os.Stdout, isTerminal)
+		}
+	}
+}
+
 func shouldPullImage() bool {
 	return env.IsSet("DDEXEC_PULL") || env.IsSet("DDEXEC_REBUILD")
 }
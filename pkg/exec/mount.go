@@ -8,6 +8,7 @@ import (
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/control"
 	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/dockerclient"
 	"github.com/rycus86/ddexec/pkg/volume"
 	"github.com/rycus86/ddexec/pkg/xdgopen"
 	"os"
@@ -32,15 +33,17 @@ func prepareMounts(c *config.AppConfiguration, sc *config.StartupConfiguration)
 		})
 	}
 
-	if sc.IsSet(sc.ShareDockerSocket) {
+	if sc.IsSet(sc.ShareDockerSocket) && dockerclient.Socket() != "" {
 		mountList = append(mountList, mount.Mount{
 			Type:   mount.TypeBind,
-			Source: "/var/run/docker.sock",
-			Target: "/var/run/docker.sock",
+			Source: dockerclient.Socket(),
+			Target: dockerclient.DefaultSocket,
 		})
 	}
 
-	if sc.UseHostX11 {
+	if isNestedDisplay(sc) {
+		mountList = append(mountList, prepareNestedDisplayMounts(sc)...)
+	} else if sc.UseHostX11 {
 		mountList = append(mountList, mount.Mount{
 			Type:   mount.TypeBind,
 			Source: "/tmp/.X11-unix",
@@ -54,18 +57,22 @@ func prepareMounts(c *config.AppConfiguration, sc *config.StartupConfiguration)
 		})
 	}
 
+	mountList = append(mountList, prepareWaylandMounts(sc)...)
+	mountList = append(mountList, prepareSoundMounts(sc)...)
+	mountList = append(mountList, prepareRuntimeDirMounts(sc)...)
+	mountList = append(mountList, prepareAgentMounts(sc)...)
+	mountList = append(mountList, prepareSecretMounts(sc)...)
+
 	if sc.IsSet(sc.ShareDBus) {
-		if sc.UseHostDBus {
+		if usesDBusProxy(sc) {
+			mountList = append(mountList, prepareDBusProxyMounts(sc)...)
+		} else if sc.UseHostDBus {
+			// the session bus socket is added to the private runtime directory
 			mountList = append(mountList, mount.Mount{
 				Type:   mount.TypeBind,
 				Source: "/run/dbus",
 				Target: "/run/dbus",
 			})
-			mountList = append(mountList, mount.Mount{
-				Type:   mount.TypeBind,
-				Source: "/run/user/" + strconv.Itoa(os.Getuid()),
-				Target: "/run/user/" + strconv.Itoa(os.Getuid()),
-			})
 		} else {
 			mountList = append(mountList, mount.Mount{
 				Type:   mount.TypeVolume,
@@ -0,0 +1,307 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/audit"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/control"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/xauth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DisplayHost   = "host"
+	DisplayNested = "nested"
+
+	NestedServerXephyr = "xephyr"
+	NestedServerXpra   = "xpra"
+	NestedServerXvfb   = "xvfb"
+
+	nestedDisplayImage      = "ddexec/nested-display:latest"
+	nestedDisplayDockerfile = `
+FROM debian:bookworm-slim
+RUN apt-get update \
+ && apt-get install -y --no-install-recommends xserver-xephyr xvfb xpra xauth \
+ && rm -rf /var/lib/apt/lists/*
+`
+
+	nestedDisplayStartTimeout = 10 * time.Second
+)
+
+func isNestedDisplay(sc *config.StartupConfiguration) bool {
+	switch sc.Display {
+	case "", DisplayHost:
+		return false
+	case DisplayNested:
+		return true
+	default:
+		panic(errors.New("unknown display mode: " + sc.Display))
+	}
+}
+
+func getNestedDisplayNumber() string {
+	if _, number := xauth.ParseDisplay(os.Getenv("DISPLAY")); number == "99" {
+		return "100"
+	} else {
+		return "99"
+	}
+}
+
+func getNestedDisplayConfiguration(sc *config.StartupConfiguration) config.NestedDisplayConfiguration {
+	nc := config.NestedDisplayConfiguration{}
+	if sc.NestedDisplay != nil {
+		nc = *sc.NestedDisplay
+	}
+
+	if nc.Server == "" {
+		nc.Server = NestedServerXephyr
+	}
+	if nc.Size == "" {
+		nc.Size = "1280x800"
+	}
+	if nc.Dpi == 0 {
+		nc.Dpi = 96
+	}
+
+	return nc
+}
+
+// startNestedDisplay starts a companion container running a separate X server for the app,
+// sharing its socket through a private directory, and returns a function to tear it down
+func startNestedDisplay(cli *client.Client, c *config.AppConfiguration, sc *config.StartupConfiguration) func() {
+	if !isNestedDisplay(sc) {
+		if getClipboardPolicy(sc) != control.ClipboardNone && sc.X11Trust == X11TrustUntrusted {
+			panic(errors.New("the clipboard is only bridged to nested displays, untrusted X11 clients share the selections " +
+				"of the host X server that they can not access, use the nested display or remove clipboard: " + sc.Clipboard))
+		}
+
+		return func() {}
+	}
+
+	if sc.KeepUser {
+		panic(errors.New("the nested display is not supported with keep_user, the user of the image could not connect to it"))
+	}
+
+	nc := getNestedDisplayConfiguration(sc)
+
+	// private to the user, the X servers accept every client that can reach their socket
+	socketDir, err := ioutil.TempDir("", "ddexec-display")
+	if err != nil {
+		panic(err)
+	}
+
+	dc := &config.AppConfiguration{
+		Name:  c.Name + "-display",
+		Image: nc.Image,
+	}
+
+	if dc.Image == "" {
+		dc.Image = nestedDisplayImage
+		dc.Dockerfile = strings.TrimSpace(nestedDisplayDockerfile)
+	}
+
+	dsc := &config.StartupConfiguration{
+		DaemonIsRootless: sc.DaemonIsRootless,
+		DaemonIsPodman:   sc.DaemonIsPodman,
+	}
+
+	prepareAndProcessImage(cli, dc, dsc)
+
+	usesHostDisplay := nc.Server != NestedServerXvfb
+	hostDisplay := os.Getenv("DISPLAY")
+
+	var mounts = []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: socketDir,
+			Target: "/tmp/.X11-unix",
+		},
+	}
+
+	var env = []string{"HOME=/tmp"} // the server runs as a user without a home directory
+
+	if usesHostDisplay {
+		host, number := xauth.ParseDisplay(hostDisplay)
+		if number == "" {
+			panic(errors.New("the " + nc.Server + " nested display needs a host display"))
+		}
+
+		if host == "" {
+			mounts = append(mounts, mount.Mount{
+				Type:   mount.TypeBind,
+				Source: "/tmp/.X11-unix/X" + number,
+				Target: "/tmp/.X11-unix/X" + number,
+			})
+		}
+
+		env = append(env, "DISPLAY="+hostDisplay, "XAUTHORITY="+getXauth())
+	}
+
+	created, err := cli.ContainerCreate(
+		context.Background(),
+		&container.Config{
+			Image: dc.Image,
+			Cmd:   getNestedDisplayCommand(c, nc),
+			Env:   env,
+			User:  getUserAndGroup(dsc), // the owner of the socket directory
+			Labels: map[string]string{
+				"com.github.rycus86.ddexec.name":    dc.Name,
+				"com.github.rycus86.ddexec.version": config.GetVersion(),
+			},
+		},
+		&container.HostConfig{
+			AutoRemove: true,
+			Mounts:     mounts,
+			UsernsMode: getNestedDisplayUsernsMode(dsc),
+		},
+		&network.NetworkingConfig{},
+		generateName(cli, dc),
+	)
+
+	auditContainer(dc.Name, created.ID, audit.ContainerCreate, map[string]string{"image": dc.Image}, auditResult(err))
+
+	if err != nil {
+		os.RemoveAll(socketDir)
+		panic(err)
+	}
+
+	var (
+		clipboard *control.ClipboardBridge
+		stopped   sync.Once
+	)
+
+	// called both when the app exits and by its closer
+	stop := func() {
+		stopped.Do(func() {
+			if debug.IsEnabled() {
+				fmt.Println("Stopping the nested display for", c.Name)
+			}
+
+			if clipboard != nil {
+				clipboard.Stop()
+			}
+
+			err := cli.ContainerStop(context.Background(), created.ID, nil)
+			auditContainer(dc.Name, created.ID, audit.ContainerStop, nil, auditResult(err))
+
+			os.RemoveAll(socketDir)
+		})
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			stop()
+			panic(err)
+		}
+	}()
+
+	if usesHostDisplay {
+		x := &containerXauth{sc: dsc}
+		if err := copyToContainer(cli, created.ID, "/", x.prepareFile()); err != nil {
+			panic(err)
+		}
+	}
+
+	startContainer(cli, created.ID, dc.Name)
+
+	socket := filepath.Join(socketDir, "X"+getNestedDisplayNumber())
+
+	for started := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+		if socketIfExists(socket) != "" {
+			break
+		} else if time.Since(started) > nestedDisplayStartTimeout {
+			panic(errors.New("the nested display did not start in time"))
+		}
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Started the nested", nc.Server, "display for", c.Name, "at", socket)
+	}
+
+	sc.NestedDisplaySocketDir = socketDir
+
+	if policy := getClipboardPolicy(sc); policy != control.ClipboardNone {
+		if bridge, err := control.StartClipboardBridge(c.Name, socket, policy, sc.ClipboardConfirm); err != nil {
+			fmt.Println("WARNING: Failed to share the clipboard with the nested display:", err)
+		} else {
+			clipboard = bridge
+		}
+	}
+
+	return stop
+}
+
+func getNestedDisplayUsernsMode(dsc *config.StartupConfiguration) container.UsernsMode {
+	if isRootlessPodman(dsc) {
+		return "keep-id"
+	}
+
+	return ""
+}
+
+// getClipboardPolicy returns the directions the clipboard is bridged in, none unless configured
+func getClipboardPolicy(sc *config.StartupConfiguration) string {
+	if sc.Clipboard == "" {
+		return control.ClipboardNone
+	}
+
+	return sc.Clipboard
+}
+
+func getNestedDisplayCommand(c *config.AppConfiguration, nc config.NestedDisplayConfiguration) []string {
+	var (
+		display = ":" + getNestedDisplayNumber()
+		dpi     = strconv.Itoa(nc.Dpi)
+	)
+
+	switch nc.Server {
+	case NestedServerXephyr:
+		return []string{
+			"Xephyr", display, "-screen", nc.Size, "-dpi", dpi, "-title", c.Name,
+			"-resizeable", "-nolisten", "tcp",
+			"-ac", // the socket is only shared with the app container, in a private directory
+		}
+
+	case NestedServerXvfb:
+		return []string{
+			"Xvfb", display, "-screen", "0", nc.Size + "x24", "-dpi", dpi,
+			"-nolisten", "tcp", "-ac",
+		}
+
+	case NestedServerXpra:
+		xvfb := "Xvfb +extension Composite -screen 0 " + nc.Size + "x24+32 -dpi " + dpi + " -nolisten tcp -noreset -ac"
+
+		// the server and the client attached to it in one process, without a shell
+		return []string{
+			"xpra", "start", display, "--daemon=no", "--attach=yes", "--xvfb=" + xvfb, "--dpi=" + dpi,
+			"--mdns=no", "--pulseaudio=no", "--notifications=no", "--webcam=no", "--systemd-run=no",
+			"--title=" + c.Name + ": @title@",
+		}
+
+	default:
+		panic(errors.New("unknown nested display server: " + nc.Server))
+	}
+}
+
+func prepareNestedDisplayMounts(sc *config.StartupConfiguration) []mount.Mount {
+	return []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: sc.NestedDisplaySocketDir,
+			Target: "/tmp/.X11-unix",
+		},
+	}
+}
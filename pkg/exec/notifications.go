@@ -0,0 +1,50 @@
+package exec
+
+import (
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/notify"
+)
+
+const notificationServiceExecutable = "/usr/local/ddexec-xdg/bin/" + notify.ExecutableName
+
+// forwardsNotifications returns true when the app gets a private bus for notifications,
+// which is only necessary when the session bus is not shared
+func forwardsNotifications(sc *config.StartupConfiguration) bool {
+	return sc.ForwardNotifications && !sc.IsSet(sc.ShareDBus)
+}
+
+func prepareNotificationFiles(sc *config.StartupConfiguration) []fileToCopy {
+	if !forwardsNotifications(sc) {
+		return nil
+	}
+
+	return []fileToCopy{{Source: getExecutable(), Target: notificationServiceExecutable}}
+}
+
+func prepareNotificationEnvironment(c *config.AppConfiguration, sc *config.StartupConfiguration) []string {
+	if !forwardsNotifications(sc) {
+		return nil
+	}
+
+	return []string{
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + notify.BusSocket,
+		notify.EnvAppName + "=" + c.Name,
+	}
+}
+
+// prepareNotificationEntrypoint starts the app through the notification service, which starts the bus
+// in the background, then replaces itself with the app once the bus is listening,
+// so that the notifications sent right after the start are not lost
+func prepareNotificationEntrypoint(sc *config.StartupConfiguration, command []string) ([]string, []string) {
+	if !forwardsNotifications(sc) {
+		return nil, command
+	}
+
+	if len(command) == 0 {
+		command = sc.ImageCmd // not inherited from the image when the entrypoint is changed
+	}
+
+	entrypoint := append([]string{notificationServiceExecutable, "--"}, sc.ImageEntrypoint...)
+
+	return entrypoint, command
+}
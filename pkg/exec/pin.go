@@ -0,0 +1,243 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/lock"
+)
+
+// UpdatesAvailable is the exit code of checking for updates when any of the apps has a newer image
+const UpdatesAvailable = 100
+
+func usePinnedImage(c *config.AppConfiguration, sc *config.StartupConfiguration) *lock.LockedImage {
+	if !sc.PinDigest || c.Dockerfile != "" || sc.ConfigPath == "" {
+		return nil
+	}
+
+	lf := lock.Read(lock.PathFor(sc.ConfigPath))
+
+	if locked, ok := (*lf)[c.Name]; ok && locked.Image == c.Image && locked.Digest != "" {
+		if debug.IsEnabled() {
+			fmt.Println("Using pinned image for", c.Name, ":", locked.Reference())
+		}
+
+		c.Image = locked.Reference()
+		return locked
+	}
+
+	return nil
+}
+
+// usePinnedImageID falls back to the image ID of the pinned image,
+// that's all we have for images loaded from a bundle (with no repository digests)
+func usePinnedImageID(cli *client.Client, c *config.AppConfiguration, locked *lock.LockedImage) (types.ImageInspect, bool) {
+	if locked == nil || locked.ID == "" {
+		return types.ImageInspect{}, false
+	}
+
+	image, _, err := cli.ImageInspectWithRaw(context.Background(), locked.ID)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return image, false
+		} else {
+			panic(err)
+		}
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Using pinned image ID for", c.Name, ":", locked.ID)
+	}
+
+	c.Image = locked.ID
+	return image, true
+}
+
+func recordPinnedImage(c *config.AppConfiguration, sc *config.StartupConfiguration, image types.ImageInspect) {
+	if !sc.PinDigest || c.Dockerfile != "" || sc.ConfigPath == "" {
+		return
+	}
+
+	digest := findRepoDigest(c.Image, image)
+	if digest == "" {
+		fmt.Println("WARNING: Could not find the repository digest for", c.Image, "(is it a local image?)")
+		return
+	}
+
+	lockPath := lock.PathFor(sc.ConfigPath)
+
+	lf := lock.Read(lockPath)
+	(*lf)[c.Name] = &lock.LockedImage{
+		Image:  c.Image,
+		Digest: digest,
+		ID:     image.ID,
+	}
+	lock.Write(lockPath, lf)
+
+	if debug.IsEnabled() {
+		fmt.Println("Pinned", c.Image, "to", digest, "in", lockPath)
+	}
+
+	c.Image = (*lf)[c.Name].Reference()
+}
+
+// findRepoDigest returns the digest of the image in the repository of the image name,
+// comparing the normalized names, as docker.io/library/alpine and alpine are the same repository
+func findRepoDigest(imageName string, image types.ImageInspect) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return ""
+	}
+
+	for _, repoDigest := range image.RepoDigests {
+		if parsed, err := reference.ParseNormalizedNamed(repoDigest); err != nil {
+			continue
+		} else if canonical, ok := parsed.(reference.Canonical); ok && parsed.Name() == named.Name() {
+			return canonical.Digest().String()
+		}
+	}
+
+	return ""
+}
+
+// Update refreshes the lock file of the configuration for the given (or all pinned) apps,
+// returning 1 when any of them failed, or with checkOnly, UpdatesAvailable when any of them has a newer image
+func Update(configPath string, g *config.GlobalConfiguration, apps []string, checkOnly bool) int {
+	cli := newClient()
+	defer cli.Close()
+
+	lockPath := lock.PathFor(configPath)
+	lf := lock.Read(lockPath)
+
+	var changed, failed, available bool
+
+	for _, item := range Sorted(g) {
+		if !isSelected(item.Name, apps) {
+			continue
+		}
+
+		c := item.Config
+		if c.Name == "" {
+			c.Name = item.Name
+		}
+
+		if c.StartupConfiguration == nil || !c.StartupConfiguration.PinDigest {
+			if len(apps) > 0 {
+				fmt.Println(c.Name, ": not pinned, skipping")
+			}
+			continue
+		}
+
+		if c.Dockerfile != "" {
+			fmt.Println(c.Name, ": built from a Dockerfile, skipping")
+			continue
+		}
+
+		remote, err := cli.DistributionInspect(context.Background(), c.Image, "")
+		if err != nil {
+			fmt.Println(c.Name, ": failed to check", c.Image, "-", err)
+			failed = true
+			continue
+		}
+
+		remoteDigest := remote.Descriptor.Digest.String()
+
+		locked, ok := (*lf)[c.Name]
+		if ok && locked.Image == c.Image && locked.Digest == remoteDigest {
+			fmt.Println(c.Name, ": up to date", "("+c.Image+")")
+			continue
+		}
+
+		if ok && locked.Image == c.Image {
+			fmt.Println(c.Name, ": newer image available for", c.Image)
+			fmt.Println("   ", locked.Digest, "->", remoteDigest)
+		} else {
+			fmt.Println(c.Name, ": not locked yet", "("+c.Image+" -> "+remoteDigest+")")
+		}
+
+		if checkOnly {
+			available = true
+			continue
+		}
+
+		image, err := pullAndInspect(cli, c.Image)
+		if err != nil {
+			fmt.Println(c.Name, ": failed to pull", c.Image, "-", err)
+			failed = true
+			continue
+		}
+
+		if digest := findRepoDigest(c.Image, image); digest != "" {
+			(*lf)[c.Name] = &lock.LockedImage{
+				Image:  c.Image,
+				Digest: digest,
+				ID:     image.ID,
+			}
+			changed = true
+		} else {
+			fmt.Println(c.Name, ": no digest found for", c.Image, "after pulling it")
+			failed = true
+		}
+	}
+
+	if changed {
+		lock.Write(lockPath, lf)
+
+		fmt.Println("Updated", lockPath)
+	}
+
+	if failed {
+		return 1
+	} else if available {
+		return UpdatesAvailable
+	}
+
+	return 0
+}
+
+// pullAndInspect pulls the image, returning the errors instead of panicking, so that the other apps are still updated
+func pullAndInspect(cli *client.Client, imageName string) (image types.ImageInspect, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+
+	pullImage(cli, imageName)
+
+	image, _, err = cli.ImageInspectWithRaw(context.Background(), imageName)
+	return image, err
+}
+
+func isSelected(name string, apps []string) bool {
+	if len(apps) == 0 {
+		return true
+	}
+
+	for _, app := range apps {
+		if app == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pullImage(cli *client.Client, image string) {
+	if debug.IsEnabled() {
+		fmt.Println("Pulling image for", image, "...")
+	}
+
+	reader, err := cli.ImagePull(context.Background(), image, types.ImagePullOptions{})
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	processPullMessages(reader)
+}
@@ -0,0 +1,235 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-units"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/parse"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type builtImage struct {
+	Summary types.ImageSummary
+	BuiltAt time.Time
+}
+
+type pruneCandidate struct {
+	Image  builtImage
+	Tag    string
+	Reason string
+}
+
+// Prune removes the images built by ddexec that are no longer referenced by
+// their configuration file, or that were superseded by newer builds, keeping
+// the latest `keep` builds for each image tag that is still in use.
+func Prune(configPaths []string, keep int, dryRun bool) int {
+	cli := newClient()
+	defer cli.Close()
+
+	images, err := cli.ImageList(context.Background(), types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelBuiltAt)),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		groups     = map[string][]builtImage{}
+		referenced = referencedImages(configPaths)
+		candidates []pruneCandidate
+	)
+
+	for _, summary := range images {
+		tag := summary.Labels[labelImageName]
+		if tag == "" && len(summary.RepoTags) > 0 && summary.RepoTags[0] != "<none>:<none>" {
+			tag = summary.RepoTags[0]
+		}
+
+		builtAt, err := time.Parse(time.RFC3339, summary.Labels[labelBuiltAt])
+		if err != nil {
+			builtAt = time.Unix(summary.Created, 0)
+		}
+
+		groups[tag] = append(groups[tag], builtImage{Summary: summary, BuiltAt: builtAt})
+	}
+
+	for tag, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].BuiltAt.After(group[j].BuiltAt)
+		})
+
+		if tag == "" {
+			for _, image := range group {
+				candidates = append(candidates, pruneCandidate{Image: image, Tag: "<none>", Reason: "untagged"})
+			}
+			continue
+		}
+
+		if !isImageReferenced(tag, group[0], referenced) {
+			for _, image := range group {
+				candidates = append(candidates, pruneCandidate{Image: image, Tag: tag, Reason: "unreferenced"})
+			}
+			continue
+		}
+
+		kept := 0
+
+		for _, image := range group {
+			if kept < keep || hasTag(image.Summary.RepoTags, tag) {
+				kept++
+				continue
+			}
+
+			candidates = append(candidates, pruneCandidate{Image: image, Tag: tag, Reason: "superseded"})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Tag != candidates[j].Tag {
+			return candidates[i].Tag < candidates[j].Tag
+		}
+		return candidates[i].Image.BuiltAt.After(candidates[j].Image.BuiltAt)
+	})
+
+	var (
+		reclaimed int64
+		exitCode  int
+	)
+
+	for _, candidate := range candidates {
+		summary := candidate.Image.Summary
+
+		description := fmt.Sprintf("%s  %-40s  %-12s  built %s  %s",
+			shortImageID(summary.ID), candidate.Tag, candidate.Reason,
+			candidate.Image.BuiltAt.Format("2006-01-02 15:04"), units.HumanSize(float64(summary.Size)))
+
+		if dryRun {
+			fmt.Println("Would remove", description)
+			reclaimed += summary.Size
+			continue
+		}
+
+		if _, err := cli.ImageRemove(context.Background(), summary.ID, types.ImageRemoveOptions{
+			Force:         len(summary.RepoTags) > 1, // allow removing the same image tagged multiple times
+			PruneChildren: true,
+		}); err != nil {
+			fmt.Println("Failed to remove", description, "-", err)
+			exitCode = 1
+		} else {
+			fmt.Println("Removed", description)
+			reclaimed += summary.Size
+		}
+	}
+
+	if len(candidates) == 0 {
+		fmt.Println("Nothing to prune")
+	} else if dryRun {
+		fmt.Println("Would reclaim up to", units.HumanSize(float64(reclaimed)))
+	} else {
+		fmt.Println("Reclaimed up to", units.HumanSize(float64(reclaimed)))
+	}
+
+	return exitCode
+}
+
+// referencedImages collects the image tags built by the given configuration files
+// and the ones in the app search path
+func referencedImages(configPaths []string) map[string]bool {
+	referenced := map[string]bool{}
+
+	for _, dir := range parse.SearchPath() {
+		for _, pattern := range []string{"*.yml", "*.yaml"} {
+			if matches, err := filepath.Glob(filepath.Join(dir, pattern)); err == nil {
+				for _, match := range matches {
+					if !strings.HasSuffix(match, ".lock.yml") {
+						configPaths = append(configPaths, match)
+					}
+				}
+			}
+		}
+	}
+
+	for _, configPath := range configPaths {
+		tags, _ := imagesBuiltBy(configPath)
+		for tag := range tags {
+			referenced[tag] = true
+		}
+	}
+
+	return referenced
+}
+
+func isImageReferenced(tag string, latest builtImage, referenced map[string]bool) bool {
+	if referenced[tag] {
+		return true
+	}
+
+	configPath := latest.Summary.Labels[labelConfigPath]
+	if configPath == "" {
+		// built by an older version, assume it's in use while it's tagged
+		return hasTag(latest.Summary.RepoTags, tag)
+	}
+
+	if tags, ok := imagesBuiltBy(configPath); ok {
+		return tags[tag]
+	} else {
+		return true // we can't tell, so let's keep it
+	}
+}
+
+// imagesBuiltBy returns the image tags built from Dockerfiles in the
+// configuration file, and false if the file exists but can't be parsed
+func imagesBuiltBy(configPath string) (tags map[string]bool, ok bool) {
+	tags = map[string]bool{}
+
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+
+	if _, err := os.Stat(configPath); err != nil && os.IsNotExist(err) {
+		return tags, true
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			if debug.IsEnabled() {
+				fmt.Println("Failed to read", configPath, ":", err)
+			}
+
+			ok = false
+		}
+	}()
+
+	for _, c := range *parse.ParseConfiguration(configPath) {
+		if c.Dockerfile != "" {
+			tags[c.Image] = true
+		}
+	}
+
+	return tags, true
+}
+
+func hasTag(repoTags []string, tag string) bool {
+	for _, t := range repoTags {
+		if t == tag || t == tag+":latest" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
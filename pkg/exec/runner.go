@@ -2,10 +2,12 @@ package exec
 
 import (
 	"context"
+	"github.com/rycus86/ddexec/pkg/audit"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/debug"
 	"github.com/rycus86/ddexec/pkg/env"
 	"github.com/rycus86/ddexec/pkg/xdgopen"
+	"strconv"
 )
 
 func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int, func()) {
@@ -35,6 +37,50 @@ func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int,
 
 	debug.LogTime("checkStreams")
 
+	resolveSoundBackend(sc)
+
+	debug.LogTime("resolveSoundBackend")
+
+	stopNestedDisplay := startNestedDisplay(cli, c, sc)
+	defer func() {
+		if err := recover(); err != nil {
+			stopNestedDisplay()
+			panic(err)
+		}
+	}()
+
+	debug.LogTime("startNestedDisplay")
+
+	stopDBusProxy := startDBusProxy(c, sc)
+	defer func() {
+		if err := recover(); err != nil {
+			stopDBusProxy()
+			panic(err)
+		}
+	}()
+
+	debug.LogTime("startDBusProxy")
+
+	removeSecrets := prepareSecretsDir(c, sc)
+	defer func() {
+		if err := recover(); err != nil {
+			removeSecrets()
+			panic(err)
+		}
+	}()
+
+	debug.LogTime("prepareSecretsDir")
+
+	revokeControlToken := issueControlToken(c, sc)
+	defer func() {
+		if err := recover(); err != nil {
+			revokeControlToken()
+			panic(err)
+		}
+	}()
+
+	debug.LogTime("issueControlToken")
+
 	environment := prepareEnvironment(c, sc)
 
 	debug.LogTime("prepareEnvironment")
@@ -51,15 +97,27 @@ func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int,
 
 	debug.LogTime("createContainer")
 
-	copyFiles(cli, containerID, sc)
+	bindControlToken(containerID, sc)
+
+	debug.LogTime("bindControlToken")
+
+	loadDesktopMappings(cli, containerID, sc)
+
+	debug.LogTime("loadDesktopMappings")
+
+	containerXauth := copyFiles(cli, containerID, sc)
 
 	debug.LogTime("copyFiles")
 
+	copySecrets(cli, containerID, c, sc)
+
+	debug.LogTime("copySecrets")
+
 	closeStreams := setupStreams(cli, containerID, c, sc)
 
 	debug.LogTime("setupStreams")
 
-	startContainer(cli, containerID)
+	startContainer(cli, containerID, c.Name)
 
 	debug.LogTime("startContainer")
 
@@ -67,6 +125,10 @@ func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int,
 
 	debug.LogTime("setupSignals")
 
+	stopXauthMonitor := monitorXauth(cli, containerID, containerXauth)
+
+	debug.LogTime("monitorXauth")
+
 	xdgopen.Register(containerID, sc)
 
 	debug.LogTime("xdgopen.Register")
@@ -87,6 +149,19 @@ func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int,
 
 		debug.LogTime("waitForExit")
 
+		auditContainer(c.Name, containerID, audit.ContainerExit, nil, "exit code "+strconv.Itoa(exitCode))
+
+		stopXauthMonitor()
+
+		if containerXauth != nil {
+			containerXauth.revoke()
+		}
+
+		stopNestedDisplay()
+		stopDBusProxy()
+		removeSecrets()
+		revokeControlToken()
+
 		xdgopen.Clear(containerID)
 
 		debug.LogTime("xdgopen.Clear")
@@ -97,10 +172,17 @@ func Run(c *config.AppConfiguration, sc *config.StartupConfiguration) (chan int,
 	return waitChan, func() {
 		debug.LogTime("closerStart")
 
-		cli.ContainerStop(context.Background(), containerID, nil)
+		err := cli.ContainerStop(context.Background(), containerID, nil)
+
+		auditContainer(c.Name, containerID, audit.ContainerStop, nil, auditResult(err))
 
 		debug.LogTime("containerStop")
 
+		stopNestedDisplay()
+		stopDBusProxy()
+		removeSecrets()
+		revokeControlToken()
+
 		// TODO maybe this is unnecessary
 		if selfId := getSelfContainerId(); selfId != "" {
 			restoreTtySize(cli, selfId)
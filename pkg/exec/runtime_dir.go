@@ -0,0 +1,118 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/dbus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getHostRuntimeDir returns the XDG runtime directory of the host user, if there is one
+func getHostRuntimeDir() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return runtimeDir
+	}
+
+	if fi, err := os.Stat("/run/user/" + strconv.Itoa(os.Getuid())); err == nil && fi.IsDir() {
+		return "/run/user/" + strconv.Itoa(os.Getuid())
+	}
+
+	return ""
+}
+
+// getContainerRuntimeDir returns the XDG runtime directory to use in the container
+func getContainerRuntimeDir() string {
+	return "/run/user/" + strconv.Itoa(os.Getuid())
+}
+
+func usesRuntimeDir(sc *config.StartupConfiguration) bool {
+	return isWaylandShared(sc) || sc.SoundBackend == SoundBackendPulse || sc.SoundBackend == SoundBackendPipeWire ||
+		usesHostSessionBus(sc) || sc.GpgAgent || len(sc.RuntimeSockets) > 0
+}
+
+// usesDBusVolume returns true when the runtime directory comes from the shared XdbusUser volume
+func usesDBusVolume(sc *config.StartupConfiguration) bool {
+	return sc.IsSet(sc.ShareDBus) && !sc.UseHostDBus && !usesDBusProxy(sc)
+}
+
+// usesHostSessionBus returns true when the app talks to the session bus of the host directly
+func usesHostSessionBus(sc *config.StartupConfiguration) bool {
+	return sc.IsSet(sc.ShareDBus) && sc.UseHostDBus && !usesDBusProxy(sc)
+}
+
+// usesPrivateRuntimeDir returns true when the app gets its own (initially empty) runtime directory,
+// with only the sockets it is configured to use bind-mounted into it
+func usesPrivateRuntimeDir(sc *config.StartupConfiguration) bool {
+	return usesRuntimeDir(sc) && !usesDBusVolume(sc)
+}
+
+func prepareRuntimeDirTmpfs(sc *config.StartupConfiguration) map[string]string {
+	if !usesPrivateRuntimeDir(sc) {
+		return nil
+	}
+
+	options := "mode=0700"
+
+	if sc.KeepUser {
+		// we don't know the numeric IDs of the image user
+		options = "mode=1777"
+	} else if uid, gid, _ := getPrivateFileOwnership(sc); uid != 0 || gid != 0 {
+		options += ",uid=" + strconv.Itoa(uid) + ",gid=" + strconv.Itoa(gid)
+	}
+
+	return map[string]string{getContainerRuntimeDir(): options}
+}
+
+// prepareRuntimeDirMounts returns the sockets from the host runtime directory the app is configured to use
+func prepareRuntimeDirMounts(sc *config.StartupConfiguration) []mount.Mount {
+	var mounts []mount.Mount
+
+	if usesHostSessionBus(sc) {
+		if socket, err := dbus.SocketPath(dbus.SessionBusAddress()); err != nil || strings.HasPrefix(socket, "@") {
+			fmt.Println("WARNING: The session bus of the host can not be shared:", dbus.SessionBusAddress())
+		} else {
+			mounts = append(mounts, runtimeSocketMount(socket, "bus"))
+		}
+	}
+
+	if len(sc.RuntimeSockets) > 0 && !usesPrivateRuntimeDir(sc) {
+		// the runtime directory is shared with the other apps through the XdbusUser volume
+		fmt.Println("WARNING: The runtime sockets are only shared with a private runtime directory:", sc.RuntimeSockets)
+		return mounts
+	}
+
+	hostRuntimeDir := getHostRuntimeDir()
+
+	for _, name := range sc.RuntimeSockets {
+		if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+			panic(errors.New("runtime sockets have to be relative to the runtime directory: " + name))
+		} else if hostRuntimeDir == "" {
+			panic(errors.New("no runtime directory found on the host for " + name))
+		}
+
+		mounts = append(mounts, runtimeSocketMount(filepath.Join(hostRuntimeDir, name), name))
+	}
+
+	return mounts
+}
+
+func runtimeSocketMount(source, name string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeBind,
+		Source: source,
+		Target: filepath.Join(getContainerRuntimeDir(), name),
+	}
+}
+
+func prepareRuntimeDirEnvironment(sc *config.StartupConfiguration) []string {
+	if usesDBusVolume(sc) || !usesRuntimeDir(sc) {
+		return nil // this is set with the DBus environment otherwise
+	}
+
+	return []string{"XDG_RUNTIME_DIR=" + getContainerRuntimeDir()}
+}
@@ -0,0 +1,201 @@
+package exec
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const secretsDir = "/run/secrets"
+
+type secretReference struct {
+	Source string
+	Target string
+	Uid    *int
+	Gid    *int
+	Mode   *int64
+}
+
+func parseSecretReferences(c *config.AppConfiguration) []secretReference {
+	var references []secretReference
+
+	for _, item := range c.Secrets {
+		ref := secretReference{}
+
+		switch value := item.(type) {
+		case string:
+			ref.Source = value
+
+		case map[interface{}]interface{}:
+			for key, v := range value {
+				switch key {
+				case "source":
+					ref.Source = fmt.Sprint(v)
+				case "target":
+					ref.Target = fmt.Sprint(v)
+				case "uid":
+					ref.Uid = parseSecretNumber(v)
+				case "gid":
+					ref.Gid = parseSecretNumber(v)
+				case "mode":
+					if mode := parseSecretNumber(v); mode != nil {
+						m := int64(*mode)
+						ref.Mode = &m
+					}
+				default:
+					panic(errors.Errorf("unknown secret option: %v", key))
+				}
+			}
+
+		default:
+			panic(errors.Errorf("invalid secret: %v", item))
+		}
+
+		if _, ok := c.SecretDefinitions[ref.Source]; !ok {
+			panic(errors.New("undefined secret: " + ref.Source))
+		}
+
+		if ref.Target == "" {
+			ref.Target = ref.Source
+		}
+
+		if ref.Target = path.Clean(ref.Target); strings.HasPrefix(ref.Target, "..") || strings.Contains(ref.Target, "/") {
+			panic(errors.New("secrets have to be in " + secretsDir + ": " + ref.Target))
+		}
+
+		references = append(references, ref)
+	}
+
+	return references
+}
+
+func parseSecretNumber(v interface{}) *int {
+	switch value := v.(type) {
+	case int:
+		return &value
+	case string:
+		// compose files have these as strings, like "0440"
+		if parsed, err := strconv.ParseInt(value, 0, 32); err == nil {
+			n := int(parsed)
+			return &n
+		}
+	}
+
+	panic(errors.Errorf("invalid number for a secret option: %v", v))
+}
+
+// prepareSecretsDir creates a private directory on a host tmpfs to mount at /run/secrets,
+// so the secrets are never written to disk, and returns a function to remove it
+func prepareSecretsDir(c *config.AppConfiguration, sc *config.StartupConfiguration) func() {
+	if len(parseSecretReferences(c)) == 0 {
+		return func() {}
+	}
+
+	if sc.KeepUser {
+		// the directory is only accessible to the current user
+		panic(errors.New("secrets are not supported with keep_user, the user of the image could not read them"))
+	}
+
+	parent := getHostRuntimeDir()
+	if parent == "" {
+		parent = "/dev/shm"
+	}
+
+	dir, err := ioutil.TempDir(parent, "ddexec-secrets")
+	if err != nil {
+		panic(err)
+	}
+
+	sc.SecretsDir = dir
+
+	return func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func prepareSecretMounts(sc *config.StartupConfiguration) []mount.Mount {
+	if sc.SecretsDir == "" {
+		return nil
+	}
+
+	return []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: sc.SecretsDir,
+			Target: secretsDir,
+		},
+	}
+}
+
+// copySecrets delivers the secrets into the created container before it starts,
+// owned by the target user and readable only by them unless configured otherwise
+func copySecrets(cli *client.Client, containerID string, c *config.AppConfiguration, sc *config.StartupConfiguration) {
+	references := parseSecretReferences(c)
+	if len(references) == 0 {
+		return
+	}
+
+	uid, gid, _ := getPrivateFileOwnership(sc)
+
+	var toCopy []fileToCopy
+
+	for _, ref := range references {
+		contents := readSecret(ref.Source, c.SecretDefinitions[ref.Source])
+
+		hdr := &tar.Header{
+			Name:    path.Join(secretsDir, ref.Target),
+			Mode:    0400,
+			Uid:     uid,
+			Gid:     gid,
+			Size:    int64(len(contents)),
+			ModTime: time.Now(),
+		}
+
+		if ref.Mode != nil {
+			hdr.Mode = *ref.Mode
+		}
+		if ref.Uid != nil {
+			hdr.Uid = *ref.Uid
+		}
+		if ref.Gid != nil {
+			hdr.Gid = *ref.Gid
+		}
+
+		toCopy = append(toCopy, fileToCopy{Target: hdr.Name, Contents: contents, Header: hdr})
+	}
+
+	if err := copyToContainer(cli, containerID, "/", toCopy...); err != nil {
+		panic(err)
+	}
+}
+
+func readSecret(name string, secret config.SecretDefinition) []byte {
+	if secret.Environment != "" {
+		if value, ok := os.LookupEnv(secret.Environment); ok {
+			return []byte(value)
+		}
+
+		panic(errors.New("the environment variable for secret " + name + " is not set: " + secret.Environment))
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Reading secret", name, "from", secret.File)
+	}
+
+	contents, err := ioutil.ReadFile(secret.File)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to read secret "+name))
+	}
+
+	return contents
+}
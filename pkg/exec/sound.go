@@ -0,0 +1,251 @@
+package exec
+
+import (
+	"archive/tar"
+	"crypto/rand"
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SoundBackendAuto     = "auto"
+	SoundBackendPulse    = "pulse"
+	SoundBackendPipeWire = "pipewire"
+	SoundBackendAlsa     = "alsa"
+
+	pulseConfigDir = "/tmp/.ddexec-pulse"
+)
+
+func resolveSoundBackend(sc *config.StartupConfiguration) {
+	if !sc.IsSet(sc.ShareSound) {
+		sc.SoundBackend = ""
+		return
+	}
+
+	switch sc.SoundBackend {
+	case "", SoundBackendAuto:
+		if getPulseSocket() != "" {
+			sc.SoundBackend = SoundBackendPulse
+		} else if getPipeWireSocket() != "" {
+			sc.SoundBackend = SoundBackendPipeWire
+		} else {
+			sc.SoundBackend = SoundBackendAlsa
+		}
+
+	case SoundBackendPulse:
+		if getPulseSocket() == "" {
+			fmt.Println("WARNING: No PulseAudio socket found, falling back to ALSA")
+			sc.SoundBackend = SoundBackendAlsa
+		}
+
+	case SoundBackendPipeWire:
+		if getPipeWireSocket() == "" {
+			fmt.Println("WARNING: No PipeWire socket found, falling back to ALSA")
+			sc.SoundBackend = SoundBackendAlsa
+		}
+
+	case SoundBackendAlsa:
+		// OK
+
+	default:
+		panic(errors.New("unknown sound backend: " + sc.SoundBackend))
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Sound backend:", sc.SoundBackend)
+	}
+}
+
+func isAlsaShared(sc *config.StartupConfiguration) bool {
+	return sc.IsSet(sc.ShareSound) && sc.SoundBackend == SoundBackendAlsa
+}
+
+func getPulseSocket() string {
+	if server := os.Getenv("PULSE_SERVER"); strings.HasPrefix(server, "unix:") {
+		return socketIfExists(strings.TrimPrefix(server, "unix:"))
+	}
+
+	if runtimeDir := getHostRuntimeDir(); runtimeDir != "" {
+		return socketIfExists(filepath.Join(runtimeDir, "pulse", "native"))
+	}
+
+	return ""
+}
+
+func getPipeWireSocket() string {
+	remote := os.Getenv("PIPEWIRE_REMOTE")
+	if remote == "" {
+		remote = "pipewire-0"
+	}
+
+	if filepath.IsAbs(remote) {
+		return socketIfExists(remote)
+	}
+
+	runtimeDir := os.Getenv("PIPEWIRE_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = getHostRuntimeDir()
+	}
+
+	if runtimeDir != "" {
+		return socketIfExists(filepath.Join(runtimeDir, remote))
+	}
+
+	return ""
+}
+
+func socketIfExists(path string) string {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return path
+	}
+
+	return ""
+}
+
+func getContainerPulseSocket() string {
+	return filepath.Join(getContainerRuntimeDir(), "pulse", "native")
+}
+
+func prepareSoundMounts(sc *config.StartupConfiguration) []mount.Mount {
+	switch sc.SoundBackend {
+	case SoundBackendPulse:
+		return []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: getPulseSocket(),
+				Target: getContainerPulseSocket(),
+			},
+		}
+
+	case SoundBackendPipeWire:
+		return []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: getPipeWireSocket(),
+				Target: filepath.Join(getContainerRuntimeDir(), "pipewire-0"),
+			},
+		}
+
+	default:
+		return nil
+	}
+}
+
+func prepareSoundEnvironment(sc *config.StartupConfiguration) []string {
+	switch sc.SoundBackend {
+	case SoundBackendPulse:
+		return []string{
+			"PULSE_SERVER=unix:" + getContainerPulseSocket(),
+			"PULSE_COOKIE=" + pulseConfigDir + "/cookie",
+			"PULSE_CLIENTCONFIG=" + pulseConfigDir + "/client.conf",
+		}
+
+	case SoundBackendPipeWire:
+		return []string{
+			"PIPEWIRE_RUNTIME_DIR=" + getContainerRuntimeDir(),
+			"PIPEWIRE_REMOTE=pipewire-0",
+		}
+
+	default:
+		return nil
+	}
+}
+
+func prepareSoundFiles(sc *config.StartupConfiguration) []fileToCopy {
+	if sc.SoundBackend != SoundBackendPulse {
+		return nil
+	}
+
+	uid, gid, mode := getPrivateFileOwnership(sc)
+
+	clientConfig := []byte(strings.TrimSpace(fmt.Sprintf(`
+default-server = unix:%s
+autospawn = no
+daemon-binary = /bin/true
+enable-shm = %s
+`, getContainerPulseSocket(), yesOrNo(sc.IsSet(sc.ShareShm)))) + "\n")
+
+	toCopy := []fileToCopy{
+		{
+			Target:   pulseConfigDir + "/client.conf",
+			Contents: clientConfig,
+			Header: &tar.Header{
+				Name: pulseConfigDir + "/client.conf",
+				Mode: 0644,
+				Size: int64(len(clientConfig)),
+				Uid:  uid,
+				Gid:  gid,
+			},
+		},
+	}
+
+	if mode != 0600 {
+		// only the user of the app should be able to read the cookie
+		fmt.Println("WARNING: The PulseAudio cookie is not shared, the IDs of the image user are unknown")
+		return toCopy
+	}
+
+	cookie := readPulseCookie()
+
+	return append(toCopy, fileToCopy{
+		Target:   pulseConfigDir + "/cookie",
+		Contents: cookie,
+		Header: &tar.Header{
+			Name: pulseConfigDir + "/cookie",
+			Mode: mode,
+			Size: int64(len(cookie)),
+			Uid:  uid,
+			Gid:  gid,
+		},
+	})
+}
+
+// readPulseCookie reads the PulseAudio cookie of the host user,
+// or generates a new one if there isn't one (the server might not need it)
+func readPulseCookie() []byte {
+	candidates := []string{os.Getenv("PULSE_COOKIE")}
+
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		candidates = append(candidates, filepath.Join(configHome, "pulse", "cookie"))
+	}
+
+	candidates = append(candidates,
+		os.ExpandEnv("${HOME}/.config/pulse/cookie"),
+		os.ExpandEnv("${HOME}/.pulse-cookie"))
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+
+		if cookie, err := ioutil.ReadFile(candidate); err == nil && len(cookie) > 0 {
+			return cookie
+		}
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("No PulseAudio cookie found, generating one")
+	}
+
+	cookie := make([]byte, 256)
+	if _, err := rand.Read(cookie); err != nil {
+		panic(err)
+	}
+
+	return cookie
+}
+
+func yesOrNo(value bool) string {
+	if value {
+		return "yes"
+	} else {
+		return "no"
+	}
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/rycus86/ddexec/pkg/audit"
 )
 
-func startContainer(cli *client.Client, containerID string) {
-	if err := cli.ContainerStart(
+func startContainer(cli *client.Client, containerID string, name string) {
+	err := cli.ContainerStart(
 		context.Background(),
 		containerID,
 		types.ContainerStartOptions{},
-	); err != nil {
+	)
+
+	auditContainer(name, containerID, audit.ContainerStart, nil, auditResult(err))
+
+	if err != nil {
 		panic(err)
 	}
 }
@@ -1,12 +1,17 @@
 package exec
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/files"
 	"github.com/tredoe/osutil/user/crypt"
 	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -14,15 +19,26 @@ import (
 	"strings"
 )
 
+// the largest /etc/passwd or /etc/group file read from the container
+const maxContainerFileSize = 1 << 20
+
 func prepareUserAndGroupFiles(sc *config.StartupConfiguration) *files.PasswdFiles {
 	var passwd, group string
 	var temporary bool
 
-	if sc.DesktopMode {
+	if sc.DesktopMode || isRootlessDocker(sc) {
 		group = files.CopyToTempfile("/etc/group")
 		passwd = files.CopyToTempfile("/etc/passwd")
-		files.ModifyFile(passwd, "(?m)^("+getUsername()+":.+:)[^:]*$", "$1/bin/sh")
 		temporary = true
+
+		if sc.DesktopMode {
+			files.ModifyFile(passwd, "(?m)^("+getUsername()+":.+:)[^:]*$", "$1/bin/sh")
+		}
+
+		if isRootlessDocker(sc) {
+			// the host user is mapped to root in the container
+			files.ModifyFile(passwd, "(?m)^("+getUsername()+":[^:]*:)[0-9]+:[0-9]+:", "${1}0:0:")
+		}
 	} else {
 		passwd = "/etc/passwd"
 		group = "/etc/group"
@@ -48,10 +64,42 @@ root:!::0:99999:7:::
 	}
 }
 
-func getUserAndGroup() string {
+func getUserAndGroup(sc *config.StartupConfiguration) string {
+	if isRootlessDocker(sc) {
+		// root in the user namespace is the host user on the outside
+		return "0:0"
+	}
+
 	return strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
 }
 
+// getPrivateFileOwnership returns the owner and the file mode to use
+// for files copied into the container that only the target user should read
+func getPrivateFileOwnership(sc *config.StartupConfiguration) (uid int, gid int, mode int64) {
+	if sc.KeepUser && sc.ImageUserResolved {
+		return sc.ImageUID, sc.ImageGID, 0600
+	} else if sc.KeepUser {
+		// we don't know the numeric IDs of the image user
+		return 0, 0, 0644
+	} else if isRootlessDocker(sc) {
+		return 0, 0, 0600
+	} else {
+		return os.Getuid(), os.Getgid(), 0600
+	}
+}
+
+// isRootlessDocker returns true for rootless Docker, where the container user namespace
+// maps root to the host user (and other IDs to subordinate ones)
+func isRootlessDocker(sc *config.StartupConfiguration) bool {
+	return sc.DaemonIsRootless && !sc.DaemonIsPodman
+}
+
+// isRootlessPodman returns true for rootless Podman, where we can keep the host user ID
+// in the container with the keep-id user namespace mode
+func isRootlessPodman(sc *config.StartupConfiguration) bool {
+	return sc.DaemonIsRootless && sc.DaemonIsPodman
+}
+
 func getUsername() string {
 	u, err := user.Current()
 	if err != nil {
@@ -83,3 +131,90 @@ func generateHashedPassword(passwordFile string) string {
 		return generated
 	}
 }
+
+// resolveImageUser finds the numeric IDs of the user of the image for keep_user, so that the private files
+// copied into the container can be owned by it, looking up the names in the files of the container
+func resolveImageUser(cli *client.Client, containerID string, sc *config.StartupConfiguration) error {
+	name, group := sc.ImageUser, ""
+	if idx := strings.Index(name, ":"); idx >= 0 {
+		name, group = name[:idx], name[idx+1:]
+	}
+
+	var uid, gid int
+
+	if name != "" {
+		passwd, err := readContainerFile(cli, containerID, "/etc/passwd")
+		if err != nil && !isNumeric(name) {
+			return err
+		}
+
+		if entry := findEntry(passwd, name, 2); entry != nil {
+			uid, _ = strconv.Atoi(entry[2])
+			gid, _ = strconv.Atoi(entry[3])
+		} else if isNumeric(name) {
+			uid, _ = strconv.Atoi(name)
+		} else {
+			return errors.New("unknown user in the image: " + name)
+		}
+	}
+
+	if group != "" {
+		groups, err := readContainerFile(cli, containerID, "/etc/group")
+		if err != nil && !isNumeric(group) {
+			return err
+		}
+
+		if entry := findEntry(groups, group, 2); entry != nil {
+			gid, _ = strconv.Atoi(entry[2])
+		} else if isNumeric(group) {
+			gid, _ = strconv.Atoi(group)
+		} else {
+			return errors.New("unknown group in the image: " + group)
+		}
+	}
+
+	sc.ImageUID, sc.ImageGID, sc.ImageUserResolved = uid, gid, true
+
+	return nil
+}
+
+// findEntry returns the fields of the passwd or group entry with the name, or with the ID in the field at idIndex
+func findEntry(contents []byte, nameOrID string, idIndex int) []string {
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+
+		if fields[0] == nameOrID || (isNumeric(nameOrID) && fields[idIndex] == nameOrID) {
+			return fields
+		}
+	}
+
+	return nil
+}
+
+func isNumeric(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+// readContainerFile reads a (small) regular file from the container
+func readContainerFile(cli *client.Client, containerID, path string) ([]byte, error) {
+	reader, _, err := cli.CopyFromContainer(context.Background(), containerID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	} else if header.Typeflag != tar.TypeReg {
+		return nil, errors.New("not a regular file: " + path)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(tr, maxContainerFileSize))
+}
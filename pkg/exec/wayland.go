@@ -0,0 +1,75 @@
+package exec
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"os"
+	"path/filepath"
+)
+
+// getWaylandSocket returns the path to the Wayland socket of the host, or empty if there's none
+func getWaylandSocket() string {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" {
+		return ""
+	}
+
+	if !filepath.IsAbs(display) {
+		runtimeDir := getHostRuntimeDir()
+		if runtimeDir == "" {
+			return ""
+		}
+
+		display = filepath.Join(runtimeDir, display)
+	}
+
+	if fi, err := os.Stat(display); err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return ""
+	}
+
+	return display
+}
+
+func isWaylandShared(sc *config.StartupConfiguration) bool {
+	return sc.ShareWayland && !sc.DesktopMode && !isNestedDisplay(sc) && getWaylandSocket() != ""
+}
+
+func prepareWaylandMounts(sc *config.StartupConfiguration) []mount.Mount {
+	if !isWaylandShared(sc) {
+		return nil
+	}
+
+	socket := getWaylandSocket()
+
+	return []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: socket,
+			Target: filepath.Join(getContainerRuntimeDir(), filepath.Base(socket)),
+		},
+	}
+}
+
+func prepareWaylandEnvironment(sc *config.StartupConfiguration) []string {
+	if !sc.ShareWayland || sc.DesktopMode || isNestedDisplay(sc) {
+		return nil
+	}
+
+	if !isWaylandShared(sc) {
+		if debug.IsEnabled() {
+			fmt.Println("No Wayland display found, falling back to X11")
+		}
+
+		return []string{"XDG_SESSION_TYPE=x11"}
+	}
+
+	return []string{
+		"WAYLAND_DISPLAY=" + filepath.Base(getWaylandSocket()),
+		"XDG_SESSION_TYPE=wayland",
+		"GDK_BACKEND=wayland,x11",
+		"QT_QPA_PLATFORM=wayland;xcb",
+		"MOZ_ENABLE_WAYLAND=1",
+	}
+}
@@ -1,22 +1,231 @@
 package exec
 
 import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"github.com/rycus86/ddexec/pkg/x11"
+	"github.com/rycus86/ddexec/pkg/xauth"
 	"os"
-	"os/exec"
+	"sync"
+	"time"
 )
 
-func prepareXauth() error {
-	target := getXauth()
+const (
+	X11TrustTrusted   = "trusted"
+	X11TrustUntrusted = "untrusted"
 
-	if fi, err := os.Stat(target); err == nil && !fi.IsDir() && fi.Size() > 0 {
-		return nil // already exists
+	xauthMonitorInterval = 2 * time.Second
+)
+
+// containerXauth generates the Xauthority file for a container,
+// either with the cookie of the host, or with an untrusted one
+type containerXauth struct {
+	sc   *config.StartupConfiguration
+	lock sync.Mutex
+
+	hostCookie      []byte
+	authorizationID uint32
+}
+
+func newContainerXauth(sc *config.StartupConfiguration) *containerXauth {
+	switch sc.X11Trust {
+	case "", X11TrustTrusted, X11TrustUntrusted:
+		return &containerXauth{sc: sc}
+	default:
+		panic(errors.New("unknown X11 trust level: " + sc.X11Trust))
+	}
+}
+
+func (x *containerXauth) isUntrusted() bool {
+	return x.sc.X11Trust == X11TrustUntrusted
+}
+
+// sharesX11 returns true when the app gets the X11 socket of the host display
+func sharesX11(sc *config.StartupConfiguration) bool {
+	return sc.UseHostX11 || sc.IsSet(sc.ShareX11)
+}
+
+// findHostCookie returns the cookie of the current display from the host Xauthority file
+func findHostCookie() (*xauth.Entry, error) {
+	return xauth.HostCookie(os.Getenv("DISPLAY"))
+}
+
+// generate returns the contents of the Xauthority file for the container,
+// changing the family of the cookie to wildcard so it matches any hostname
+func (x *containerXauth) generate() ([]byte, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	cookie, err := findHostCookie()
+	if err != nil {
+		return nil, err
+	}
+
+	x.hostCookie = cookie.Data
+
+	if !x.isUntrusted() {
+		return xauth.Marshal(xauth.Entry{
+			Family: xauth.FamilyWild,
+			Number: cookie.Number,
+			Name:   cookie.Name,
+			Data:   cookie.Data,
+		}), nil
+	}
+
+	conn, err := x11.Dial(os.Getenv("DISPLAY"), cookie)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if x.authorizationID != 0 {
+		conn.RevokeAuthorization(x.authorizationID)
+		x.authorizationID = 0
+	}
+
+	authID, data, err := conn.GenerateAuthorization(x11.TrustLevelUntrusted, uint32(x.sc.X11Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	if debug.IsEnabled() {
+		fmt.Println("Generated untrusted X11 authorization:", authID)
+	}
+
+	x.authorizationID = authID
+
+	return xauth.Marshal(xauth.Entry{
+		Family: xauth.FamilyWild,
+		Number: cookie.Number,
+		Name:   xauth.MitMagicCookie,
+		Data:   data,
+	}), nil
+}
+
+// hasChanged returns true if the cookie on the host is different from the one used last time
+func (x *containerXauth) hasChanged() bool {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	cookie, err := findHostCookie()
+	return err == nil && !bytes.Equal(cookie.Data, x.hostCookie)
+}
+
+// revoke revokes the untrusted authorization (if there is one)
+func (x *containerXauth) revoke() {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.authorizationID == 0 {
+		return
+	}
+
+	cookie, err := findHostCookie()
+	if err != nil {
+		return
+	}
+
+	conn, err := x11.Dial(os.Getenv("DISPLAY"), cookie)
+	if err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("Failed to connect to revoke the X11 authorization:", err)
+		}
+		return
 	}
+	defer conn.Close()
 
-	// TODO this won't work without xauth and a shell, but that's probably OK?
-	return exec.Command("sh", "-c",
-		"touch "+target+" && "+
-			"xauth nlist "+os.Getenv("DISPLAY")+" | sed -e 's/^..../ffff/' | xauth -f "+target+" nmerge -",
-	).Run()
+	if err := conn.RevokeAuthorization(x.authorizationID); err != nil && debug.IsEnabled() {
+		fmt.Println("Failed to revoke the X11 authorization:", err)
+	} else if debug.IsEnabled() {
+		fmt.Println("Revoked untrusted X11 authorization:", x.authorizationID)
+	}
+
+	x.authorizationID = 0
+}
+
+func (x *containerXauth) prepareFile() fileToCopy {
+	contents, err := x.generate()
+	if err != nil {
+		if x.isUntrusted() {
+			// we don't want to fall back to giving full access to the display
+			panic(errors.Wrap(err, "failed to generate an untrusted X11 authorization"))
+		}
+
+		fmt.Println("WARNING: Failed to prepare the X authority for the container:", err)
+		contents = []byte{}
+	}
+
+	return newXauthFile(x.sc, contents)
+}
+
+func newXauthFile(sc *config.StartupConfiguration, contents []byte) fileToCopy {
+	uid, gid, mode := getPrivateFileOwnership(sc)
+
+	return fileToCopy{
+		Target:   getXauth(),
+		Contents: contents,
+		Header: &tar.Header{
+			Name:    getXauth(),
+			Mode:    mode,
+			Size:    int64(len(contents)),
+			Uid:     uid,
+			Gid:     gid,
+			ModTime: time.Now(),
+		},
+	}
+}
+
+// monitorXauth regenerates the Xauthority file in the container when the cookie changes on the host
+func monitorXauth(cli *client.Client, containerID string, x *containerXauth) func() {
+	if x == nil || x.sc.DesktopMode || !sharesX11(x.sc) {
+		return func() {}
+	}
+
+	var stop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(xauthMonitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-ticker.C:
+				if !x.hasChanged() {
+					continue
+				}
+
+				if debug.IsEnabled() {
+					fmt.Println("The X authority cookie has changed, updating", containerID)
+				}
+
+				contents, err := x.generate()
+				if err != nil {
+					if debug.IsEnabled() {
+						fmt.Println("Failed to regenerate the X authority for", containerID, ":", err)
+					}
+					continue
+				}
+
+				if err := copyToContainer(cli, containerID, "/", newXauthFile(x.sc, contents)); err != nil {
+					if debug.IsEnabled() {
+						fmt.Println("Failed to update the X authority in", containerID, ":", err)
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+	}
 }
 
 func getXauth() string {
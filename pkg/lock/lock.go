@@ -0,0 +1,72 @@
+package lock
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func PathFor(configPath string) string {
+	ext := filepath.Ext(configPath)
+	if ext == ".yml" || ext == ".yaml" {
+		return strings.TrimSuffix(configPath, ext) + ".lock.yml"
+	} else {
+		return configPath + ".lock.yml"
+	}
+}
+
+func Read(path string) *LockFile {
+	lf := LockFile{}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &lf
+		} else {
+			panic(err)
+		}
+	}
+
+	if err := yaml.NewDecoder(bytes.NewReader(contents)).Decode(&lf); err != nil {
+		panic(err)
+	}
+
+	return &lf
+}
+
+func Write(path string, lf *LockFile) {
+	var contents = new(bytes.Buffer)
+
+	contents.WriteString("# generated by ddexec, do not edit\n")
+
+	encoder := yaml.NewEncoder(contents)
+	if err := encoder.Encode(lf); err != nil {
+		panic(err)
+	}
+	encoder.Close()
+
+	if err := ioutil.WriteFile(path, contents.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Reference returns the digest reference for the image, like `alpine@sha256:...`
+func (li *LockedImage) Reference() string {
+	return Repository(li.Image) + "@" + li.Digest
+}
+
+// Repository strips the tag and the digest from an image name
+func Repository(image string) string {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		image = image[:idx]
+	}
+
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		image = image[:idx]
+	}
+
+	return image
+}
@@ -0,0 +1,9 @@
+package lock
+
+type LockedImage struct {
+	Image  string `yaml:"image"`
+	Digest string `yaml:"digest"`
+	ID     string `yaml:"id,omitempty"`
+}
+
+type LockFile map[string]*LockedImage
@@ -0,0 +1,337 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/rycus86/ddexec/pkg/config"
+	"github.com/rycus86/ddexec/pkg/control"
+	"github.com/rycus86/ddexec/pkg/dbus"
+	"github.com/rycus86/ddexec/pkg/debug"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// BusSocket is where the minimal bus listens in the container
+	BusSocket = "/tmp/.ddexec-dbus/bus"
+	// ExecutableName is the name of the ddexec binary that runs the service in the container
+	ExecutableName = "ddexec-notifications"
+	// EnvAppName passes the name of the app to the service
+	EnvAppName = "DDEXEC_APP_NAME"
+
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = dbus.ObjectPath("/org/freedesktop/Notifications")
+	notificationsInterface = "org.freedesktop.Notifications"
+
+	// how long the app waits for the bus before starting without it
+	busStartTimeout = 5 * time.Second
+
+	// the unique name of the notification service on our bus
+	serviceName = ":1.0"
+
+	errorUnknownMethod  = "org.freedesktop.DBus.Error.UnknownMethod"
+	errorServiceUnknown = "org.freedesktop.DBus.Error.ServiceUnknown"
+	errorNameHasNoOwner = "org.freedesktop.DBus.Error.NameHasNoOwner"
+	errorFailed         = "org.freedesktop.DBus.Error.Failed"
+)
+
+// bus is a minimal message bus serving only itself and the notification service,
+// without routing messages between its clients
+type bus struct {
+	guid    string
+	appName string
+
+	lock       sync.Mutex
+	lastClient int
+}
+
+// Run runs the notification service, or when started as the entrypoint of the app (with the command after --),
+// starts the service in the background and replaces itself with the app once the bus is listening
+func Run(args []string) int {
+	if len(args) == 0 {
+		return Serve()
+	}
+
+	if args[0] == "--" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		fmt.Println("No command to run with the notification service")
+		return 1
+	}
+
+	self, err := os.Executable()
+	if err == nil {
+		service := exec.Command(self)
+		service.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		err = service.Start()
+	}
+
+	if err != nil {
+		fmt.Println("WARNING: Failed to start the notification service:", err)
+	} else if !waitForBus() {
+		fmt.Println("WARNING: The notification service did not start in time")
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fmt.Println("Failed to find the command:", err)
+		return 127
+	}
+
+	err = syscall.Exec(path, args, os.Environ())
+	fmt.Println("Failed to run the command:", err)
+	return 126
+}
+
+// waitForBus waits until the bus accepts connections
+func waitForBus() bool {
+	deadline := time.Now().Add(busStartTimeout)
+
+	for time.Now().Before(deadline) {
+		if conn, err := net.Dial("unix", BusSocket); err == nil {
+			conn.Close()
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+// Serve runs the notification service on a private bus socket until it fails
+func Serve() int {
+	if err := os.MkdirAll(filepath.Dir(BusSocket), 0700); err != nil {
+		fmt.Println("Failed to create the bus directory:", err)
+		return 1
+	}
+
+	os.Remove(BusSocket)
+
+	l, err := net.Listen("unix", BusSocket)
+	if err != nil {
+		fmt.Println("Failed to listen on the bus socket:", err)
+		return 1
+	}
+	defer l.Close()
+
+	b := &bus{guid: dbus.NewGUID(), appName: os.Getenv(EnvAppName)}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Println("Failed to accept a bus connection:", err)
+			return 1
+		}
+
+		go b.serve(conn)
+	}
+}
+
+func (b *bus) nextClientName() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastClient++
+	return ":1." + strconv.Itoa(b.lastClient)
+}
+
+func (b *bus) serve(conn net.Conn) {
+	defer conn.Close()
+
+	c, err := dbus.Accept(conn, b.guid, os.Getuid())
+	if err != nil {
+		return
+	}
+
+	name := b.nextClientName()
+
+	for {
+		m, err := c.Receive()
+		if err != nil {
+			return
+		}
+
+		m.Sender = name
+
+		if m.Type != dbus.TypeMethodCall {
+			continue // signals and replies are not routed anywhere
+		}
+
+		reply := b.handle(c, name, m)
+
+		if reply != nil && m.ExpectsReply() {
+			reply.Sender = dbus.BusName
+			if m.Destination != dbus.BusName {
+				reply.Sender = serviceName
+			}
+
+			if _, err := c.Send(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *bus) handle(c *dbus.Conn, name string, m *dbus.Message) *dbus.Message {
+	if debug.IsEnabled() {
+		fmt.Println("DBus", name+":", m)
+	}
+
+	switch m.Destination {
+	case dbus.BusName:
+		return b.handleBus(c, name, m)
+
+	case notificationsName, serviceName:
+		return b.handleNotifications(m)
+
+	default:
+		return dbus.NewError(m, errorServiceUnknown, "The name "+m.Destination+" is not available")
+	}
+}
+
+func (b *bus) handleBus(c *dbus.Conn, name string, m *dbus.Message) *dbus.Message {
+	switch m.Member {
+	case "Hello":
+		reply := dbus.NewReply(m, "s", name)
+		reply.Sender = dbus.BusName
+		c.Send(reply)
+
+		c.Send(&dbus.Message{
+			Type:        dbus.TypeSignal,
+			Flags:       dbus.FlagNoReplyExpected,
+			Sender:      dbus.BusName,
+			Path:        dbus.BusPath,
+			Interface:   dbus.BusInterface,
+			Member:      "NameAcquired",
+			Destination: name,
+			Signature:   "s",
+			Body:        []interface{}{name},
+		})
+
+		return nil
+
+	case "RequestName":
+		return dbus.NewReply(m, "u", uint32(1)) // primary owner
+
+	case "ReleaseName":
+		return dbus.NewReply(m, "u", uint32(1)) // released
+
+	case "GetNameOwner":
+		if owner := b.owner(name, m); owner != "" {
+			return dbus.NewReply(m, "s", owner)
+		}
+		return dbus.NewError(m, errorNameHasNoOwner, "The name does not have an owner")
+
+	case "NameHasOwner":
+		return dbus.NewReply(m, "b", b.owner(name, m) != "")
+
+	case "StartServiceByName":
+		if b.owner(name, m) != "" {
+			return dbus.NewReply(m, "u", uint32(2)) // already running
+		}
+		return dbus.NewError(m, errorServiceUnknown, "The service is not available")
+
+	case "ListNames", "ListActivatableNames":
+		return dbus.NewReply(m, "as", []string{dbus.BusName, notificationsName, serviceName, name})
+
+	case "AddMatch", "RemoveMatch", "Ping":
+		return dbus.NewReply(m, "")
+
+	case "GetId":
+		return dbus.NewReply(m, "s", b.guid)
+
+	case "GetConnectionUnixUser":
+		return dbus.NewReply(m, "u", uint32(os.Getuid()))
+
+	default:
+		return dbus.NewError(m, errorUnknownMethod, "Unsupported method: "+m.Member)
+	}
+}
+
+// owner returns the owner of the name in the first argument of the call, if it has one
+func (b *bus) owner(client string, m *dbus.Message) string {
+	if len(m.Body) == 0 {
+		return ""
+	}
+
+	switch m.Body[0] {
+	case dbus.BusName:
+		return dbus.BusName
+	case notificationsName, serviceName:
+		return serviceName
+	case client:
+		return client
+	default:
+		return ""
+	}
+}
+
+func (b *bus) handleNotifications(m *dbus.Message) *dbus.Message {
+	if m.Interface != "" && m.Interface != notificationsInterface {
+		return dbus.NewError(m, errorUnknownMethod, "Unsupported interface: "+m.Interface)
+	}
+
+	switch m.Member {
+	case "GetCapabilities":
+		return dbus.NewReply(m, "as", []string{"body"})
+
+	case "GetServerInformation":
+		return dbus.NewReply(m, "ssss", "ddexec", "rycus86", config.GetVersion(), "1.2")
+
+	case "CloseNotification":
+		return dbus.NewReply(m, "")
+
+	case "Notify":
+		if m.Signature != "susssasa{sv}i" {
+			return dbus.NewError(m, "org.freedesktop.DBus.Error.InvalidArgs", "Unexpected signature: "+string(m.Signature))
+		}
+
+		id, err := control.Notify(b.toRequest(m))
+		if err != nil {
+			return dbus.NewError(m, errorFailed, err.Error())
+		}
+
+		return dbus.NewReply(m, "u", id)
+
+	default:
+		return dbus.NewError(m, errorUnknownMethod, "Unsupported method: "+m.Member)
+	}
+}
+
+func (b *bus) toRequest(m *dbus.Message) control.NotifyRequest {
+	request := control.NotifyRequest{
+		AppName:    b.appName,
+		ReplacesID: m.Body[1].(uint32),
+		Icon:       m.Body[2].(string),
+		Summary:    m.Body[3].(string),
+		Body:       m.Body[4].(string),
+		Timeout:    m.Body[7].(int32),
+		Urgency:    1, // normal
+	}
+
+	if request.AppName == "" {
+		request.AppName = m.Body[0].(string)
+	}
+
+	if hints, ok := m.Body[6].(map[interface{}]interface{}); ok {
+		if urgency, ok := hints["urgency"].(dbus.Variant); ok {
+			if value, ok := urgency.Value.(byte); ok {
+				request.Urgency = value
+			}
+		}
+
+		if category, ok := hints["category"].(dbus.Variant); ok {
+			request.Category, _ = category.Value.(string)
+		}
+	}
+
+	return request
+}
@@ -3,6 +3,7 @@ package parse
 import (
 	"bytes"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/debug"
 	"gopkg.in/yaml.v2"
@@ -65,6 +66,8 @@ func ParseConfiguration(filepath string) *config.GlobalConfiguration {
 
 	var processedYaml = postProcess(rawYaml, mapper)
 
+	secrets := parseSecretDefinitions(rawYaml)
+
 	if debug.IsEnabled() {
 		fmt.Printf("Processed YAML:\n%+v\n", processedYaml)
 	}
@@ -87,9 +90,47 @@ func ParseConfiguration(filepath string) *config.GlobalConfiguration {
 		panic(err)
 	}
 
+	for _, app := range c {
+		if app != nil {
+			app.SecretDefinitions = secrets
+		}
+	}
+
 	return &c
 }
 
+// parseSecretDefinitions removes the top-level secrets from the configuration and parses them
+func parseSecretDefinitions(rawYaml map[interface{}]interface{}) map[string]config.SecretDefinition {
+	raw, ok := rawYaml["secrets"]
+	if !ok {
+		return nil
+	}
+
+	delete(rawYaml, "secrets")
+
+	contents, err := yaml.Marshal(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	var secrets map[string]config.SecretDefinition
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.SetStrict(true)
+
+	if err := decoder.Decode(&secrets); err != nil {
+		panic(err)
+	}
+
+	for name, secret := range secrets {
+		if (secret.File == "") == (secret.Environment == "") {
+			panic(errors.New("secret " + name + " needs either a file or an environment variable"))
+		}
+	}
+
+	return secrets
+}
+
 func newMapper(filepath string) func(key string) string {
 	return func(key string) string {
 		if isVariableKept(key) {
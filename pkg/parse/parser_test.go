@@ -22,3 +22,25 @@ ENTRYPOINT [ "/usr/bin/st" ]`
 		t.Fatal("unexpected content:\n" + c.Dockerfile)
 	}
 }
+
+func TestParseSecrets(t *testing.T) {
+	gc := ParseConfiguration("testdata/secrets.dapp.yaml")
+
+	if len(*gc) != 1 {
+		t.Fatal("unexpected apps:", *gc)
+	}
+
+	c := (*gc)["app"]
+
+	if len(c.Secrets) != 2 {
+		t.Fatal("unexpected secret references:", c.Secrets)
+	}
+
+	if token := c.SecretDefinitions["token"]; !strings.HasSuffix(token.File, "/testdata/token.txt") {
+		t.Error("unexpected token secret:", token)
+	}
+
+	if password := c.SecretDefinitions["password"]; password.Environment != "APP_PASSWORD" || password.File != "" {
+		t.Error("unexpected password secret:", password)
+	}
+}
@@ -0,0 +1,60 @@
+package parse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const EnvAppsPath = "DDEXEC_APPS_PATH"
+
+// SearchPath returns the directories to look for app configurations in
+func SearchPath() []string {
+	if appsPath := os.Getenv(EnvAppsPath); appsPath != "" {
+		var dirs []string
+
+		for _, dir := range filepath.SplitList(appsPath) {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+
+		return dirs
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+
+	return []string{filepath.Join(configHome, "ddexec", "apps")}
+}
+
+// FindConfiguration returns the path to the configuration file,
+// looking for it in the app search path if it doesn't exist as is
+func FindConfiguration(name string) string {
+	if _, err := os.Stat(name); err == nil || strings.Contains(name, "/") {
+		return name
+	}
+
+	if target, ok := FindInSearchPath(name); ok {
+		return target
+	}
+
+	return name
+}
+
+// FindInSearchPath looks for the configuration only in the app search path
+func FindInSearchPath(name string) (string, bool) {
+	for _, dir := range SearchPath() {
+		for _, candidate := range []string{name, name + ".yml", name + ".yaml"} {
+			target := filepath.Join(dir, candidate)
+
+			if fi, err := os.Stat(target); err == nil && !fi.IsDir() {
+				return target, true
+			}
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,357 @@
+package x11
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/xauth"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// the byte order we use for talking to the X server
+var order = binary.LittleEndian
+
+// GenericEvent is the code of the events of extensions (like XInput2) that can be longer than 32 bytes
+const GenericEvent = 35
+
+// Conn is a minimal X11 protocol client connection
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	lock     sync.Mutex
+	sequence uint16
+	pending  map[uint16]chan packet
+	asyncErr error
+	closed   bool
+
+	nextID uint32
+
+	ResourceIDBase   uint32
+	ResourceIDMask   uint32
+	MaxRequestLength int
+	RootWindow       uint32
+	RootVisual       uint32
+
+	// Events receives the events sent by the server
+	Events chan []byte
+}
+
+type packet struct {
+	data []byte
+	err  error
+}
+
+// Error is an error response from the X server
+type Error struct {
+	Code     byte
+	Sequence uint16
+	Value    uint32
+	Major    byte
+	Minor    uint16
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("X11 error %d (request %d.%d, value %d)", e.Code, e.Major, e.Minor, e.Value)
+}
+
+// Dial connects to the X server of the display, authenticating with the given cookie (if any)
+func Dial(display string, cookie *xauth.Entry) (*Conn, error) {
+	host, number := xauth.ParseDisplay(display)
+	if number == "" {
+		return nil, errors.New("invalid display: " + display)
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if host == "" {
+		conn, err = net.Dial("unix", "/tmp/.X11-unix/X"+number)
+	} else if n, e := strconv.Atoi(number); e != nil {
+		return nil, errors.New("invalid display: " + display)
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, cookie)
+}
+
+// DialSocket connects to the X server listening on the unix socket
+func DialSocket(path string, cookie *xauth.Entry) (*Conn, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, cookie)
+}
+
+// NewConn sets up the X11 connection on an already connected socket
+func NewConn(conn net.Conn, cookie *xauth.Entry) (*Conn, error) {
+	c := &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		pending: map[uint16]chan packet{},
+		Events:  make(chan []byte, 256),
+	}
+
+	if err := c.setup(cookie); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+func (c *Conn) setup(cookie *xauth.Entry) error {
+	var authName, authData []byte
+	if cookie != nil {
+		authName, authData = []byte(cookie.Name), cookie.Data
+	}
+
+	request := make([]byte, 12, 12+pad(len(authName))+pad(len(authData)))
+	request[0] = 'l'
+	order.PutUint16(request[2:], 11) // protocol major version
+	order.PutUint16(request[4:], 0)  // protocol minor version
+	order.PutUint16(request[6:], uint16(len(authName)))
+	order.PutUint16(request[8:], uint16(len(authData)))
+	request = append(request, padded(authName)...)
+	request = append(request, padded(authData)...)
+
+	if _, err := c.conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return err
+	}
+
+	data := make([]byte, int(order.Uint16(header[6:]))*4)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return err
+	}
+
+	switch header[0] {
+	case 0: // Failed
+		reasonLength := int(header[1])
+		if reasonLength > len(data) {
+			reasonLength = len(data)
+		}
+		return errors.New("X11 connection refused: " + string(data[:reasonLength]))
+
+	case 2: // Authenticate
+		return errors.New("X11 connection refused: further authentication required")
+	}
+
+	if len(data) < 32 {
+		return errors.New("X11 connection setup: unexpected response")
+	}
+
+	c.ResourceIDBase = order.Uint32(data[4:])
+	c.ResourceIDMask = order.Uint32(data[8:])
+	c.MaxRequestLength = int(order.Uint16(data[18:])) * 4
+
+	vendorLength := int(order.Uint16(data[16:]))
+	numFormats := int(data[21])
+
+	screenOffset := 32 + pad(vendorLength) + numFormats*8
+	if len(data) < screenOffset+36 {
+		return errors.New("X11 connection setup: no screens found")
+	}
+
+	c.RootWindow = order.Uint32(data[screenOffset:])
+	c.RootVisual = order.Uint32(data[screenOffset+32:])
+
+	return nil
+}
+
+func (c *Conn) readLoop() {
+	for {
+		data := make([]byte, 32)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			c.fail(err)
+			return
+		}
+
+		if data[0] == 1 || EventCode(data) == GenericEvent { // a reply or a generic event, possibly with additional data
+			if extra := int(order.Uint32(data[4:])) * 4; extra > 0 {
+				data = append(data, make([]byte, extra)...)
+				if _, err := io.ReadFull(c.reader, data[32:]); err != nil {
+					c.fail(err)
+					return
+				}
+			}
+		}
+
+		switch data[0] {
+		case 0:
+			xerr := &Error{
+				Code:     data[1],
+				Sequence: order.Uint16(data[2:]),
+				Value:    order.Uint32(data[4:]),
+				Minor:    order.Uint16(data[8:]),
+				Major:    data[10],
+			}
+
+			c.deliver(xerr.Sequence, packet{err: xerr})
+
+		case 1:
+			c.deliver(order.Uint16(data[2:]), packet{data: data})
+
+		default:
+			select {
+			case c.Events <- data:
+			default:
+				// nobody is processing the events
+			}
+		}
+	}
+}
+
+func (c *Conn) deliver(sequence uint16, p packet) {
+	c.lock.Lock()
+	ch, ok := c.pending[sequence]
+	delete(c.pending, sequence)
+	if !ok && p.err != nil {
+		c.asyncErr = p.err
+	}
+	c.lock.Unlock()
+
+	if ok {
+		ch <- p
+	}
+}
+
+func (c *Conn) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for sequence, ch := range c.pending {
+		ch <- packet{err: err}
+		delete(c.pending, sequence)
+	}
+
+	if !c.closed {
+		c.closed = true
+		close(c.Events)
+	}
+}
+
+// Close closes the connection to the X server
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// NewID allocates a new resource ID
+func (c *Conn) NewID() uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.nextID++
+
+	increment := c.ResourceIDMask & -c.ResourceIDMask // the lowest bit of the mask
+	return c.ResourceIDBase | ((c.nextID * increment) & c.ResourceIDMask)
+}
+
+// send writes a request, and waits for its reply if it expects one
+func (c *Conn) send(request []byte, hasReply bool) ([]byte, error) {
+	if len(request)%4 != 0 {
+		panic("unpadded X11 request")
+	}
+
+	order.PutUint16(request[2:], uint16(len(request)/4))
+
+	var ch chan packet
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, errors.New("X11 connection is closed")
+	}
+
+	c.sequence++
+
+	if hasReply {
+		ch = make(chan packet, 1)
+		c.pending[c.sequence] = ch
+	}
+
+	_, err := c.conn.Write(request)
+	c.lock.Unlock()
+
+	if err != nil || !hasReply {
+		return nil, err
+	}
+
+	p := <-ch
+	return p.data, p.err
+}
+
+// Sync waits for the server to process the previous requests,
+// and returns the last error of the ones that don't have replies
+func (c *Conn) Sync() error {
+	request := make([]byte, 4)
+	request[0] = 43 // GetInputFocus
+
+	if _, err := c.send(request, true); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.asyncErr
+	c.asyncErr = nil
+	return err
+}
+
+// QueryExtension returns the major opcode of the extension, if it's present
+func (c *Conn) QueryExtension(name string) (bool, byte, error) {
+	request := make([]byte, 8, 8+pad(len(name)))
+	request[0] = 98 // QueryExtension
+	order.PutUint16(request[4:], uint16(len(name)))
+	request = append(request, padded([]byte(name))...)
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return reply[8] != 0, reply[9], nil
+}
+
+// InternAtom returns the atom for the name
+func (c *Conn) InternAtom(name string) (uint32, error) {
+	request := make([]byte, 8, 8+pad(len(name)))
+	request[0] = 16 // InternAtom
+	order.PutUint16(request[4:], uint16(len(name)))
+	request = append(request, padded([]byte(name))...)
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return order.Uint32(reply[8:]), nil
+}
+
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+func padded(data []byte) []byte {
+	return append(data[:len(data):len(data)], make([]byte, pad(len(data))-len(data))...)
+}
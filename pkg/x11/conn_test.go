@@ -0,0 +1,180 @@
+package x11
+
+import (
+	"bytes"
+	"github.com/rycus86/ddexec/pkg/x11/x11test"
+	"github.com/rycus86/ddexec/pkg/xauth"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// connect sets up a connection to a new fake X server
+func connect(t *testing.T, setup func(s *x11test.Server)) (*x11test.Server, *Conn) {
+	server, client := x11test.NewServer()
+	if setup != nil {
+		setup(server)
+	}
+
+	conn, err := NewConn(client, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal("failed to connect:", err)
+	}
+
+	return server, conn
+}
+
+// nextEvent waits for the next event from the server
+func nextEvent(t *testing.T, conn *Conn) []byte {
+	select {
+	case event, ok := <-conn.Events:
+		if !ok {
+			t.Fatal("the connection was closed")
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+func TestSetup(t *testing.T) {
+	server, client := x11test.NewServer()
+	defer server.Close()
+
+	cookie := &xauth.Entry{Name: xauth.MitMagicCookie, Data: bytes.Repeat([]byte{0xab}, 16)}
+
+	conn, err := NewConn(client, cookie)
+	if err != nil {
+		t.Fatal("failed to connect:", err)
+	}
+
+	if conn.ResourceIDBase != x11test.ResourceIDBase || conn.ResourceIDMask != x11test.ResourceIDMask {
+		t.Errorf("unexpected resource IDs: %x %x", conn.ResourceIDBase, conn.ResourceIDMask)
+	}
+	if conn.MaxRequestLength != x11test.MaxRequestLength*4 {
+		t.Error("unexpected maximum request length:", conn.MaxRequestLength)
+	}
+	if conn.RootWindow != x11test.RootWindow || conn.RootVisual != x11test.RootVisual {
+		t.Errorf("unexpected screen: %x %x", conn.RootWindow, conn.RootVisual)
+	}
+
+	first, second := conn.NewID(), conn.NewID()
+	if first == second || first&^conn.ResourceIDMask != conn.ResourceIDBase || second&^conn.ResourceIDMask != conn.ResourceIDBase {
+		t.Errorf("unexpected resource IDs: %x %x", first, second)
+	}
+
+	// the connection works after the setup with a cookie
+	if atom, err := conn.InternAtom("CLIPBOARD"); err != nil || atom != server.Atom("CLIPBOARD") {
+		t.Error("failed to intern an atom:", atom, err)
+	}
+}
+
+func TestSetupRefused(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		io.ReadFull(server, make([]byte, 12))
+
+		reason := "No protocol specified"
+
+		response := make([]byte, 8, 8+pad(len(reason)))
+		response[1] = byte(len(reason))
+		order.PutUint16(response[6:], uint16(pad(len(reason))/4))
+		server.Write(append(response, padded([]byte(reason))...))
+	}()
+
+	if _, err := NewConn(client, nil); err == nil || !strings.Contains(err.Error(), "No protocol specified") {
+		t.Error("expected the connection to be refused with the reason, got", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server, conn := connect(t, func(s *x11test.Server) {
+		s.Reply = func(request []byte) []byte {
+			switch request[0] {
+			case 16: // InternAtom
+				return x11test.Error(14, request, 0) // BadIDChoice
+			case 18: // ChangeProperty
+				return x11test.Error(3, request, order.Uint32(request[4:])) // BadWindow
+			}
+			return nil
+		}
+	})
+	defer server.Close()
+
+	if _, err := conn.InternAtom("CLIPBOARD"); err == nil {
+		t.Error("expected an error for the request")
+	} else if xerr, ok := err.(*Error); !ok || xerr.Code != 14 || xerr.Major != 16 {
+		t.Error("unexpected error:", err)
+	}
+
+	if err := conn.ChangeProperty(0x1234, AtomString, AtomString, 8, []byte("data")); err != nil {
+		t.Fatal("unexpected error before the reply:", err)
+	}
+
+	if err := conn.Sync(); err == nil {
+		t.Error("expected the error of the previous request")
+	} else if xerr, ok := err.(*Error); !ok || xerr.Code != 3 || xerr.Value != 0x1234 || xerr.Major != 18 {
+		t.Error("unexpected error:", err)
+	}
+
+	if err := conn.Sync(); err != nil {
+		t.Error("expected the error to be returned once, got", err)
+	}
+}
+
+func TestGenericEvent(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	generic := make([]byte, 32+8)
+	generic[0] = GenericEvent
+	order.PutUint32(generic[4:], 2) // additional length
+	copy(generic[32:], "extended")
+
+	notify := make([]byte, 32)
+	notify[0] = SelectionNotify | 0x80
+
+	go server.Write(append(generic, notify...))
+
+	if event := nextEvent(t, conn); !bytes.Equal(event, generic) {
+		t.Error("unexpected generic event:", event)
+	}
+
+	if event := nextEvent(t, conn); EventCode(event) != SelectionNotify || len(event) != 32 {
+		t.Error("expected the next event after the generic one, got", event)
+	}
+
+	if t.Failed() {
+		return // the rest of the events would block the server
+	}
+
+	// the replies are still matched to their requests
+	if atom, err := conn.InternAtom("TARGETS"); err != nil || atom != server.Atom("TARGETS") {
+		t.Error("failed to intern an atom:", atom, err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	server, conn := connect(t, nil)
+
+	server.Close()
+
+	select {
+	case _, ok := <-conn.Events:
+		if ok {
+			t.Error("expected no events")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the events to be closed")
+	}
+
+	if _, err := conn.InternAtom("CLIPBOARD"); err == nil {
+		t.Error("expected an error on the closed connection")
+	}
+}
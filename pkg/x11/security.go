@@ -0,0 +1,88 @@
+package x11
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rycus86/ddexec/pkg/xauth"
+)
+
+// Trust levels of the authorizations generated with the SECURITY extension
+const (
+	TrustLevelTrusted   uint32 = 0
+	TrustLevelUntrusted uint32 = 1
+)
+
+const (
+	securityGenerateAuthorization = 1
+	securityRevokeAuthorization   = 2
+
+	securityAuthTimeout    = 1 << 0
+	securityAuthTrustLevel = 1 << 1
+)
+
+func (c *Conn) securityOpcode() (byte, error) {
+	if present, opcode, err := c.QueryExtension("SECURITY"); err != nil {
+		return 0, err
+	} else if !present {
+		return 0, errors.New("the X server does not support the SECURITY extension")
+	} else {
+		return opcode, nil
+	}
+}
+
+// GenerateAuthorization generates a new MIT-MAGIC-COOKIE-1 authorization with the trust level,
+// that expires after the timeout (in seconds) after its last client disconnected (or never with 0)
+func (c *Conn) GenerateAuthorization(trustLevel uint32, timeout uint32) (uint32, []byte, error) {
+	opcode, err := c.securityOpcode()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	authName := []byte(xauth.MitMagicCookie)
+
+	request := make([]byte, 12, 12+pad(len(authName))+8)
+	request[0] = opcode
+	request[1] = securityGenerateAuthorization
+	order.PutUint16(request[4:], uint16(len(authName)))
+	order.PutUint16(request[6:], 0) // let the server generate the cookie
+	order.PutUint32(request[8:], securityAuthTimeout|securityAuthTrustLevel)
+	request = append(request, padded(authName)...)
+	request = append(request, make([]byte, 8)...)
+	order.PutUint32(request[len(request)-8:], timeout)
+	order.PutUint32(request[len(request)-4:], trustLevel)
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	authID := order.Uint32(reply[8:])
+	dataLength := int(order.Uint16(reply[12:]))
+
+	if len(reply) < 32+dataLength {
+		return 0, nil, errors.New("unexpected reply for GenerateAuthorization")
+	}
+
+	data := make([]byte, dataLength)
+	copy(data, reply[32:])
+
+	return authID, data, nil
+}
+
+// RevokeAuthorization revokes an authorization generated earlier
+func (c *Conn) RevokeAuthorization(authID uint32) error {
+	opcode, err := c.securityOpcode()
+	if err != nil {
+		return err
+	}
+
+	request := make([]byte, 8)
+	request[0] = opcode
+	request[1] = securityRevokeAuthorization
+	order.PutUint32(request[4:], authID)
+
+	if _, err := c.send(request, false); err != nil {
+		return err
+	}
+
+	return c.Sync()
+}
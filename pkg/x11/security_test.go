@@ -0,0 +1,76 @@
+package x11
+
+import (
+	"bytes"
+	"github.com/rycus86/ddexec/pkg/x11/x11test"
+	"github.com/rycus86/ddexec/pkg/xauth"
+	"testing"
+)
+
+const testSecurityOpcode = 137
+
+func TestGenerateAuthorization(t *testing.T) {
+	cookie := bytes.Repeat([]byte{0x5a}, 16)
+
+	server, conn := connect(t, func(s *x11test.Server) {
+		s.Extensions["SECURITY"] = x11test.Extension{Opcode: testSecurityOpcode}
+		s.Reply = func(request []byte) []byte {
+			if request[0] != testSecurityOpcode || request[1] != securityGenerateAuthorization {
+				return nil
+			}
+
+			reply := make([]byte, 32, 32+len(cookie))
+			reply[0] = 1
+			order.PutUint32(reply[4:], uint32(len(cookie)/4))
+			order.PutUint32(reply[8:], 42) // authorization ID
+			order.PutUint16(reply[12:], uint16(len(cookie)))
+			return append(reply, cookie...)
+		}
+	})
+	defer server.Close()
+
+	authID, data, err := conn.GenerateAuthorization(TrustLevelUntrusted, 60)
+	if err != nil {
+		t.Fatal("failed to generate the authorization:", err)
+	}
+
+	if authID != 42 || !bytes.Equal(data, cookie) {
+		t.Error("unexpected authorization:", authID, data)
+	}
+
+	request := server.Request(testSecurityOpcode)
+	if request == nil {
+		t.Fatal("no GenerateAuthorization request")
+	}
+
+	nameLength := int(order.Uint16(request[4:]))
+	if string(request[12:12+nameLength]) != xauth.MitMagicCookie || order.Uint16(request[6:]) != 0 {
+		t.Errorf("unexpected authorization protocol: %q", request[12:12+nameLength])
+	}
+
+	if order.Uint32(request[8:]) != securityAuthTimeout|securityAuthTrustLevel {
+		t.Error("unexpected value mask:", order.Uint32(request[8:]))
+	}
+
+	values := request[12+pad(nameLength):]
+	if order.Uint32(values) != 60 || order.Uint32(values[4:]) != TrustLevelUntrusted {
+		t.Error("unexpected timeout and trust level:", values)
+	}
+
+	if err := conn.RevokeAuthorization(authID); err != nil {
+		t.Fatal("failed to revoke the authorization:", err)
+	}
+
+	if request := server.Request(testSecurityOpcode); request == nil || request[1] != securityRevokeAuthorization || order.Uint32(request[4:]) != 42 {
+		t.Error("unexpected RevokeAuthorization request:", request)
+	}
+}
+
+func TestSecurityMissing(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	if _, _, err := conn.GenerateAuthorization(TrustLevelUntrusted, 0); err == nil {
+		t.Error("expected an error without the SECURITY extension")
+	}
+}
@@ -0,0 +1,162 @@
+package x11
+
+// Event masks and codes used by ddexec
+const (
+	PropertyChangeMask uint32 = 0x400000
+
+	SelectionClear   = 29
+	SelectionRequest = 30
+	SelectionNotify  = 31
+
+	AtomNone   uint32 = 0
+	AtomAtom   uint32 = 4
+	AtomString uint32 = 31
+
+	CurrentTime uint32 = 0
+)
+
+// CreateWindow creates an invisible, input-only window under the root window
+func (c *Conn) CreateWindow(eventMask uint32) (uint32, error) {
+	wid := c.NewID()
+
+	request := make([]byte, 36)
+	request[0] = 1 // CreateWindow
+	order.PutUint32(request[4:], wid)
+	order.PutUint32(request[8:], c.RootWindow)
+	order.PutUint16(request[16:], 1) // width
+	order.PutUint16(request[18:], 1) // height
+	order.PutUint16(request[22:], 2) // InputOnly
+	order.PutUint32(request[28:], 0x800)
+	order.PutUint32(request[32:], eventMask)
+
+	if _, err := c.send(request, false); err != nil {
+		return 0, err
+	}
+
+	return wid, c.Sync()
+}
+
+// GetProperty reads (and optionally deletes) a property of a window
+func (c *Conn) GetProperty(window, property uint32, delete bool, maxLength int) (propertyType uint32, format byte, data []byte, err error) {
+	request := make([]byte, 24)
+	request[0] = 20 // GetProperty
+	if delete {
+		request[1] = 1
+	}
+	order.PutUint32(request[4:], window)
+	order.PutUint32(request[8:], property)
+	order.PutUint32(request[12:], 0) // AnyPropertyType
+	order.PutUint32(request[16:], 0)
+	order.PutUint32(request[20:], uint32(pad(maxLength)/4))
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	format = reply[1]
+	propertyType = order.Uint32(reply[8:])
+	length := int(order.Uint32(reply[16:])) * int(format) / 8
+
+	if length > len(reply)-32 {
+		length = len(reply) - 32
+	}
+
+	data = make([]byte, length)
+	copy(data, reply[32:])
+
+	return propertyType, format, data, nil
+}
+
+// ChangeProperty replaces the property of a window
+func (c *Conn) ChangeProperty(window, property, propertyType uint32, format byte, data []byte) error {
+	request := make([]byte, 24, 24+pad(len(data)))
+	request[0] = 18 // ChangeProperty
+	order.PutUint32(request[4:], window)
+	order.PutUint32(request[8:], property)
+	order.PutUint32(request[12:], propertyType)
+	request[16] = format
+	order.PutUint32(request[20:], uint32(len(data)*8/int(format)))
+	request = append(request, padded(data)...)
+
+	_, err := c.send(request, false)
+	return err
+}
+
+// GetSelectionOwner returns the window owning the selection
+func (c *Conn) GetSelectionOwner(selection uint32) (uint32, error) {
+	request := make([]byte, 8)
+	request[0] = 23 // GetSelectionOwner
+	order.PutUint32(request[4:], selection)
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return 0, err
+	}
+
+	return order.Uint32(reply[8:]), nil
+}
+
+// SetSelectionOwner makes the window the owner of the selection
+func (c *Conn) SetSelectionOwner(owner, selection uint32) error {
+	request := make([]byte, 16)
+	request[0] = 22 // SetSelectionOwner
+	order.PutUint32(request[4:], owner)
+	order.PutUint32(request[8:], selection)
+	order.PutUint32(request[12:], CurrentTime)
+
+	_, err := c.send(request, false)
+	return err
+}
+
+// ConvertSelection asks the owner of the selection to store it in the property of the requestor window
+func (c *Conn) ConvertSelection(requestor, selection, target, property uint32) error {
+	request := make([]byte, 24)
+	request[0] = 24 // ConvertSelection
+	order.PutUint32(request[4:], requestor)
+	order.PutUint32(request[8:], selection)
+	order.PutUint32(request[12:], target)
+	order.PutUint32(request[16:], property)
+	order.PutUint32(request[20:], CurrentTime)
+
+	_, err := c.send(request, false)
+	return err
+}
+
+// SendSelectionNotify notifies the requestor that the selection was stored (or refused with AtomNone)
+func (c *Conn) SendSelectionNotify(timestamp, requestor, selection, target, property uint32) error {
+	request := make([]byte, 44)
+	request[0] = 25 // SendEvent
+	order.PutUint32(request[4:], requestor)
+	order.PutUint32(request[8:], 0) // no event mask
+
+	event := request[12:]
+	event[0] = SelectionNotify
+	order.PutUint32(event[4:], timestamp)
+	order.PutUint32(event[8:], requestor)
+	order.PutUint32(event[12:], selection)
+	order.PutUint32(event[16:], target)
+	order.PutUint32(event[20:], property)
+
+	_, err := c.send(request, false)
+	return err
+}
+
+// EventCode returns the type of the event (without the flag for events from SendEvent)
+func EventCode(event []byte) byte {
+	return event[0] & 0x7f
+}
+
+// Uint32At reads a 32 bit value from an event
+func Uint32At(event []byte, offset int) uint32 {
+	return order.Uint32(event[offset:])
+}
+
+// EncodeAtoms serializes a list of atoms as property data
+func EncodeAtoms(atoms ...uint32) []byte {
+	data := make([]byte, 4*len(atoms))
+	for idx, atom := range atoms {
+		order.PutUint32(data[4*idx:], atom)
+	}
+	return data
+}
@@ -0,0 +1,141 @@
+package x11
+
+import (
+	"bytes"
+	"github.com/rycus86/ddexec/pkg/x11/x11test"
+	"testing"
+)
+
+func TestCreateWindow(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	window, err := conn.CreateWindow(PropertyChangeMask)
+	if err != nil {
+		t.Fatal("failed to create the window:", err)
+	}
+
+	if window&^x11test.ResourceIDMask != x11test.ResourceIDBase || window == x11test.ResourceIDBase {
+		t.Errorf("unexpected window ID: %x", window)
+	}
+
+	request := server.Request(1)
+	if request == nil {
+		t.Fatal("no CreateWindow request")
+	}
+
+	if Uint32At(request, 4) != window || Uint32At(request, 8) != x11test.RootWindow {
+		t.Error("unexpected window or parent:", request)
+	}
+	if order.Uint16(request[22:]) != 2 {
+		t.Error("expected an InputOnly window")
+	}
+	if Uint32At(request, 28) != 0x800 || Uint32At(request, 32) != PropertyChangeMask {
+		t.Error("unexpected event mask:", request[28:])
+	}
+}
+
+func TestProperties(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	window, property := uint32(0x1200001), uint32(200)
+
+	if err := conn.ChangeProperty(window, property, AtomString, 8, []byte("hello")); err != nil {
+		t.Fatal("failed to change the property:", err)
+	}
+
+	if err := conn.Sync(); err != nil {
+		t.Fatal("failed to sync:", err)
+	}
+
+	if propertyType, format, data := server.Property(window, property); propertyType != AtomString || format != 8 || string(data) != "hello" {
+		t.Errorf("unexpected property on the server: %d %d %q", propertyType, format, data)
+	}
+
+	propertyType, format, data, err := conn.GetProperty(window, property, false, 1024)
+	if err != nil {
+		t.Fatal("failed to get the property:", err)
+	}
+
+	if propertyType != AtomString || format != 8 || string(data) != "hello" {
+		t.Errorf("unexpected property: %d %d %q", propertyType, format, data)
+	}
+
+	atoms := EncodeAtoms(AtomAtom, AtomString, 0x12345678)
+	server.SetProperty(window, property, AtomAtom, 32, atoms)
+
+	propertyType, format, data, err = conn.GetProperty(window, property, true, 1024)
+	if err != nil {
+		t.Fatal("failed to get the property:", err)
+	}
+
+	if propertyType != AtomAtom || format != 32 || !bytes.Equal(data, atoms) {
+		t.Errorf("unexpected atoms: %d %d %v", propertyType, format, data)
+	}
+
+	if Uint32At(data, 8) != 0x12345678 {
+		t.Errorf("unexpected atom: %x", Uint32At(data, 8))
+	}
+
+	if _, _, data, _ := conn.GetProperty(window, property, false, 1024); len(data) != 0 {
+		t.Error("expected the property to be deleted, got", data)
+	}
+}
+
+func TestSelections(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	window, selection := uint32(0x1200001), uint32(300)
+
+	if err := conn.SetSelectionOwner(window, selection); err != nil {
+		t.Fatal("failed to own the selection:", err)
+	}
+
+	if owner, err := conn.GetSelectionOwner(selection); err != nil {
+		t.Fatal("failed to get the owner:", err)
+	} else if owner != window {
+		t.Errorf("unexpected owner: %x", owner)
+	}
+
+	if err := conn.ConvertSelection(window, selection, AtomString, 400); err != nil {
+		t.Fatal("failed to convert the selection:", err)
+	}
+
+	if request := server.Request(24); request == nil {
+		t.Error("no ConvertSelection request")
+	} else if Uint32At(request, 4) != window || Uint32At(request, 8) != selection ||
+		Uint32At(request, 12) != AtomString || Uint32At(request, 16) != 400 {
+		t.Error("unexpected ConvertSelection request:", request)
+	}
+
+	if err := conn.SendSelectionNotify(123, 0x3400001, selection, AtomString, AtomNone); err != nil {
+		t.Fatal("failed to notify the requestor:", err)
+	}
+
+	request := server.Request(25)
+	if request == nil {
+		t.Fatal("no SendEvent request")
+	}
+
+	event := request[12:]
+	if EventCode(event) != SelectionNotify || Uint32At(event, 4) != 123 || Uint32At(event, 8) != 0x3400001 ||
+		Uint32At(event, 12) != selection || Uint32At(event, 16) != AtomString || Uint32At(event, 20) != AtomNone {
+		t.Error("unexpected SelectionNotify event:", event)
+	}
+}
+
+func TestEventCode(t *testing.T) {
+	event := make([]byte, 32)
+
+	event[0] = SelectionRequest
+	if EventCode(event) != SelectionRequest {
+		t.Error("unexpected event code:", EventCode(event))
+	}
+
+	event[0] = SelectionNotify | 0x80 // sent with SendEvent
+	if EventCode(event) != SelectionNotify {
+		t.Error("unexpected event code:", EventCode(event))
+	}
+}
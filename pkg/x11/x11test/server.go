@@ -0,0 +1,307 @@
+// Package x11test provides a fake X server for testing the X11 protocol clients
+package x11test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	RootWindow     uint32 = 0x100
+	RootVisual     uint32 = 0x21
+	ResourceIDBase uint32 = 0x1200000
+	ResourceIDMask uint32 = 0x1fffff
+
+	// MaxRequestLength is the maximum request length of the server in 4 byte units
+	MaxRequestLength = 0xffff
+
+	requestTimeout = 2 * time.Second
+)
+
+var order = binary.LittleEndian
+
+// Extension is an extension supported by the server
+type Extension struct {
+	Opcode     byte
+	FirstEvent byte
+}
+
+// Server answers the core requests used by ddexec on one end of a pipe,
+// and records every request of the client
+type Server struct {
+	conn net.Conn
+
+	// Extensions are the extensions present on the server, their QueryVersion requests (minor 0) are answered
+	Extensions map[string]Extension
+
+	// Reply can answer the requests before the built-in handlers, with a reply, an error or nil to fall through,
+	// the sequence number is filled in by the server
+	Reply func(request []byte) []byte
+
+	lock       sync.Mutex
+	sequence   uint16
+	atoms      map[string]uint32
+	owners     map[uint32]uint32
+	properties map[[2]uint32]property
+
+	requests chan []byte
+}
+
+type property struct {
+	propertyType uint32
+	format       byte
+	data         []byte
+}
+
+// NewServer returns the fake server with the client end of the connection,
+// the server starts answering once the client sent its connection setup
+func NewServer() (*Server, net.Conn) {
+	server, client := net.Pipe()
+
+	s := &Server{
+		conn:       server,
+		Extensions: map[string]Extension{},
+		atoms:      map[string]uint32{"ATOM": 4, "STRING": 31},
+		owners:     map[uint32]uint32{},
+		properties: map[[2]uint32]property{},
+		requests:   make(chan []byte, 256),
+	}
+
+	go s.serve()
+
+	return s, client
+}
+
+// Close closes the connection to the client
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Atom returns the atom the server assigned to the name (or 0 if it was not interned yet)
+func (s *Server) Atom(name string) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.atoms[name]
+}
+
+// Property returns the contents of the property of the window
+func (s *Server) Property(window, atom uint32) (uint32, byte, []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p := s.properties[[2]uint32{window, atom}]
+	return p.propertyType, p.format, p.data
+}
+
+// SetProperty changes the property of the window, as another client would
+func (s *Server) SetProperty(window, atom, propertyType uint32, format byte, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.properties[[2]uint32{window, atom}] = property{propertyType, format, data}
+}
+
+// Write sends raw data to the client, like events
+func (s *Server) Write(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.conn.Write(data)
+	return err
+}
+
+// Request waits for the next request of the client with the major opcode,
+// skipping the others, and returns nil if it does not arrive in time
+func (s *Server) Request(opcode byte) []byte {
+	timeout := time.After(requestTimeout)
+
+	for {
+		select {
+		case request := <-s.requests:
+			if request[0] == opcode {
+				return request
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func (s *Server) serve() {
+	if err := s.setup(); err != nil {
+		return
+	}
+
+	header := make([]byte, 4)
+
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			return
+		}
+
+		request := make([]byte, int(order.Uint16(header[2:]))*4)
+		copy(request, header)
+
+		if _, err := io.ReadFull(s.conn, request[4:]); err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		s.sequence++
+		sequence := s.sequence
+		reply := s.handle(request)
+		s.lock.Unlock()
+
+		select {
+		case s.requests <- request:
+		default:
+			// nobody is checking the requests
+		}
+
+		if reply != nil {
+			order.PutUint16(reply[2:], sequence)
+			if s.Write(reply) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) setup() error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(s.conn, header); err != nil {
+		return err
+	}
+
+	auth := make([]byte, pad(int(order.Uint16(header[6:])))+pad(int(order.Uint16(header[8:]))))
+	if _, err := io.ReadFull(s.conn, auth); err != nil {
+		return err
+	}
+
+	// no vendor string and pixmap formats, one screen without depths
+	data := make([]byte, 32+40)
+	order.PutUint32(data[4:], ResourceIDBase)
+	order.PutUint32(data[8:], ResourceIDMask)
+	order.PutUint16(data[18:], MaxRequestLength)
+	data[20] = 1 // number of screens
+	order.PutUint32(data[32:], RootWindow)
+	order.PutUint32(data[64:], RootVisual)
+
+	response := make([]byte, 8, 8+len(data))
+	response[0] = 1 // Success
+	order.PutUint16(response[2:], 11)
+	order.PutUint16(response[6:], uint16(len(data)/4))
+
+	return s.Write(append(response, data...))
+}
+
+// handle answers the request, returning nil for the requests without a reply
+func (s *Server) handle(request []byte) []byte {
+	if s.Reply != nil {
+		if reply := s.Reply(request); reply != nil {
+			return reply
+		}
+	}
+
+	switch request[0] {
+	case 16: // InternAtom
+		name := string(request[8 : 8+order.Uint16(request[4:])])
+
+		atom, ok := s.atoms[name]
+		if !ok && request[1] == 0 {
+			atom = uint32(100 + len(s.atoms))
+			s.atoms[name] = atom
+		}
+
+		return withValue(atom)
+
+	case 18: // ChangeProperty
+		var (
+			format = request[16]
+			length = int(order.Uint32(request[20:])) * int(format) / 8
+		)
+
+		s.properties[[2]uint32{order.Uint32(request[4:]), order.Uint32(request[8:])}] = property{
+			propertyType: order.Uint32(request[12:]),
+			format:       format,
+			data:         append([]byte(nil), request[24:24+length]...),
+		}
+
+	case 20: // GetProperty
+		key := [2]uint32{order.Uint32(request[4:]), order.Uint32(request[8:])}
+		p := s.properties[key]
+
+		if request[1] != 0 {
+			delete(s.properties, key)
+		}
+
+		reply := make([]byte, 32, 32+pad(len(p.data)))
+		reply[0] = 1
+		reply[1] = p.format
+		order.PutUint32(reply[4:], uint32(pad(len(p.data))/4))
+		order.PutUint32(reply[8:], p.propertyType)
+		if p.format > 0 {
+			order.PutUint32(reply[16:], uint32(len(p.data)*8/int(p.format)))
+		}
+		reply = append(reply, make([]byte, pad(len(p.data)))...)
+		copy(reply[32:], p.data)
+		return reply
+
+	case 22: // SetSelectionOwner
+		s.owners[order.Uint32(request[8:])] = order.Uint32(request[4:])
+
+	case 23: // GetSelectionOwner
+		return withValue(s.owners[order.Uint32(request[4:])])
+
+	case 43: // GetInputFocus
+		return withValue(RootWindow)
+
+	case 98: // QueryExtension
+		name := string(request[8 : 8+order.Uint16(request[4:])])
+
+		reply := withValue(0)
+		if extension, ok := s.Extensions[name]; ok {
+			reply[8] = 1
+			reply[9] = extension.Opcode
+			reply[10] = extension.FirstEvent
+		}
+		return reply
+
+	default:
+		for _, extension := range s.Extensions {
+			if request[0] == extension.Opcode && request[1] == 0 { // QueryVersion
+				return withValue(0)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withValue returns a reply without additional data, with the value in its first field
+func withValue(value uint32) []byte {
+	reply := make([]byte, 32)
+	reply[0] = 1
+	order.PutUint32(reply[8:], value)
+	return reply
+}
+
+// Error returns an error response for the request to return from Reply
+func Error(code byte, request []byte, value uint32) []byte {
+	response := make([]byte, 32)
+	response[1] = code
+	order.PutUint32(response[4:], value)
+	if request[0] >= 128 { // the minor opcode of extensions
+		order.PutUint16(response[8:], uint16(request[1]))
+	}
+	response[10] = request[0]
+	return response
+}
+
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,67 @@
+package x11
+
+import "github.com/pkg/errors"
+
+const (
+	xfixesQueryVersion         = 0
+	xfixesSelectSelectionInput = 2
+
+	XFixesSetSelectionOwnerNotifyMask uint32 = 1 << 0
+)
+
+// XFixes is the XFIXES extension on a connection
+type XFixes struct {
+	conn       *Conn
+	opcode     byte
+	FirstEvent byte
+}
+
+// InitXFixes initializes the XFIXES extension (its version has to be negotiated before using it)
+func (c *Conn) InitXFixes() (*XFixes, error) {
+	request := make([]byte, 8, 12)
+	request[0] = 98 // QueryExtension
+	order.PutUint16(request[4:], uint16(len("XFIXES")))
+	request = append(request, padded([]byte("XFIXES"))...)
+
+	reply, err := c.send(request, true)
+	if err != nil {
+		return nil, err
+	} else if reply[8] == 0 {
+		return nil, errors.New("the X server does not support the XFIXES extension")
+	}
+
+	xf := &XFixes{conn: c, opcode: reply[9], FirstEvent: reply[10]}
+
+	version := make([]byte, 12)
+	version[0] = xf.opcode
+	version[1] = xfixesQueryVersion
+	order.PutUint32(version[4:], 5)
+	order.PutUint32(version[8:], 0)
+
+	if _, err := c.send(version, true); err != nil {
+		return nil, err
+	}
+
+	return xf, nil
+}
+
+// SelectSelectionInput asks for events on the window about changes of the selection
+func (xf *XFixes) SelectSelectionInput(window, selection, eventMask uint32) error {
+	request := make([]byte, 16)
+	request[0] = xf.opcode
+	request[1] = xfixesSelectSelectionInput
+	order.PutUint32(request[4:], window)
+	order.PutUint32(request[8:], selection)
+	order.PutUint32(request[12:], eventMask)
+
+	if _, err := xf.conn.send(request, false); err != nil {
+		return err
+	}
+
+	return xf.conn.Sync()
+}
+
+// IsSelectionNotify returns true for the XFixesSelectionNotify events
+func (xf *XFixes) IsSelectionNotify(event []byte) bool {
+	return EventCode(event) == xf.FirstEvent
+}
@@ -0,0 +1,78 @@
+package x11
+
+import (
+	"github.com/rycus86/ddexec/pkg/x11/x11test"
+	"testing"
+)
+
+func TestXFixes(t *testing.T) {
+	server, conn := connect(t, func(s *x11test.Server) {
+		s.Extensions["XFIXES"] = x11test.Extension{Opcode: 138, FirstEvent: 87}
+	})
+	defer server.Close()
+
+	xfixes, err := conn.InitXFixes()
+	if err != nil {
+		t.Fatal("failed to initialize XFIXES:", err)
+	}
+
+	if request := server.Request(138); request == nil || request[1] != xfixesQueryVersion || Uint32At(request, 4) != 5 {
+		t.Error("expected to negotiate version 5, got", request)
+	}
+
+	window, selection := uint32(0x1200001), uint32(300)
+
+	if err := xfixes.SelectSelectionInput(window, selection, XFixesSetSelectionOwnerNotifyMask); err != nil {
+		t.Fatal("failed to select the selection input:", err)
+	}
+
+	if request := server.Request(138); request == nil || request[1] != xfixesSelectSelectionInput {
+		t.Error("no SelectSelectionInput request")
+	} else if Uint32At(request, 4) != window || Uint32At(request, 8) != selection || Uint32At(request, 12) != XFixesSetSelectionOwnerNotifyMask {
+		t.Error("unexpected SelectSelectionInput request:", request)
+	}
+
+	event := make([]byte, 32)
+
+	event[0] = 87
+	if !xfixes.IsSelectionNotify(event) {
+		t.Error("expected a selection notify event")
+	}
+
+	event[0] = SelectionNotify
+	if xfixes.IsSelectionNotify(event) {
+		t.Error("expected a core event")
+	}
+}
+
+func TestXFixesMissing(t *testing.T) {
+	server, conn := connect(t, nil)
+	defer server.Close()
+
+	if _, err := conn.InitXFixes(); err == nil {
+		t.Error("expected an error without the XFIXES extension")
+	}
+}
+
+func TestXFixesSelectError(t *testing.T) {
+	server, conn := connect(t, func(s *x11test.Server) {
+		s.Extensions["XFIXES"] = x11test.Extension{Opcode: 138, FirstEvent: 87}
+		s.Reply = func(request []byte) []byte {
+			if request[0] == 138 && request[1] == xfixesSelectSelectionInput {
+				return x11test.Error(3, request, Uint32At(request, 4)) // BadWindow
+			}
+			return nil
+		}
+	})
+	defer server.Close()
+
+	xfixes, err := conn.InitXFixes()
+	if err != nil {
+		t.Fatal("failed to initialize XFIXES:", err)
+	}
+
+	err = xfixes.SelectSelectionInput(0x1200001, 300, XFixesSetSelectionOwnerNotifyMask)
+	if xerr, ok := err.(*Error); !ok || xerr.Code != 3 || xerr.Major != 138 || xerr.Minor != xfixesSelectSelectionInput {
+		t.Error("expected a BadWindow error, got", err)
+	}
+}
@@ -0,0 +1,21 @@
+package xauth
+
+// Address families used in Xauthority files
+const (
+	FamilyInternet  uint16 = 0
+	FamilyDECnet    uint16 = 1
+	FamilyChaos     uint16 = 2
+	FamilyInternet6 uint16 = 6
+	FamilyLocal     uint16 = 256
+	FamilyWild      uint16 = 65535
+)
+
+const MitMagicCookie = "MIT-MAGIC-COOKIE-1"
+
+type Entry struct {
+	Family  uint16
+	Address []byte
+	Number  string
+	Name    string
+	Data    []byte
+}
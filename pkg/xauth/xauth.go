@@ -0,0 +1,190 @@
+package xauth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read parses the entries of an Xauthority file
+func Read(r io.Reader) ([]Entry, error) {
+	var (
+		entries []Entry
+		reader  = bufio.NewReader(r)
+	)
+
+	for {
+		var family uint16
+		if err := binary.Read(reader, binary.BigEndian, &family); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+
+		var fields [4][]byte
+
+		for idx := range fields {
+			if field, err := readField(reader); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return entries, err
+			} else {
+				fields[idx] = field
+			}
+		}
+
+		entries = append(entries, Entry{
+			Family:  family,
+			Address: fields[0],
+			Number:  string(fields[1]),
+			Name:    string(fields[2]),
+			Data:    fields[3],
+		})
+	}
+}
+
+func readField(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	field := make([]byte, length)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// Write serializes the entries in the Xauthority file format
+func Write(w io.Writer, entries ...Entry) error {
+	for _, entry := range entries {
+		if err := binary.Write(w, binary.BigEndian, entry.Family); err != nil {
+			return err
+		}
+
+		for _, field := range [][]byte{entry.Address, []byte(entry.Number), []byte(entry.Name), entry.Data} {
+			if err := binary.Write(w, binary.BigEndian, uint16(len(field))); err != nil {
+				return err
+			}
+
+			if _, err := w.Write(field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Marshal returns the entries in the Xauthority file format
+func Marshal(entries ...Entry) []byte {
+	var b bytes.Buffer
+	Write(&b, entries...) // writing to a buffer doesn't fail
+	return b.Bytes()
+}
+
+// ReadFile parses the entries of the Xauthority file at the given path
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// HostFile returns the path to the Xauthority file of the current user
+func HostFile() string {
+	if path := os.Getenv("XAUTHORITY"); path != "" {
+		return path
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".Xauthority")
+}
+
+// HostCookie returns the cookie of the display from the host Xauthority file
+func HostCookie(display string) (*Entry, error) {
+	entries, err := ReadFile(HostFile())
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := FindCookie(entries, display)
+	if cookie == nil {
+		return nil, errors.New("no X authority cookie found for DISPLAY=" + display)
+	}
+
+	return cookie, nil
+}
+
+// ParseDisplay splits a display name like `host:0.0` into its host and display number
+func ParseDisplay(display string) (host string, number string) {
+	idx := strings.LastIndex(display, ":")
+	if idx < 0 {
+		return "", ""
+	}
+
+	host, number = display[:idx], display[idx+1:]
+
+	if dot := strings.Index(number, "."); dot >= 0 {
+		number = number[:dot]
+	}
+
+	if strings.HasPrefix(host, "/") || host == "unix" {
+		host = "" // like /tmp/launch-xyz/org.xquartz:0 or unix:0
+	}
+
+	return host, number
+}
+
+// FindCookie returns the best MIT-MAGIC-COOKIE-1 entry for the display
+func FindCookie(entries []Entry, display string) *Entry {
+	host, number := ParseDisplay(display)
+	if number == "" {
+		return nil
+	}
+
+	localHostname, _ := os.Hostname()
+
+	var wildcard *Entry
+
+	for idx := range entries {
+		entry := &entries[idx]
+
+		if entry.Name != MitMagicCookie {
+			continue
+		}
+
+		if entry.Number != "" && entry.Number != number {
+			continue
+		}
+
+		switch entry.Family {
+		case FamilyWild:
+			if wildcard == nil {
+				wildcard = entry
+			}
+
+		case FamilyLocal:
+			if (host == "" || host == localHostname) && string(entry.Address) == localHostname {
+				return entry
+			}
+
+		default:
+			if host != "" && string(entry.Address) == host {
+				return entry
+			}
+		}
+	}
+
+	return wildcard
+}
@@ -0,0 +1,83 @@
+package xauth
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	entries := []Entry{
+		{Family: FamilyLocal, Address: []byte("workstation"), Number: "0", Name: MitMagicCookie, Data: []byte{1, 2, 3, 4}},
+		{Family: FamilyWild, Number: "1", Name: MitMagicCookie, Data: []byte{5, 6, 7, 8}},
+	}
+
+	parsed, err := Read(bytes.NewReader(Marshal(entries...)))
+	if err != nil {
+		t.Fatal("failed to read entries:", err)
+	}
+
+	if len(parsed) != len(entries) {
+		t.Fatal("unexpected number of entries:", len(parsed))
+	}
+
+	for idx, entry := range parsed {
+		expected := entries[idx]
+
+		if entry.Family != expected.Family || !bytes.Equal(entry.Address, expected.Address) ||
+			entry.Number != expected.Number || entry.Name != expected.Name || !bytes.Equal(entry.Data, expected.Data) {
+			t.Errorf("unexpected entry at %d: %+v", idx, entry)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data := Marshal(Entry{Family: FamilyWild, Number: "0", Name: MitMagicCookie, Data: []byte{1, 2, 3, 4}})
+
+	if _, err := Read(bytes.NewReader(data[:len(data)-2])); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+}
+
+func TestParseDisplay(t *testing.T) {
+	for display, expected := range map[string][2]string{
+		":0":                            {"", "0"},
+		":1.0":                          {"", "1"},
+		"unix:2":                        {"", "2"},
+		"remote:10.0":                   {"remote", "10"},
+		"/tmp/launch-xyz/org.xquartz:0": {"", "0"},
+		"invalid":                       {"", ""},
+	} {
+		if host, number := ParseDisplay(display); host != expected[0] || number != expected[1] {
+			t.Errorf("unexpected result for %s: %s, %s", display, host, number)
+		}
+	}
+}
+
+func TestFindCookie(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	entries := []Entry{
+		{Family: FamilyWild, Number: "0", Name: MitMagicCookie, Data: []byte("wild")},
+		{Family: FamilyLocal, Address: []byte("other-host"), Number: "0", Name: MitMagicCookie, Data: []byte("other")},
+		{Family: FamilyLocal, Address: []byte(hostname), Number: "0", Name: "XDM-AUTHORIZATION-1", Data: []byte("xdm")},
+		{Family: FamilyLocal, Address: []byte(hostname), Number: "0", Name: MitMagicCookie, Data: []byte("local")},
+		{Family: FamilyLocal, Address: []byte(hostname), Number: "1", Name: MitMagicCookie, Data: []byte("local-1")},
+	}
+
+	if found := FindCookie(entries, ":0"); found == nil || string(found.Data) != "local" {
+		t.Errorf("unexpected cookie for :0 : %+v", found)
+	}
+
+	if found := FindCookie(entries, ":1.0"); found == nil || string(found.Data) != "local-1" {
+		t.Errorf("unexpected cookie for :1.0 : %+v", found)
+	}
+
+	if found := FindCookie(entries[:2], ":0"); found == nil || string(found.Data) != "wild" {
+		t.Errorf("expected the wildcard cookie, got: %+v", found)
+	}
+
+	if found := FindCookie(entries, ":5"); found != nil {
+		t.Errorf("unexpected cookie for :5 : %+v", found)
+	}
+}
@@ -4,51 +4,48 @@ import (
 	"context"
 	"fmt"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/rycus86/ddexec/pkg/debug"
-	"os"
-	"strings"
+	"github.com/rycus86/ddexec/pkg/dockerclient"
+	"io"
 )
 
-func ExecInContainer(containerId string, command string) (bool, error) {
+// ExecInContainer runs the command in the container, copying its output to the writers,
+// and returns its exit code, it stops waiting for the command when the context is cancelled
+func ExecInContainer(ctx context.Context, containerId string, command []string, stdout, stderr io.Writer) (int, error) {
 	if debug.IsEnabled() {
-		fmt.Println("exec in", containerId, ">", command)
+		fmt.Printf("exec in %s > %q\n", containerId, command)
 	}
 
-	// TODO dupe of exec/client.go
-
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := dockerclient.New()
 	if err != nil {
-		return false, err
+		return -1, err
 	}
 	defer cli.Close()
 
-	cli.NegotiateAPIVersion(context.Background())
-
-	// TODO /bin/sh -c needs special characters escaped
-	command = strings.ReplaceAll(command, "&", "\\&")
-
-	exec, err := cli.ContainerExecCreate(context.Background(), containerId, types.ExecConfig{
-		// TODO using /bin/sh for now
-		Cmd:          []string{"/bin/sh", "-c", command},
+	exec, err := cli.ContainerExecCreate(ctx, containerId, types.ExecConfig{
+		Cmd:          command,
 		Detach:       false,
 		AttachStdout: true,
 		AttachStderr: true,
 	})
 	if err != nil {
-		fmt.Println("exec create failed:", err)
-		return false, err
+		if debug.IsEnabled() {
+			fmt.Println("exec create failed:", err)
+		}
+		return -1, err
 	}
 
 	if debug.IsEnabled() {
 		fmt.Println("exec created OK")
 	}
 
-	resp, err := cli.ContainerExecAttach(context.Background(), exec.ID, types.ExecStartCheck{})
+	resp, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
 	if err != nil {
-		fmt.Println("exec attach failed:", err)
-		return false, err
+		if debug.IsEnabled() {
+			fmt.Println("exec attach failed:", err)
+		}
+		return -1, err
 	}
 	defer resp.Close()
 
@@ -56,30 +53,55 @@ func ExecInContainer(containerId string, command string) (bool, error) {
 		fmt.Println("exec attached OK")
 	}
 
-	if err = cli.ContainerExecStart(context.Background(), exec.ID, types.ExecStartCheck{}); err != nil {
-		fmt.Println("exec start failed:", err)
-		return false, err
+	if err = cli.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{}); err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("exec start failed:", err)
+		}
+		return -1, err
 	}
 
 	if debug.IsEnabled() {
 		fmt.Println("exec started OK")
 	}
 
-	stdcopy.StdCopy(os.Stdout, os.Stderr, resp.Reader)
+	// the attached stream does not follow the context, close it when cancelled
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		if debug.IsEnabled() {
+			fmt.Println("exec read failed:", err)
+		}
+		return -1, err
+	}
 
 	if debug.IsEnabled() {
 		fmt.Println("exec read OK")
 	}
 
-	inspect, err := cli.ContainerExecInspect(context.Background(), exec.ID)
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
 	if err != nil {
-		fmt.Println("exec inspect failed:", err)
-		return false, err
+		if debug.IsEnabled() {
+			fmt.Println("exec inspect failed:", err)
+		}
+		return -1, err
 	}
 
 	if debug.IsEnabled() {
 		fmt.Println("exec inspect OK, exit:", inspect.ExitCode)
 	}
 
-	return inspect.ExitCode == 0, nil
+	return inspect.ExitCode, nil
 }
@@ -6,15 +6,7 @@ import (
 	"os"
 )
 
-func CheckArgs() {
-	if len(os.Args) != 2 {
-		fmt.Println("Error: Expected a file or a URL as the only parameter.")
-		fmt.Println("Use `-h` or `--help` for options")
-		os.Exit(1)
-	}
-
-	if os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "--manual" {
-		fmt.Println(`xdg-open {file|URL}
+const usage = `xdg-open {file|URL}
 xdg-open {--help|--manual|--version}
 
 xdg-open emulation by ddexec ( https://github.com/rycus86/ddexec )
@@ -27,7 +19,23 @@ The mime handlers are configured in the ddexec config yaml:
     xdg_open:
       text/plain: vim <arg>
       x-scheme-handler/http:  chrome --user-data-dir=/data <arg>
-      x-scheme-handler/https: chrome --user-data-dir=/data <arg>
+      x-scheme-handler/https: chrome --user-data-dir=/data %u
+
+The commands are split into arguments with shell-like quoting, then <arg> and the
+field codes of .desktop files (%f %F %u %U) are replaced by the file or URL, which
+is appended when none of them is present. The command runs without a shell.
+Without access to the Docker daemon, the files are opened through the control socket
+of ddexec, which only runs the handlers registered by the other apps, unless they are
+listed in control: run_command (disable it with control: xdg_open: false in x-startup).
+
+The type of the files is detected by their names and contents, using the
+shared-mime-info database of the system. The handlers of the parent types, like
+text/plain for text/x-go, and wildcards, like image/*, are used when no handler
+is registered for the exact type.
+
+The applications in /usr/share/applications of the images are also registered
+for the mime types of their .desktop files, used when no mapping above matches
+(disable it with xdg_open_auto: false in x-startup).
 
 Exit Codes
   An exit code of 0 indicates success while a non-zero exit code indicates failure.
@@ -35,7 +43,18 @@ Exit Codes
     1 Error in command line syntax.
     2 One of the files passed on the command line did not exist.
     3 A required tool could not be found.
-    4 The action failed.`)
+    4 The action failed.`
+
+func CheckArgs() {
+	if len(os.Args) != 2 {
+		fmt.Println("Error: Expected a file or a URL as the only parameter.")
+		fmt.Println("Use `-h` or `--help` for options")
+		os.Exit(1)
+	}
+
+	if os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "--manual" {
+		// not with fmt, the field codes in it look like formatting directives
+		os.Stdout.WriteString(usage + "\n")
 	}
 
 	if os.Args[1] == "-v" || os.Args[1] == "--version" {
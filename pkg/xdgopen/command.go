@@ -0,0 +1,220 @@
+package xdgopen
+
+import (
+	"github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+	"net/url"
+	"strings"
+)
+
+// the placeholder of the file or URL in the mappings, besides the field codes of the Exec key in .desktop files
+const argPlaceholder = "<arg>"
+
+// argMarker stands for the placeholder while splitting the command, its < and > would be redirections
+const argMarker = "\x00arg\x00"
+
+// SplitCommand parses the command into its arguments with shell-like quoting, without expanding
+// variables or running anything, shell operators like ; or | are rejected as there is no shell
+func SplitCommand(command string) ([]string, error) {
+	parser := &shellwords.Parser{}
+
+	args, err := parser.Parse(strings.ReplaceAll(command, argPlaceholder, argMarker))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse: "+command)
+	} else if parser.Position >= 0 {
+		return nil, errors.New("shell operators are not supported in: " + command)
+	}
+
+	for idx, arg := range args {
+		args[idx] = strings.ReplaceAll(arg, argMarker, argPlaceholder)
+	}
+
+	return args, nil
+}
+
+// ExpandCommand splits the mapped command into its arguments, then replaces the field codes
+// and the <arg> placeholder in them with the file or URL, so that it always stays a single argument
+func ExpandCommand(command, arg string) ([]string, error) {
+	words, err := SplitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		args   []string
+		hasArg bool
+	)
+
+	for _, word := range words {
+		expanded, used := expandFieldCodes(word, arg)
+		hasArg = hasArg || used
+
+		if expanded != "" || !isOnlyFieldCodes(word) {
+			args = append(args, expanded)
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty command: " + command)
+	}
+
+	if !hasArg {
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// MatchesCommand checks whether the command is the mapped command expanded for some file or URL
+func MatchesCommand(mapping string, command []string) bool {
+	words, err := SplitCommand(mapping)
+	if err != nil {
+		return false
+	}
+
+	for _, candidate := range argCandidates(words, command) {
+		if expanded, err := ExpandCommand(mapping, candidate); err == nil && equalArgs(expanded, command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// argCandidates returns the possible values of the file or URL in the command,
+// from the arguments around the placeholders of the mapping, or the last one when appended
+func argCandidates(words, command []string) []string {
+	var candidates []string
+
+	if len(command) > 0 {
+		candidates = append(candidates, command[len(command)-1])
+	}
+
+	for _, word := range words {
+		prefix, suffix, ok := splitAtPlaceholder(word)
+		if !ok {
+			continue
+		}
+
+		prefix, _ = expandFieldCodes(prefix, "")
+		suffix, _ = expandFieldCodes(suffix, "")
+
+		for _, arg := range command {
+			if len(arg) >= len(prefix)+len(suffix) && strings.HasPrefix(arg, prefix) && strings.HasSuffix(arg, suffix) {
+				candidates = append(candidates, arg[len(prefix):len(arg)-len(suffix)])
+			}
+		}
+	}
+
+	return candidates
+}
+
+// splitAtPlaceholder returns the parts of the argument of the mapping before and after its first
+// field code or placeholder for the file or URL
+func splitAtPlaceholder(word string) (string, string, bool) {
+	for idx := 0; idx < len(word); idx++ {
+		if strings.HasPrefix(word[idx:], argPlaceholder) {
+			return word[:idx], word[idx+len(argPlaceholder):], true
+		}
+
+		if word[idx] == '%' && idx+1 < len(word) {
+			if strings.ContainsRune("fFuU", rune(word[idx+1])) {
+				return word[:idx], word[idx+2:], true
+			}
+
+			idx++ // %% and the other field codes
+		}
+	}
+
+	return "", "", false
+}
+
+func equalArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// expandFieldCodes replaces the <arg> placeholder and the field codes of the Exec key of .desktop files
+// in the argument of the mapping, the file or URL is inserted as it is, its own % characters are kept
+// https://specifications.freedesktop.org/desktop-entry-spec/latest/exec-variables.html
+func expandFieldCodes(word, arg string) (string, bool) {
+	var (
+		result strings.Builder
+		used   bool
+	)
+
+	for idx := 0; idx < len(word); idx++ {
+		if strings.HasPrefix(word[idx:], argPlaceholder) {
+			result.WriteString(arg)
+			used = true
+			idx += len(argPlaceholder) - 1
+			continue
+		}
+
+		if word[idx] != '%' || idx+1 >= len(word) {
+			result.WriteByte(word[idx])
+			continue
+		}
+
+		idx++
+
+		switch word[idx] {
+		case '%':
+			result.WriteByte('%')
+		case 'f', 'F':
+			result.WriteString(toLocalPath(arg))
+			used = true
+		case 'u', 'U':
+			result.WriteString(arg)
+			used = true
+		case 'i', 'c', 'k', 'd', 'D', 'n', 'N', 'v', 'm':
+			// %i and %c are expanded at registration, %k has no meaning here, the others are deprecated
+		default:
+			result.WriteByte('%') // not a field code, kept as it is
+			result.WriteByte(word[idx])
+		}
+	}
+
+	return result.String(), used
+}
+
+// isOnlyFieldCodes checks whether the argument consists of field codes only,
+// which are removed when they expand to nothing
+func isOnlyFieldCodes(word string) bool {
+	if word == "" {
+		return false
+	}
+
+	for idx := 0; idx < len(word); idx += 2 {
+		if word[idx] != '%' || idx+1 >= len(word) || !strings.ContainsRune("fFuUickdDnNvm", rune(word[idx+1])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toLocalPath converts file:// URLs for the applications that expect file names
+func toLocalPath(arg string) string {
+	if strings.HasPrefix(arg, "file://") {
+		if parsed, err := url.Parse(arg); err == nil && (parsed.Host == "" || parsed.Host == "localhost") {
+			return parsed.Path
+		}
+	}
+
+	return arg
+}
+
+// quoteArgument quotes the value so that SplitCommand returns it as a single argument
+func quoteArgument(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,99 @@
+package xdgopen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	for command, expected := range map[string][]string{
+		"viewer --new":                     {"viewer", "--new"},
+		"  viewer\t--new  ":                {"viewer", "--new"},
+		`viewer "two words" 'it''s'`:       {"viewer", "two words", "its"},
+		`viewer "a \"b\" \$c" e\ f`:        {"viewer", `a "b" $c`, "e f"},
+		`viewer '$HOME; rm -rf' "$(id)"`:   {"viewer", "$HOME; rm -rf", "$(id)"},
+		`viewer --title="My Viewer" <arg>`: {"viewer", "--title=My Viewer", "<arg>"},
+		`viewer '<arg>' a<arg>b`:           {"viewer", "<arg>", "a<arg>b"},
+	} {
+		args, err := SplitCommand(command)
+		if err != nil {
+			t.Errorf("failed to split %s: %s", command, err)
+		} else if !reflect.DeepEqual(args, expected) {
+			t.Errorf("unexpected arguments for %s: %q", command, args)
+		}
+	}
+
+	for _, command := range []string{`viewer "open`, `viewer 'open`, `viewer \`, `viewer; id`, `viewer | id`, `viewer > x`} {
+		if _, err := SplitCommand(command); err == nil {
+			t.Errorf("expected an error for %s", command)
+		}
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	const url = "https://example.com/a b?q='x';$(id)&r=\"1\""
+
+	for command, expected := range map[string][]string{
+		"browser <arg>":              {"browser", url},
+		"browser %U":                 {"browser", url},
+		"browser --url=%u":           {"browser", "--url=" + url},
+		"browser":                    {"browser", url},
+		"browser %i %k --new %u":     {"browser", "--new", url},
+		"browser 'Browser' 100%% %u": {"browser", "Browser", "100%", url},
+	} {
+		args, err := ExpandCommand(command, url)
+		if err != nil {
+			t.Errorf("failed to expand %s: %s", command, err)
+		} else if !reflect.DeepEqual(args, expected) {
+			t.Errorf("unexpected arguments for %s: %q", command, args)
+		}
+	}
+
+	// the % characters of the URL are not field codes
+	const encoded = "https://example.com/a%20b/%F0%9F%98%80?q=%u&f=%f%%"
+
+	for command, expected := range map[string][]string{
+		"browser <arg>":      {"browser", encoded},
+		"browser %u":         {"browser", encoded},
+		"browser --url=%U":   {"browser", "--url=" + encoded},
+		"browser %z <arg>":   {"browser", "%z", encoded},
+		"browser <arg><arg>": {"browser", encoded + encoded},
+	} {
+		args, err := ExpandCommand(command, encoded)
+		if err != nil {
+			t.Errorf("failed to expand %s: %s", command, err)
+		} else if !reflect.DeepEqual(args, expected) {
+			t.Errorf("unexpected arguments for %s: %q", command, args)
+		}
+	}
+
+	args, err := ExpandCommand("editor %f", "file:///tmp/some%20file.txt")
+	if err != nil || !reflect.DeepEqual(args, []string{"editor", "/tmp/some file.txt"}) {
+		t.Errorf("unexpected arguments for a file URL: %q (%v)", args, err)
+	}
+
+	if _, err := ExpandCommand("%i", url); err == nil {
+		t.Error("expected an error for an empty command")
+	}
+}
+
+func TestMatchesCommand(t *testing.T) {
+	for _, tc := range []struct {
+		mapping  string
+		command  []string
+		expected bool
+	}{
+		{"vim <arg>", []string{"vim", "/tmp/a b.txt"}, true},
+		{"vim", []string{"vim", "/tmp/a.txt"}, true},
+		{"chrome --user-data-dir=/data %u", []string{"chrome", "--user-data-dir=/data", "https://example.com"}, true},
+		{"browser --url=%U --new", []string{"browser", "--url=https://example.com", "--new"}, true},
+		{"viewer --icon 'viewer' %f", []string{"viewer", "--icon", "viewer", "/tmp/image.png"}, true},
+		{"vim <arg>", []string{"vim", "-c", "!rm -rf ~", "/tmp/a.txt"}, false},
+		{"vim <arg>", []string{"sh", "-c", "id"}, false},
+		{"browser --url=%U", []string{"browser", "--url=https://example.com", "--other"}, false},
+	} {
+		if actual := MatchesCommand(tc.mapping, tc.command); actual != tc.expected {
+			t.Errorf("unexpected match of %q for %s: %v", tc.command, tc.mapping, actual)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package xdgopen
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DesktopEntry holds the keys of a .desktop file we need to open files with the application
+// https://specifications.freedesktop.org/desktop-entry-spec/latest/
+type DesktopEntry struct {
+	Type      string
+	Name      string
+	Exec      string
+	Icon      string
+	MimeTypes []string
+	NoDisplay bool
+	Hidden    bool
+}
+
+// ParseDesktopEntry reads the [Desktop Entry] group of a .desktop file
+func ParseDesktopEntry(r io.Reader) (*DesktopEntry, error) {
+	entry := &DesktopEntry{}
+	inGroup := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			inGroup = line == "[Desktop Entry]"
+			continue
+		}
+
+		if !inGroup {
+			continue // actions and other groups
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key, value := strings.TrimSpace(parts[0]), unescapeDesktopValue(strings.TrimSpace(parts[1]))
+
+		switch key {
+		case "Type":
+			entry.Type = value
+		case "Name":
+			entry.Name = value
+		case "Exec":
+			entry.Exec = value
+		case "Icon":
+			entry.Icon = value
+		case "MimeType":
+			for _, mimetype := range strings.Split(value, ";") {
+				if mimetype = strings.TrimSpace(mimetype); mimetype != "" {
+					entry.MimeTypes = append(entry.MimeTypes, mimetype)
+				}
+			}
+		case "NoDisplay":
+			entry.NoDisplay = value == "true"
+		case "Hidden":
+			entry.Hidden = value == "true"
+		}
+	}
+
+	return entry, scanner.Err()
+}
+
+// unescapeDesktopValue replaces the escape sequences of string values
+func unescapeDesktopValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	return strings.NewReplacer(`\s`, " ", `\n`, "\n", `\t`, "\t", `\r`, "\r", `\\`, `\`).Replace(value)
+}
+
+// DesktopMappings returns the xdg-open mappings of the applications for their mime types,
+// the entries are keyed by their filename, the first one in order wins for each type
+func DesktopMappings(entries map[string]*DesktopEntry) map[string]string {
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mappings := map[string]string{}
+
+	for _, name := range names {
+		entry := entries[name]
+
+		if entry.Hidden || entry.Exec == "" || (entry.Type != "" && entry.Type != "Application") {
+			continue
+		}
+
+		command := expandEntryFieldCodes(entry.Exec, entry)
+
+		for _, mimetype := range entry.MimeTypes {
+			if _, ok := mappings[mimetype]; !ok {
+				mappings[mimetype] = command
+			}
+		}
+	}
+
+	return mappings
+}
+
+// expandEntryFieldCodes replaces the %i and %c field codes with the icon and the name of the application,
+// the others are expanded when the file or URL is opened
+func expandEntryFieldCodes(exec string, entry *DesktopEntry) string {
+	var command strings.Builder
+
+	for idx := 0; idx < len(exec); idx++ {
+		if exec[idx] != '%' || idx+1 >= len(exec) {
+			command.WriteByte(exec[idx])
+			continue
+		}
+
+		idx++
+
+		if exec[idx] == 'i' {
+			if entry.Icon != "" {
+				command.WriteString("--icon " + quoteArgument(entry.Icon))
+			}
+		} else if exec[idx] == 'c' {
+			if entry.Name != "" {
+				command.WriteString(quoteArgument(entry.Name))
+			}
+		} else {
+			command.WriteByte('%')
+			command.WriteByte(exec[idx])
+		}
+	}
+
+	return command.String()
+}
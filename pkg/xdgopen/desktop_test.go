@@ -0,0 +1,59 @@
+package xdgopen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const chromeDesktopEntry = `[Desktop Entry]
+Version=1.0
+Name=Google Chrome
+Name[de]=Google Chrome
+# a comment
+Exec=/usr/bin/google-chrome-stable %U
+Icon=google-chrome
+Type=Application
+MimeType=text/html;x-scheme-handler/http;x-scheme-handler/https;
+
+[Desktop Action new-window]
+Name=New Window
+Exec=/usr/bin/google-chrome-stable --new-window
+`
+
+func TestParseDesktopEntry(t *testing.T) {
+	entry, err := ParseDesktopEntry(strings.NewReader(chromeDesktopEntry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &DesktopEntry{
+		Type:      "Application",
+		Name:      "Google Chrome",
+		Exec:      "/usr/bin/google-chrome-stable %U",
+		Icon:      "google-chrome",
+		MimeTypes: []string{"text/html", "x-scheme-handler/http", "x-scheme-handler/https"},
+	}
+
+	if !reflect.DeepEqual(entry, expected) {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestDesktopMappings(t *testing.T) {
+	mappings := DesktopMappings(map[string]*DesktopEntry{
+		"b-editor.desktop": {Exec: "editor --new %f -x", MimeTypes: []string{"text/plain", "text/x-go"}},
+		"a-viewer.desktop": {Name: "Viewer", Icon: "viewer", Exec: "viewer %i %c 100%%", MimeTypes: []string{"text/plain"}},
+		"hidden.desktop":   {Exec: "hidden %u", MimeTypes: []string{"image/png"}, Hidden: true},
+		"link.desktop":     {Type: "Link", Exec: "link %u", MimeTypes: []string{"image/jpeg"}},
+	})
+
+	expected := map[string]string{
+		"text/plain": "viewer --icon 'viewer' 'Viewer' 100%%",
+		"text/x-go":  "editor --new %f -x",
+	}
+
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Errorf("unexpected mappings: %+v", mappings)
+	}
+}
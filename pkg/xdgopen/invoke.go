@@ -1,14 +1,13 @@
 package xdgopen
 
 import (
+	"context"
 	"fmt"
 	"github.com/rycus86/ddexec/pkg/control"
 	"github.com/rycus86/ddexec/pkg/xdgexec"
 	"io/ioutil"
-	"mime"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 )
@@ -22,7 +21,7 @@ func Invoke(arg string) int {
 			return 1 // Error in command line syntax.
 		}
 
-		return dispatch("x-scheme-handler/"+parsed.Scheme, arg)
+		return dispatch(LoadMimeDatabase(), "x-scheme-handler/"+parsed.Scheme, arg)
 
 	} else {
 		if _, err := os.Stat(arg); err != nil && os.IsNotExist(err) {
@@ -30,9 +29,12 @@ func Invoke(arg string) int {
 			return 2 // One of the files passed on the command line did not exist.
 		}
 
-		mimetype := mime.TypeByExtension(path.Ext(arg))
-		if strings.Contains(mimetype, ";") {
-			mimetype = strings.Split(mimetype, ";")[0]
+		db := LoadMimeDatabase()
+
+		mimetype, err := db.DetectFile(arg)
+		if err != nil {
+			fmt.Println("Failed to read", arg, "-", err)
+			return 4 // The action failed.
 		}
 
 		if mimetype == "" {
@@ -40,53 +42,72 @@ func Invoke(arg string) int {
 			return 4 // The action failed.
 		}
 
-		return dispatch(mimetype, arg)
+		return dispatch(db, mimetype, arg)
 	}
 }
 
-func dispatch(mimetype string, arg string) int {
-	files, err := filepath.Glob(filepath.Join(GetMappingDirectory(), "xdg_open.*"))
-	if err != nil {
-		fmt.Println("Failed to read xdg-open mappings:", err)
-		return 4 // The action failed.
-	}
-
+func dispatch(db *MimeDatabase, mimetype string, arg string) int {
 	var targetContainer, mappedCommand string
 
-	for _, filename := range files {
-		if contents, err := ioutil.ReadFile(filename); err != nil {
-			continue // Failed to read file - TODO maybe log it
-		} else {
-			for _, line := range strings.Split(string(contents), "\n") {
-				if strings.HasPrefix(line, mimetype+"=") {
-					mappedCommand = strings.SplitN(line, "=", 2)[1]
-					targetContainer = strings.Replace(filepath.Base(filename), "xdg_open.", "", 1)
-					break
-				}
+	// the mappings found in .desktop files are only used when none of the explicit ones match,
+	// the handlers of the parent types and the wildcards can open the more specific types too
+	for _, prefix := range []string{mappingPrefix, autoMappingPrefix} {
+		files, err := filepath.Glob(filepath.Join(GetMappingDirectory(), prefix+"*"))
+		if err != nil {
+			fmt.Println("Failed to read xdg-open mappings:", err)
+			return 4 // The action failed.
+		}
+
+		for _, candidate := range db.Candidates(mimetype) {
+			targetContainer, mappedCommand = findMapping(files, prefix, candidate)
+			if targetContainer != "" {
+				break
 			}
 		}
 
-		if mappedCommand != "" {
+		if targetContainer != "" {
 			break
 		}
 	}
 
 	if targetContainer != "" {
-		finalCommand := strings.Replace(mappedCommand, "<arg>", arg, -1)
+		finalCommand, err := ExpandCommand(mappedCommand, arg)
+		if err != nil {
+			fmt.Println("Invalid xdg-open mapping for", mimetype, "in", targetContainer+":", err)
+			return 4 // The action failed.
+		}
 
-		if ok, err := xdgexec.ExecInContainer(targetContainer, finalCommand); err != nil {
-			if ok, err := control.RunCommand(targetContainer, finalCommand); err == nil && ok {
-				return 0 // Successful.
-			} else {
-				return 4 // The action failed.
-			}
-		} else if ok {
-			return 0 // Successful.
-		} else {
+		exitCode, err := xdgexec.ExecInContainer(context.Background(), targetContainer, finalCommand, os.Stdout, os.Stderr)
+		if err != nil {
+			exitCode, err = control.Exec(targetContainer, finalCommand, os.Stdout, os.Stderr)
+		}
+
+		if err != nil {
+			fmt.Println("Failed to open", arg, "in", targetContainer+":", err)
+			return 4 // The action failed.
+		} else if exitCode != 0 {
 			return 4 // The action failed.
+		} else {
+			return 0 // Successful.
 		}
 	}
 
 	fmt.Printf("No tool found for %s (type: %s)\n", arg, mimetype)
 	return 3 // A required tool could not be found.
 }
+
+func findMapping(files []string, prefix, mimetype string) (string, string) {
+	for _, filename := range files {
+		if contents, err := ioutil.ReadFile(filename); err != nil {
+			continue // Failed to read file - TODO maybe log it
+		} else {
+			for _, line := range strings.Split(string(contents), "\n") {
+				if strings.HasPrefix(line, mimetype+"=") {
+					return strings.TrimPrefix(filepath.Base(filename), prefix), strings.SplitN(line, "=", 2)[1]
+				}
+			}
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,574 @@
+package xdgopen
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the most we read from the files to check their contents
+const maxSniffLength = 1024 * 1024
+
+// MimeDatabase holds the shared-mime-info data to detect the type of files
+// https://specifications.freedesktop.org/shared-mime-info-spec/latest/
+type MimeDatabase struct {
+	globs   []mimeGlob
+	magic   []magicEntry
+	parents map[string][]string
+	aliases map[string]string
+}
+
+type mimeGlob struct {
+	weight        int
+	mimetype      string
+	pattern       string
+	caseSensitive bool
+}
+
+type magicEntry struct {
+	priority int
+	mimetype string
+	rules    []magicMatch
+}
+
+type magicMatch struct {
+	indent      int
+	offset      int
+	rangeLength int
+	value       []byte
+	mask        []byte
+}
+
+// mimeDirectories returns the mime directories of the XDG data directories, the most important first
+func mimeDirectories() []string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	var directories []string
+	for _, dir := range append([]string{dataHome}, strings.Split(dataDirs, ":")...) {
+		if dir != "" {
+			directories = append(directories, filepath.Join(dir, "mime"))
+		}
+	}
+
+	return directories
+}
+
+// LoadMimeDatabase reads the shared-mime-info data of the XDG data directories,
+// the files missing from them are ignored
+func LoadMimeDatabase() *MimeDatabase {
+	db := newMimeDatabase()
+
+	for _, dir := range mimeDirectories() {
+		db.load(dir)
+	}
+
+	return db
+}
+
+func newMimeDatabase() *MimeDatabase {
+	return &MimeDatabase{
+		parents: map[string][]string{},
+		aliases: map[string]string{},
+	}
+}
+
+// load adds the data of the mime directory, the entries loaded earlier take precedence
+func (db *MimeDatabase) load(dir string) {
+	readLines(filepath.Join(dir, "globs2"), func(fields []string) {
+		if len(fields) < 3 || fields[2] == "__NOGLOBS__" {
+			return
+		}
+
+		weight, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return
+		}
+
+		db.globs = append(db.globs, mimeGlob{
+			weight:        weight,
+			mimetype:      fields[1],
+			pattern:       fields[2],
+			caseSensitive: len(fields) > 3 && strings.Contains(fields[3], "cs"),
+		})
+	}, ":")
+
+	readLines(filepath.Join(dir, "subclasses"), func(fields []string) {
+		if len(fields) == 2 {
+			db.parents[fields[0]] = append(db.parents[fields[0]], fields[1])
+		}
+	}, " ")
+
+	readLines(filepath.Join(dir, "aliases"), func(fields []string) {
+		if _, ok := db.aliases[fields[0]]; len(fields) == 2 && !ok {
+			db.aliases[fields[0]] = fields[1]
+		}
+	}, " ")
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "magic")); err == nil {
+		db.magic = append(db.magic, parseMagic(data)...)
+	}
+}
+
+// readLines calls the function with the separated fields of the lines of the file, except the comments
+func readLines(filename string, process func(fields []string), separator string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		process(strings.Split(line, separator))
+	}
+}
+
+// parseMagic reads the rules of the binary magic file, stopping at the first invalid one
+func parseMagic(data []byte) []magicEntry {
+	const header = "MIME-Magic\x00\n"
+
+	if !bytes.HasPrefix(data, []byte(header)) {
+		return nil
+	}
+
+	var (
+		entries []magicEntry
+		current *magicEntry
+	)
+
+	for pos := len(header); pos < len(data); {
+		if data[pos] == '[' {
+			end := bytes.IndexByte(data[pos:], '\n')
+			if end < 0 {
+				break
+			}
+
+			section := strings.TrimSuffix(strings.TrimPrefix(string(data[pos:pos+end]), "["), "]")
+			pos += end + 1
+
+			parts := strings.SplitN(section, ":", 2)
+			if len(parts) != 2 {
+				break
+			}
+
+			priority, _ := strconv.Atoi(parts[0])
+			entries = append(entries, magicEntry{priority: priority, mimetype: parts[1]})
+			current = &entries[len(entries)-1]
+			continue
+		}
+
+		match, next, ok := parseMagicMatch(data, pos)
+		if !ok || current == nil {
+			break
+		}
+
+		current.rules = append(current.rules, match)
+		pos = next
+	}
+
+	return entries
+}
+
+// parseMagicMatch reads a line of the format [indent]>start-offset=value-length value[&mask][~word-size][+range-length]
+func parseMagicMatch(data []byte, pos int) (magicMatch, int, bool) {
+	match := magicMatch{rangeLength: 1}
+
+	readNumber := func() int {
+		start := pos
+		for pos < len(data) && data[pos] >= '0' && data[pos] <= '9' {
+			pos++
+		}
+		number, _ := strconv.Atoi(string(data[start:pos]))
+		return number
+	}
+
+	match.indent = readNumber()
+	if pos >= len(data) || data[pos] != '>' {
+		return match, pos, false
+	}
+	pos++
+
+	match.offset = readNumber()
+	if pos+3 > len(data) || data[pos] != '=' {
+		return match, pos, false
+	}
+
+	length := int(binary.BigEndian.Uint16(data[pos+1 : pos+3]))
+	pos += 3
+
+	if pos+length > len(data) {
+		return match, pos, false
+	}
+	match.value = data[pos : pos+length]
+	pos += length
+
+	wordSize := 1
+
+	for pos < len(data) && data[pos] != '\n' {
+		switch data[pos] {
+		case '&':
+			if pos+1+length > len(data) {
+				return match, pos, false
+			}
+			match.mask = data[pos+1 : pos+1+length]
+			pos += 1 + length
+		case '~':
+			pos++
+			wordSize = readNumber()
+		case '+':
+			pos++
+			match.rangeLength = readNumber()
+		default:
+			return match, pos, false
+		}
+	}
+
+	if wordSize > 1 {
+		match.value = swapWords(match.value, wordSize)
+		if match.mask != nil {
+			match.mask = swapWords(match.mask, wordSize)
+		}
+	}
+
+	return match, pos + 1, true
+}
+
+// swapWords converts the big endian words of the value to little endian, the rules with a word size
+// are relative to the byte order of the host, and ddexec runs on little endian ones (amd64 and arm64)
+func swapWords(value []byte, wordSize int) []byte {
+	if len(value)%wordSize != 0 {
+		return value
+	}
+
+	swapped := make([]byte, len(value))
+
+	for idx := 0; idx < len(value); idx += wordSize {
+		switch wordSize {
+		case 2:
+			binary.LittleEndian.PutUint16(swapped[idx:], binary.BigEndian.Uint16(value[idx:]))
+		case 4:
+			binary.LittleEndian.PutUint32(swapped[idx:], binary.BigEndian.Uint32(value[idx:]))
+		default:
+			return value // not a word size of the specification
+		}
+	}
+
+	return swapped
+}
+
+// matches checks the contents at the offsets of the rule
+func (m *magicMatch) matches(data []byte) bool {
+	for offset := m.offset; offset < m.offset+m.rangeLength; offset++ {
+		if offset+len(m.value) > len(data) {
+			return false
+		}
+
+		found := true
+
+		for idx, expected := range m.value {
+			actual := data[offset+idx]
+			if m.mask != nil {
+				actual &= m.mask[idx]
+				expected &= m.mask[idx]
+			}
+
+			if actual != expected {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches checks the rules of the entry, the nested ones have to match as well
+func (e *magicEntry) matches(data []byte) bool {
+	return matchRules(e.rules, 0, data)
+}
+
+// matchRules checks whether any of the rules at the indentation level match, together with one of their nested rules
+func matchRules(rules []magicMatch, indent int, data []byte) bool {
+	for idx := 0; idx < len(rules); idx++ {
+		if rules[idx].indent != indent {
+			continue
+		}
+
+		end := idx + 1
+		for end < len(rules) && rules[end].indent > indent {
+			end++
+		}
+
+		if rules[idx].matches(data) && (end == idx+1 || matchRules(rules[idx+1:end], indent+1, data)) {
+			return true
+		}
+
+		idx = end - 1
+	}
+
+	return false
+}
+
+// magicLength returns how much of the contents of the files the magic rules look at
+func (db *MimeDatabase) magicLength() int {
+	length := 512 // what http.DetectContentType needs
+
+	for _, entry := range db.magic {
+		for _, match := range entry.rules {
+			if end := match.offset + match.rangeLength + len(match.value); end > length {
+				length = end
+			}
+		}
+	}
+
+	if length > maxSniffLength {
+		return maxSniffLength
+	}
+
+	return length
+}
+
+// MatchGlob returns the type for the file name, the literal patterns come first,
+// then the ones with the highest weight and the longest pattern
+func (db *MimeDatabase) MatchGlob(filename string) string {
+	if matches := db.matchGlobs(filename); len(matches) > 0 {
+		return matches[0]
+	}
+
+	return ""
+}
+
+// matchGlobs returns the types of the best matching patterns for the file name,
+// more than one when the name is ambiguous
+func (db *MimeDatabase) matchGlobs(filename string) []string {
+	var (
+		best    *mimeGlob
+		exact   bool
+		matches []string
+	)
+
+	for idx := range db.globs {
+		glob := &db.globs[idx]
+
+		name, pattern := filename, glob.pattern
+		if !glob.caseSensitive {
+			name, pattern = strings.ToLower(name), strings.ToLower(pattern)
+		}
+
+		if matched, err := path.Match(pattern, name); err != nil || !matched {
+			continue
+		}
+
+		literal := !strings.ContainsAny(pattern, "*?[")
+
+		switch {
+		case best == nil,
+			literal && !exact,
+			literal == exact && glob.weight > best.weight,
+			literal == exact && glob.weight == best.weight && len(glob.pattern) > len(best.pattern):
+
+			best, exact = glob, literal
+			matches = []string{glob.mimetype}
+
+		case literal == exact && glob.weight == best.weight && len(glob.pattern) == len(best.pattern):
+			if !containsString(matches, glob.mimetype) {
+				matches = append(matches, glob.mimetype)
+			}
+		}
+	}
+
+	return matches
+}
+
+// MatchMagic returns the type of the contents, the rules with the highest priority win
+func (db *MimeDatabase) MatchMagic(data []byte) string {
+	var best *magicEntry
+
+	for idx := range db.magic {
+		entry := &db.magic[idx]
+
+		if (best == nil || entry.priority > best.priority) && entry.matches(data) {
+			best = entry
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return best.mimetype
+}
+
+// Canonical returns the type the alias stands for
+func (db *MimeDatabase) Canonical(mimetype string) string {
+	if canonical, ok := db.aliases[mimetype]; ok {
+		return canonical
+	}
+
+	return mimetype
+}
+
+// Parents returns the types the type is a subclass of,
+// all text files are also plain text, even when the database does not say so
+func (db *MimeDatabase) Parents(mimetype string) []string {
+	mimetype = db.Canonical(mimetype)
+
+	parents := db.parents[mimetype]
+
+	if strings.HasPrefix(mimetype, "text/") && mimetype != "text/plain" && !containsString(parents, "text/plain") {
+		parents = append(parents[:len(parents):len(parents)], "text/plain")
+	}
+
+	return parents
+}
+
+// Ancestors returns the type, and the types it is a subclass of, the closest ones first
+func (db *MimeDatabase) Ancestors(mimetype string) []string {
+	ancestors := []string{mimetype}
+	if canonical := db.Canonical(mimetype); canonical != mimetype {
+		ancestors = append(ancestors, canonical)
+	}
+
+	for idx := 0; idx < len(ancestors); idx++ {
+		for _, parent := range db.Parents(ancestors[idx]) {
+			if !containsString(ancestors, parent) {
+				ancestors = append(ancestors, parent)
+			}
+		}
+	}
+
+	return ancestors
+}
+
+// IsSubclassOf checks whether the type is the same as the parent, or a subclass of it
+func (db *MimeDatabase) IsSubclassOf(mimetype, parent string) bool {
+	return containsString(db.Ancestors(mimetype), db.Canonical(parent))
+}
+
+// Candidates returns the types to look up the xdg-open mappings for: the type and the types
+// it is a subclass of, the closest ones first, each media type followed by its wildcard, like image/*
+func (db *MimeDatabase) Candidates(mimetype string) []string {
+	ancestors := db.Ancestors(mimetype)
+
+	last := map[string]int{}
+	for idx, ancestor := range ancestors {
+		last[mediaType(ancestor)] = idx
+	}
+
+	var candidates []string
+	for idx, ancestor := range ancestors {
+		candidates = append(candidates, ancestor)
+
+		if media := mediaType(ancestor); last[media] == idx && ancestor != media+"/*" {
+			candidates = append(candidates, media+"/*")
+		}
+	}
+
+	return candidates
+}
+
+func mediaType(mimetype string) string {
+	return strings.SplitN(mimetype, "/", 2)[0]
+}
+
+// DetectFile returns the type of the file by its name, or by its contents
+// when the name does not tell or it is ambiguous
+func (db *MimeDatabase) DetectFile(filename string) (string, error) {
+	if fi, err := os.Stat(filename); err != nil {
+		return "", err
+	} else if fi.IsDir() {
+		return "inode/directory", nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, int64(db.magicLength())))
+	if err != nil {
+		return "", err
+	}
+
+	return db.Detect(filepath.Base(filename), data), nil
+}
+
+// Detect returns the type of the file by its name and the beginning of its contents,
+// following the recommended checking order of the specification: the name wins when it matches one type,
+// the magic rules choose between the types of an ambiguous name, or tell the type of the files without one
+func (db *MimeDatabase) Detect(filename string, data []byte) string {
+	byName := db.matchGlobs(filename)
+	if len(byName) == 0 {
+		if byExtension := withoutParameters(mime.TypeByExtension(path.Ext(filename))); byExtension != "" {
+			byName = []string{byExtension}
+		}
+	}
+
+	if len(byName) == 1 {
+		return byName[0]
+	}
+
+	byContents := db.MatchMagic(data)
+
+	if len(byName) > 1 {
+		for _, candidate := range byName {
+			if byContents != "" && db.IsSubclassOf(candidate, byContents) {
+				return candidate
+			}
+		}
+
+		return byName[0]
+	}
+
+	if byContents == "" && len(data) > 0 {
+		// only for the files without a name to go by
+		byContents = withoutParameters(http.DetectContentType(data))
+	}
+
+	return byContents
+}
+
+func withoutParameters(mimetype string) string {
+	if idx := strings.Index(mimetype, ";"); idx >= 0 {
+		mimetype = mimetype[:idx]
+	}
+
+	return strings.TrimSpace(mimetype)
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package xdgopen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testGlobs = `# comment
+50:text/x-go:*.go
+50:text/x-makefile:makefile
+50:text/x-makefile:*.mk
+50:image/png:*.png
+50:image/svg+xml:*.svg
+50:application/gzip:*.gz
+50:application/x-compressed-tar:*.tar.gz
+50:text/x-readme:README:cs
+10:text/x-readme:readme*
+50:text/x-data:*.dat
+50:image/png:*.dat
+`
+
+const testSubclasses = `text/x-go text/plain
+image/svg+xml application/xml
+application/xml text/plain
+application/x-compressed-tar application/gzip
+`
+
+const testAliases = `text/xml application/xml
+`
+
+// magicRule encodes a line of the magic file
+func magicRule(indent, offset string, value, suffix string) string {
+	return indent + ">" + offset + "=" + string([]byte{byte(len(value) >> 8), byte(len(value))}) + value + suffix + "\n"
+}
+
+func testMimeDatabase(t *testing.T) *MimeDatabase {
+	dir, err := ioutil.TempDir("", "ddexec-mime-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	magic := "MIME-Magic\x00\n" +
+		"[50:image/png]\n" +
+		magicRule("", "0", "\x89PNG", "") +
+		"[50:application/gzip]\n" +
+		magicRule("", "0", "\x1f\x8b", "") +
+		"[60:application/xml]\n" +
+		magicRule("", "0", "<?xml", "") +
+		"[70:application/x-test]\n" +
+		magicRule("", "0", "TEST", "") +
+		magicRule("1", "4", "\x00\x01", "~2") + // a 16-bit word, swapped to little endian
+		magicRule("1", "4", "v", "+8") +
+		"[40:application/x-masked]\n" +
+		magicRule("", "0", "\xf0", "&\xf0")
+
+	for name, contents := range map[string]string{
+		"globs2":     testGlobs,
+		"subclasses": testSubclasses,
+		"aliases":    testAliases,
+		"magic":      magic,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := newMimeDatabase()
+	db.load(dir)
+
+	return db
+}
+
+func TestMatchGlob(t *testing.T) {
+	db := testMimeDatabase(t)
+
+	for filename, expected := range map[string]string{
+		"main.go":     "text/x-go",
+		"MAIN.GO":     "text/x-go",
+		"Makefile":    "text/x-makefile",
+		"a.tar.gz":    "application/x-compressed-tar",
+		"a.gz":        "application/gzip",
+		"README":      "text/x-readme",
+		"readme.md":   "text/x-readme",
+		"unknown.xyz": "",
+		"a.dat":       "text/x-data", // the first of the ambiguous ones
+	} {
+		if mimetype := db.MatchGlob(filename); mimetype != expected {
+			t.Errorf("unexpected type for %s: %s", filename, mimetype)
+		}
+	}
+}
+
+func TestMatchMagic(t *testing.T) {
+	db := testMimeDatabase(t)
+
+	word := "\x01\x00" // little endian
+
+	for data, expected := range map[string]string{
+		"\x89PNG\r\n":        "image/png",
+		"TEST" + word:        "application/x-test",
+		"TEST" + "..":        "", // the nested rules have to match too
+		"TEST" + "...v":      "application/x-test",
+		"\xf3\x00":           "application/x-masked",
+		"<?xml version=1.0?": "application/xml",
+		"plain text":         "",
+	} {
+		if mimetype := db.MatchMagic([]byte(data)); mimetype != expected {
+			t.Errorf("unexpected type for %q: %s", data, mimetype)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	db := testMimeDatabase(t)
+
+	for _, test := range []struct {
+		filename, data, expected string
+	}{
+		{"main.go", "package main\n", "text/x-go"},
+		{"image", "\x89PNG\r\n", "image/png"},                      // no extension
+		{"image.go", "\x89PNG\r\n", "text/x-go"},                   // the name wins
+		{"image.svg", "<?xml version=1.0?>", "image/svg+xml"},      // the name is more specific
+		{"image.dat", "\x89PNG\r\n", "image/png"},                  // the contents tell the ambiguous name
+		{"notes.dat", "some notes\n", "text/x-data"},               // the first type of the ambiguous name
+		{"notes.dat", "<html><body></body></html>", "text/x-data"}, // not sniffed with a name
+		{"notes", "some notes\n", "text/plain"},
+		{"page", "<html><body></body></html>", "text/html"},
+		{"data", "\x00\x01\x02", "application/octet-stream"},
+		{"empty", "", ""},
+	} {
+		if mimetype := db.Detect(test.filename, []byte(test.data)); mimetype != test.expected {
+			t.Errorf("unexpected type for %s: %s", test.filename, mimetype)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	db := testMimeDatabase(t)
+
+	for mimetype, expected := range map[string][]string{
+		"text/x-go":                    {"text/x-go", "text/plain", "text/*"},
+		"image/svg+xml":                {"image/svg+xml", "image/*", "application/xml", "application/*", "text/plain", "text/*"},
+		"text/xml":                     {"text/xml", "application/xml", "application/*", "text/plain", "text/*"},
+		"application/x-compressed-tar": {"application/x-compressed-tar", "application/gzip", "application/*"},
+		"x-scheme-handler/https":       {"x-scheme-handler/https", "x-scheme-handler/*"},
+	} {
+		if candidates := db.Candidates(mimetype); !reflect.DeepEqual(candidates, expected) {
+			t.Errorf("unexpected candidates for %s: %q", mimetype, candidates)
+		}
+	}
+}
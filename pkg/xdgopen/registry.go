@@ -4,23 +4,46 @@ import (
 	"fmt"
 	"github.com/rycus86/ddexec/pkg/config"
 	"github.com/rycus86/ddexec/pkg/debug"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+)
+
+const (
+	mappingPrefix     = "xdg_open."
+	autoMappingPrefix = "xdg_open_auto." // found in .desktop files, used when no explicit mapping matches
 )
 
 func Register(containerId string, sc *config.StartupConfiguration) {
-	if len(sc.XdgOpenMappings) == 0 {
+	writeMappings(filepath.Join(GetMappingDirectory(), mappingPrefix+containerId), sc.XdgOpenMappings)
+
+	// explicit mappings take precedence over the ones found in the container
+	auto := map[string]string{}
+	for key, value := range sc.XdgOpenAutoMappings {
+		if _, ok := sc.XdgOpenMappings[key]; !ok {
+			auto[key] = value
+		}
+	}
+
+	writeMappings(filepath.Join(GetMappingDirectory(), autoMappingPrefix+containerId), auto)
+}
+
+func writeMappings(filename string, mappings map[string]string) {
+	if len(mappings) == 0 {
 		return
 	}
 
-	f, err := os.Create(filepath.Join(GetMappingDirectory(), "xdg_open."+containerId))
-	if err != nil && debug.IsEnabled() {
-		fmt.Println("Failed to register xdg-open mappings for", containerId, ":", err)
+	f, err := os.Create(filename)
+	if err != nil {
+		if debug.IsEnabled() {
+			fmt.Println("Failed to register xdg-open mappings in", filename, ":", err)
+		}
 		return
 	}
 	defer f.Close()
 
-	for key, value := range sc.XdgOpenMappings {
+	for key, value := range mappings {
 		f.WriteString(key + "=" + value + "\n")
 
 		if debug.IsEnabled() {
@@ -30,19 +53,24 @@ func Register(containerId string, sc *config.StartupConfiguration) {
 }
 
 func Clear(containerId string) {
-	os.Remove(filepath.Join(GetMappingDirectory(), "xdg_open."+containerId))
+	os.Remove(filepath.Join(GetMappingDirectory(), mappingPrefix+containerId))
+	os.Remove(filepath.Join(GetMappingDirectory(), autoMappingPrefix+containerId))
 }
 
-/*
-
-TODO register default handlers found in the container
-
-https://github.com/go-ini/ini
-
-/usr/share/applications/google-chrome.desktop:MimeType=text/html;text/xml;application/xhtml_xml;image/webp;x-scheme-handler/http;x-scheme-handler/https;x-scheme-handler/ftp;
-Exec=/usr/bin/google-chrome-stable %U
+// IsHandler checks whether the command runs one of the xdg-open handlers registered for the container
+func IsHandler(containerId string, command []string) bool {
+	for _, prefix := range []string{mappingPrefix, autoMappingPrefix} {
+		contents, err := ioutil.ReadFile(filepath.Join(GetMappingDirectory(), prefix+containerId))
+		if err != nil {
+			continue
+		}
 
-/usr/share/applications/slack.desktop:MimeType=x-scheme-handler/slack;
-Exec=/usr/bin/slack %U
+		for _, line := range strings.Split(string(contents), "\n") {
+			if parts := strings.SplitN(line, "=", 2); len(parts) == 2 && MatchesCommand(parts[1], command) {
+				return true
+			}
+		}
+	}
 
-*/
+	return false
+}